@@ -0,0 +1,165 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isArchive reports whether path names a migrator archive rather than a directory
+func isArchive(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// openArchive reads a .zip, .tar.gz or .tgz archive of migrators into memory.  when every entry of the archive
+// lies within a single top level directory, that directory is treated as the root.
+func openArchive(archivePath string) (fs.FS, error) {
+	content, err := os.ReadFile(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read migrator archive '%s': %w", archivePath, err)
+	}
+
+	var fsys fs.FS
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		fsys, err = zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	} else {
+		fsys, err = readTarGz(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open migrator archive '%s': %w", archivePath, err)
+	}
+
+	return archiveRoot(fsys)
+}
+
+// memoryFS returns a read only file system of files held in memory, keyed by their slash separated paths.  they
+// are stored, uncompressed, in a zip archive, whose reader is the file system.
+func memoryFS(files map[string][]byte) (fs.FS, error) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, data := range files {
+		file, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			return nil, err
+		}
+		_, err = file.Write(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+// readTarGz loads the regular files of a gzipped tarball into an in memory file system
+func readTarGz(content []byte) (fs.FS, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(strings.TrimPrefix(header.Name, "/"))] = data
+	}
+
+	return memoryFS(files)
+}
+
+// archiveRoot descends into the top level directory of an archive when it is the archive's only entry
+func archiveRoot(fsys fs.FS) (fs.FS, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return fsys, nil
+	}
+
+	return fs.Sub(fsys, entries[0].Name())
+}
+
+// migratorFS returns the file system holding the migrators, which is the archive when one was given
+func migratorFS(config *Config) fs.FS {
+	if config.FS != nil {
+		return config.FS
+	}
+	return os.DirFS(config.Directory)
+}
+
+// fsPath converts the path of a file beneath the migrator directory to its path within migratorFS
+func fsPath(config *Config, filePath string) (string, error) {
+	rel, err := filepath.Rel(config.Directory, filePath)
+	if err != nil {
+		return "", fmt.Errorf("'%s' is not within the migrator directory: %w", filePath, err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// readMigratorFile reads the file at path beneath the migrator directory
+func readMigratorFile(config *Config, filePath string) ([]byte, error) {
+	name, err := fsPath(config, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(migratorFS(config), name)
+}
+
+// statMigratorFile describes the file at path beneath the migrator directory
+func statMigratorFile(config *Config, filePath string) (fs.FileInfo, error) {
+	name, err := fsPath(config, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(migratorFS(config), name)
+}
+
+// globMigratorFiles returns the paths of the files directly within dir, beneath the migrator directory, whose
+// names match pattern
+func globMigratorFiles(config *Config, dir string, pattern string) ([]string, error) {
+	name, err := fsPath(config, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	globbed, err := fs.Glob(migratorFS(config), path.Join(name, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]string, 0, len(globbed))
+	for _, match := range globbed {
+		matches = append(matches, filepath.Join(config.Directory, filepath.FromSlash(match)))
+	}
+	return matches, nil
+}
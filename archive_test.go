@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+var archivedMigrators = map[string]string{
+	"migrations/0001_make_table.sql":      "CREATE TABLE {{ .EVO_TEST_TABLE }} (id INT);",
+	"migrations/0002_add_column.sql":      "ALTER TABLE {{ .EVO_TEST_TABLE }} ADD COLUMN name TEXT;",
+	"migrations/0002_add_column.down.sql": "ALTER TABLE {{ .EVO_TEST_TABLE }} DROP COLUMN name;",
+	"migrations/staging/0003_seed.sql":    "INSERT INTO {{ .EVO_TEST_TABLE }} (id, name) VALUES (1, 'staging');",
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(gz)
+	for name, content := range files {
+		err := writer.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		assert.NoError(t, err)
+		_, err = writer.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestOpenArchive(t *testing.T) {
+	t.Setenv("EVO_TEST_TABLE", "archived")
+	dir := t.TempDir()
+
+	for name, content := range map[string][]byte{
+		"migrations.zip":    zipArchive(t, archivedMigrators),
+		"migrations.tar.gz": tarGzArchive(t, archivedMigrators),
+	} {
+		archivePath := filepath.Join(dir, name)
+		err := os.WriteFile(archivePath, content, 0o644)
+		assert.NoError(t, err)
+		assert.True(t, isArchive(archivePath))
+
+		fsys, err := openArchive(archivePath)
+		assert.NoError(t, err)
+		config := &Config{
+			Directory: archivePath,
+			FS:        fsys,
+			Env:       "staging",
+		}
+
+		// the single top level directory is the root, and down files are not migrators
+		matches, err := findMigrators(config)
+		assert.NoError(t, err)
+		migNames := make([]string, 0, len(matches))
+		for _, match := range matches {
+			migNames = append(migNames, migratorName(config, match))
+		}
+		assert.Equal(t, []string{"0001_make_table.sql", "0002_add_column.sql", "staging/0003_seed.sql"}, migNames, name)
+
+		data, err := getTemplateData(config)
+		assert.NoError(t, err)
+		rendered, err := renderMigrator(config, matches[0], migNames[0], data)
+		assert.NoError(t, err)
+		assert.Equal(t, "CREATE TABLE archived (id INT);", rendered.SQL)
+
+		_, err = statMigratorFile(config, downPath(matches[1]))
+		assert.NoError(t, err)
+	}
+}
+
+func TestMigrateFromArchive(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	t.Setenv("EVO_TEST_TABLE", "archived")
+	content := zipArchive(t, archivedMigrators)
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	assert.NoError(t, err)
+	config.FS, err = archiveRoot(reader)
+	assert.NoError(t, err)
+	config.Directory = "migrations.zip"
+	config.Env = "staging"
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{
+		"0001_make_table.sql":   {},
+		"0002_add_column.sql":   {},
+		"staging/0003_seed.sql": {},
+	}, pastMigrations)
+
+	var name string
+	err = standardConn.QueryRow(context.Background(), "SELECT name FROM archived WHERE id = 1").Scan(&name)
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", name)
+}
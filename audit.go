@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AuditAction is the kind of action recorded in evo_audit
+type AuditAction string
+
+const (
+	AuditStart         AuditAction = "start"
+	AuditSuccess       AuditAction = "success"
+	AuditFailure       AuditAction = "failure"
+	AuditRollback      AuditAction = "rollback"
+	AuditPasswordReset AuditAction = "password_reset"
+	// AuditPaused follows the start of a backfill which stopped at its maximum number of chunks, to resume later
+	AuditPaused AuditAction = "paused"
+)
+
+// auditTable returns the schema qualified name of the evo_audit table
+func auditTable(config *Config) string {
+	return pgx.Identifier{migrationSchema(config), "evo_audit"}.Sanitize()
+}
+
+// ensureAuditTable creates the evo_audit table, in which every action is recorded when EVO_AUDIT is set
+func ensureAuditTable(conn *pgx.Conn, config *Config) error {
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id BIGSERIAL PRIMARY KEY, created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(), action TEXT NOT NULL, migrator TEXT, actor TEXT NOT NULL, error TEXT)", auditTable(config)))
+	if err != nil {
+		return fmt.Errorf("unable to create evo audit table: %w", err)
+	}
+
+	if len(config.SystemTableOwner) > 0 {
+		return ensureTableOwner(conn, auditTable(config), config.SystemTableOwner)
+	}
+	return nil
+}
+
+// writeAudit records an action taken by actor, against a migrator when migName is not empty, along with the error
+// it failed with, if any.  the audit trail accompanies the actions rather than gating them, so a failure to write
+// it is logged and otherwise ignored.
+func writeAudit(conn Executable, config *Config, action AuditAction, migName string, actor string, actionErr error) {
+	if !config.Audit {
+		return
+	}
+
+	var migrator, errText *string
+	if len(migName) > 0 {
+		migrator = &migName
+	}
+	if actionErr != nil {
+		text := actionErr.Error()
+		errText = &text
+	}
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("INSERT INTO %s (action, migrator, actor, error) VALUES ($1, $2, $3, $4)", auditTable(config)), string(action), migrator, actor, errText)
+	if err != nil {
+		logf("unable to record %s of migrator '%s' in the audit table: %s\n", action, migName, err.Error())
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestAudit(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Audit = true
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE accounts (id INT);",
+		"0002_broken.sql":     "INSERT INTO missing VALUES (1);",
+	})
+	err = doMigration(config, nil)
+	assert.Error(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"0001_make_table.sql": {}}, pastMigrations)
+
+	rows, err := conn.Query(context.Background(), "SELECT action, migrator, actor, coalesce(error, '') FROM evo_audit ORDER BY id")
+	assert.NoError(t, err)
+	type auditRow struct {
+		action, migrator, actor, err string
+	}
+	var audited []auditRow
+	for rows.Next() {
+		var row auditRow
+		assert.NoError(t, rows.Scan(&row.action, &row.migrator, &row.actor, &row.err))
+		audited = append(audited, row)
+	}
+	rows.Close()
+	assert.NoError(t, rows.Err())
+
+	assert.Len(t, audited, 4)
+	assert.Equal(t, auditRow{"start", "0001_make_table.sql", config.Username, ""}, audited[0])
+	assert.Equal(t, auditRow{"success", "0001_make_table.sql", config.Username, ""}, audited[1])
+	assert.Equal(t, auditRow{"start", "0002_broken.sql", config.Username, ""}, audited[2])
+	assert.Equal(t, "failure", audited[3].action)
+	assert.Equal(t, "0002_broken.sql", audited[3].migrator)
+	assert.Contains(t, audited[3].err, "missing")
+}
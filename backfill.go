@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// DirectiveBackfill makes a migrator a backfill over the integer column of a table, e.g.
+	// "-- evo: backfill=accounts.id".  its single statement is executed once per chunk of ids, with the exclusive
+	// lower and inclusive upper bounds of the chunk as $1 and $2, each chunk committed along with the progress.
+	DirectiveBackfill string = "backfill"
+	// DirectiveBackfillChunk sets the number of ids per chunk of a backfill, e.g. "-- evo: backfill-chunk=5000"
+	DirectiveBackfillChunk string = "backfill-chunk"
+	// DirectiveBackfillMaxChunks bounds the chunks of a backfill executed per run, e.g.
+	// "-- evo: backfill-max-chunks=100", pausing the run until the next once reached
+	DirectiveBackfillMaxChunks string = "backfill-max-chunks"
+)
+
+// defaultBackfillChunk is the number of ids per chunk when a backfill does not set one
+const defaultBackfillChunk = 1000
+
+// backfillParameter matches the positional parameters of the statement of a backfill
+var backfillParameter = regexp.MustCompile(`\$([0-9]+)`)
+
+// BackfillSpec describes a backfill migrator, as set by its directives
+type BackfillSpec struct {
+	// Table is the, optionally schema qualified, table whose Column ranges the chunks
+	Table  []string
+	Column string
+	Chunk  int64
+	// MaxChunks, when not 0, is the number of chunks executed per run
+	MaxChunks int
+}
+
+// parseBackfill returns the backfill described by the directives of a migrator, or nil when it is not a backfill.
+// the migrator must be a single statement taking the bounds of a chunk as $1 and $2, and no other parameters.
+func parseBackfill(sql string) (*BackfillSpec, error) {
+	directives := parseDirectives(sql)
+	target, ok := directives[DirectiveBackfill]
+	if !ok {
+		return nil, nil
+	}
+
+	parts := strings.Split(target, ".")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return nil, fmt.Errorf("backfill target '%s' must be a table and column, e.g. accounts.id", target)
+	}
+	spec := &BackfillSpec{
+		Table:  parts[:len(parts)-1],
+		Column: parts[len(parts)-1],
+		Chunk:  defaultBackfillChunk,
+	}
+
+	if value, ok := directives[DirectiveBackfillChunk]; ok {
+		chunk, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || chunk < 1 {
+			return nil, fmt.Errorf("backfill chunk '%s' must be a positive number of ids", value)
+		}
+		spec.Chunk = chunk
+	}
+	if value, ok := directives[DirectiveBackfillMaxChunks]; ok {
+		maxChunks, err := strconv.Atoi(value)
+		if err != nil || maxChunks < 1 {
+			return nil, fmt.Errorf("backfill max chunks '%s' must be a positive number of chunks", value)
+		}
+		spec.MaxChunks = maxChunks
+	}
+
+	statements := 0
+	for _, statement := range splitStatements(sql) {
+		if len(stripComments(statement)) > 0 {
+			statements++
+		}
+	}
+	if statements != 1 {
+		return nil, fmt.Errorf("a backfill must be a single statement, found %d", statements)
+	}
+	parameters := map[string]struct{}{}
+	for _, match := range backfillParameter.FindAllStringSubmatch(sql, -1) {
+		parameters[match[1]] = struct{}{}
+	}
+	_, lower := parameters["1"]
+	_, upper := parameters["2"]
+	if len(parameters) != 2 || !lower || !upper {
+		return nil, fmt.Errorf("a backfill statement must take the bounds of each chunk as $1 and $2, and no other parameters")
+	}
+
+	return spec, nil
+}
+
+// backfillTable returns the schema qualified name of the evo_backfill table
+func backfillTable(config *Config) string {
+	return pgx.Identifier{migrationSchema(config), "evo_backfill"}.Sanitize()
+}
+
+// ensureBackfillTable creates the evo_backfill table, recording the last id processed by each unfinished backfill
+func ensureBackfillTable(conn *pgx.Conn, config *Config) error {
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (migrator TEXT PRIMARY KEY, last_id BIGINT NOT NULL, updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW())", backfillTable(config)))
+	if err != nil {
+		return fmt.Errorf("unable to create evo backfill table: %w", err)
+	}
+
+	if len(config.SystemTableOwner) > 0 {
+		return ensureTableOwner(conn, backfillTable(config), config.SystemTableOwner)
+	}
+	return nil
+}
+
+// runBackfill executes a backfill migrator chunk by chunk, from the id following the last one recorded in
+// evo_backfill (or the lowest id of the table) up to the highest id of the table as the run starts.  each chunk
+// commits with the progress, so that a later run resumes from it.  once the last chunk is done the migrator is
+// recorded as applied.  reports false when the run reached the maximum number of chunks before finishing.
+func runBackfill(conn *pgx.Conn, config *Config, rendered *RenderedMigrator, migName string) (bool, error) {
+	spec := rendered.Backfill
+	err := ensureBackfillTable(conn, config)
+	if err != nil {
+		return false, err
+	}
+
+	table := pgx.Identifier(spec.Table).Sanitize()
+	column := pgx.Identifier{spec.Column}.Sanitize()
+	var lowest, highest *int64
+	err = conn.QueryRow(context.Background(), fmt.Sprintf("SELECT min(%s)::BIGINT - 1, max(%s)::BIGINT FROM %s", column, column, table)).Scan(&lowest, &highest)
+	if err != nil {
+		return false, fmt.Errorf("unable to find the range of %s.%s to backfill: %w", strings.Join(spec.Table, "."), spec.Column, err)
+	}
+
+	var last int64
+	err = conn.QueryRow(context.Background(), fmt.Sprintf("SELECT last_id FROM %s WHERE migrator = $1", backfillTable(config)), migName).Scan(&last)
+	switch {
+	case err == nil:
+		logf("migrator '%s': resuming backfill after id %d\n", migName, last)
+	case errors.Is(err, pgx.ErrNoRows):
+		if lowest != nil {
+			last = *lowest
+		}
+	default:
+		return false, fmt.Errorf("unable to read the progress of backfill '%s': %w", migName, err)
+	}
+
+	chunks := 0
+	for highest != nil && last < *highest {
+		if spec.MaxChunks > 0 && chunks == spec.MaxChunks {
+			logf("migrator '%s': backfill paused after id %d of %d, the next run resumes it\n", migName, last, *highest)
+			return false, nil
+		}
+
+		upper := min(last+spec.Chunk, *highest)
+		err = withTransaction(conn, func(tx pgx.Tx) error {
+			_, err := tx.Exec(context.Background(), rendered.SQL, last, upper)
+			if err != nil {
+				return fmt.Errorf("chunk of ids %d to %d failed: %w", last+1, upper, schemaPermissionHint(config, err))
+			}
+			_, err = tx.Exec(context.Background(), fmt.Sprintf("INSERT INTO %s (migrator, last_id) VALUES ($1, $2) ON CONFLICT (migrator) DO UPDATE SET last_id = EXCLUDED.last_id, updated_at = NOW()", backfillTable(config)), migName, upper)
+			if err != nil {
+				return fmt.Errorf("unable to record the progress of backfill '%s': %w", migName, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+		logf("migrator '%s': backfilled ids up to %d of %d\n", migName, upper, *highest)
+		last = upper
+		chunks++
+	}
+
+	err = withTransaction(conn, func(tx pgx.Tx) error {
+		err := recordMigrator(tx, config, migName, rendered.Checksum)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), fmt.Sprintf("DELETE FROM %s WHERE migrator = $1", backfillTable(config)), migName)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to record backfill '%s': %w", migName, err)
+	}
+	return true, nil
+}
+
+// withTransaction calls fn within a transaction, which is committed unless fn fails
+func withTransaction(conn *pgx.Conn, fn func(tx pgx.Tx) error) error {
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		_ = tx.Rollback(context.Background())
+		return err
+	}
+	return tx.Commit(context.Background())
+}
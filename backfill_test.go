@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseBackfill(t *testing.T) {
+	spec, err := parseBackfill("-- evo: backfill=billing.accounts.id\n-- evo: backfill-chunk=500\n-- evo: backfill-max-chunks=4\nUPDATE billing.accounts SET seen = true WHERE id BETWEEN $1 + 1 AND $2;")
+	assert.NoError(t, err)
+	assert.Equal(t, &BackfillSpec{Table: []string{"billing", "accounts"}, Column: "id", Chunk: 500, MaxChunks: 4}, spec)
+
+	spec, err = parseBackfill("-- evo: backfill=accounts.id\nUPDATE accounts SET seen = true WHERE id BETWEEN $1 + 1 AND $2;")
+	assert.NoError(t, err)
+	assert.Equal(t, &BackfillSpec{Table: []string{"accounts"}, Column: "id", Chunk: defaultBackfillChunk}, spec)
+
+	spec, err = parseBackfill("UPDATE accounts SET seen = true;")
+	assert.NoError(t, err)
+	assert.Nil(t, spec)
+
+	_, err = parseBackfill("-- evo: backfill=accounts")
+	assert.Error(t, err)
+	_, err = parseBackfill("-- evo: backfill=accounts.id\n-- evo: backfill-chunk=0")
+	assert.Error(t, err)
+
+	// the statement is checked before the backfill runs
+	_, err = parseBackfill("-- evo: backfill=accounts.id\nUPDATE accounts SET seen = true WHERE id BETWEEN $1 + 1 AND $2;\nUPDATE accounts SET seen = false;")
+	assert.ErrorContains(t, err, "single statement, found 2")
+	_, err = parseBackfill("-- evo: backfill=accounts.id\nUPDATE accounts SET seen = true WHERE id > $1;")
+	assert.ErrorContains(t, err, "$1 and $2")
+	_, err = parseBackfill("-- evo: backfill=accounts.id\nUPDATE accounts SET seen = $3 WHERE id BETWEEN $1 + 1 AND $2;")
+	assert.ErrorContains(t, err, "$1 and $2")
+}
+
+func TestBackfillDirectives(t *testing.T) {
+	dir := t.TempDir()
+	writeMigrators(t, dir, map[string]string{
+		"0001_role.sql":     "-- evo: backfill=accounts.id\n-- evo: role=app_owner\nUPDATE accounts SET seen = true WHERE id BETWEEN $1 + 1 AND $2;",
+		"0002_triggers.sql": "-- evo: backfill=accounts.id\n-- evo: disable-triggers=accounts\nUPDATE accounts SET seen = true WHERE id BETWEEN $1 + 1 AND $2;",
+	})
+	config := &Config{Directory: dir}
+	for _, name := range []string{"0001_role.sql", "0002_triggers.sql"} {
+		_, err := renderMigrator(config, filepath.Join(dir, name), name, map[string]any{})
+		assert.ErrorContains(t, err, "is a backfill", name)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE accounts (id INT PRIMARY KEY, name TEXT, name_lower TEXT);\nINSERT INTO accounts SELECT i, 'Name' || i FROM generate_series(1, 10) i;",
+		"0002_backfill.sql": `-- evo: backfill=accounts.id
+-- evo: backfill-chunk=3
+-- evo: backfill-max-chunks=2
+UPDATE accounts SET name_lower = lower(name) WHERE id BETWEEN $1 + 1 AND $2;`,
+		"0003_not_null.sql": "ALTER TABLE accounts ALTER COLUMN name_lower SET NOT NULL;",
+	})
+
+	// the first run stops after two chunks, holding back the migrator which relies on the backfill
+	config.Audit = true
+	var stages []ProgressStage
+	config.Progress = func(event ProgressEvent) {
+		if event.Migrator == "0002_backfill.sql" {
+			stages = append(stages, event.Stage)
+		}
+	}
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []ProgressStage{ProgressMigratorStarted, ProgressMigratorPaused}, stages)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	var filled, lastID int
+	err = conn.QueryRow(context.Background(), "SELECT count(name_lower) FROM accounts").Scan(&filled)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, filled)
+	err = conn.QueryRow(context.Background(), "SELECT last_id FROM evo_backfill WHERE migrator = '0002_backfill.sql'").Scan(&lastID)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, lastID)
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"0001_make_table.sql": {}}, pastMigrations)
+	rows, err := conn.Query(context.Background(), "SELECT action FROM evo_audit WHERE migrator = '0002_backfill.sql' ORDER BY id")
+	assert.NoError(t, err)
+	actions, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"start", "paused"}, actions)
+
+	// the second resumes from the checkpoint and finishes, applying the rest
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	err = conn.QueryRow(context.Background(), "SELECT count(name_lower) FROM accounts WHERE name_lower = lower(name)").Scan(&filled)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, filled)
+	pastMigrations, err = getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 3)
+	var remaining int
+	err = conn.QueryRow(context.Background(), "SELECT count(*) FROM evo_backfill").Scan(&remaining)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DirectiveBatch executes the statements of a migrator in batches, each in a round trip of its own, e.g.
+// "-- evo: batch" for batches of EVO_BATCH_SIZE statements, or "-- evo: batch=500"
+const DirectiveBatch string = "batch"
+
+// defaultBatchSize is the number of statements per batch when EVO_BATCH_SIZE is not set
+const defaultBatchSize = 1000
+
+// parseBatchSize returns the number of statements per batch of a migrator carrying the batch directive, or 0 when
+// it does not carry it
+func parseBatchSize(config *Config, sql string) (int, error) {
+	value, ok := parseDirectives(sql)[DirectiveBatch]
+	if !ok {
+		return 0, nil
+	}
+	if len(value) == 0 {
+		if config.BatchSize > 0 {
+			return config.BatchSize, nil
+		}
+		return defaultBatchSize, nil
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("batch size '%s' must be a positive number of statements", value)
+	}
+	return size, nil
+}
+
+// execBatches executes the rendered migrator in batches of rendered.BatchSize statements, logging progress after
+// each.  within a transaction the batches share it, so the migrator still commits or fails as a whole.
+func execBatches(conn Executable, config *Config, rendered *RenderedMigrator, migName string) error {
+	statements := splitStatements(rendered.SQL)
+	batches := (len(statements) + rendered.BatchSize - 1) / rendered.BatchSize
+	for i := 0; i < len(statements); i += rendered.BatchSize {
+		end := min(i+rendered.BatchSize, len(statements))
+		batch := i/rendered.BatchSize + 1
+		_, err := conn.Exec(context.Background(), strings.Join(statements[i:end], ";\n"))
+		if err != nil {
+			return fmt.Errorf("batch %d of %d (statements %d to %d) failed: %w", batch, batches, i+1, end, schemaPermissionHint(config, err))
+		}
+		logf("migrator '%s': batch %d of %d applied (%d of %d statements)\n", migName, batch, batches, end, len(statements))
+	}
+
+	return nil
+}
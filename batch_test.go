@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseBatchSize(t *testing.T) {
+	size, err := parseBatchSize(&Config{}, "CREATE TABLE things (id INT);")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, size)
+
+	size, err = parseBatchSize(&Config{}, "-- evo: batch\nINSERT INTO things VALUES (1);")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultBatchSize, size)
+
+	size, err = parseBatchSize(&Config{BatchSize: 50}, "-- evo: batch\nINSERT INTO things VALUES (1);")
+	assert.NoError(t, err)
+	assert.Equal(t, 50, size)
+
+	size, err = parseBatchSize(&Config{BatchSize: 50}, "-- evo: batch=10\nINSERT INTO things VALUES (1);")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, size)
+
+	_, err = parseBatchSize(&Config{}, "-- evo: batch=0\nINSERT INTO things VALUES (1);")
+	assert.ErrorContains(t, err, "must be a positive number of statements")
+}
+
+func TestBatches(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	var inserts strings.Builder
+	for i := 1; i <= 95; i++ {
+		fmt.Fprintf(&inserts, "INSERT INTO things VALUES (%d);\n", i)
+	}
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT PRIMARY KEY);",
+		"0002_seed.sql":       "-- evo: batch=10\n" + inserts.String(),
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	var count int
+	err = conn.QueryRow(context.Background(), "SELECT count(*) FROM things").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 95, count)
+
+	// a failing batch rolls back the batches applied before it along with the rest of the migrator
+	writeMigrators(t, config.Directory, map[string]string{
+		"0003_seed_more.sql": "-- evo: batch=10\n" + strings.ReplaceAll(inserts.String(), "VALUES (", "VALUES (1000 + ") + "INSERT INTO things VALUES (1);\n",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "batch 10 of 10 (statements 91 to 96) failed")
+
+	err = conn.QueryRow(context.Background(), "SELECT count(*) FROM things").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 95, count)
+
+	// batches and savepoints are exclusive
+	writeMigrators(t, config.Directory, map[string]string{
+		"0003_seed_more.sql": "-- evo: batch\n-- evo: savepoints\nINSERT INTO things VALUES (2000);",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "may not use both savepoints and batches")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+)
+
+type CheckResult struct {
+	Name string
+	Err  error
+}
+
+// checkMigrators parses, renders and validates every migrator without connecting to a database
+func checkMigrators(config *Config) ([]CheckResult, error) {
+	matches, err := findMigrators(config)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := getTemplateData(config)
+	if err != nil {
+		return nil, err
+	}
+
+	migNames := make([]string, 0, len(matches))
+	results := make([]CheckResult, 0, len(matches))
+	for _, match := range matches {
+		migName := migratorName(config, match)
+		migNames = append(migNames, migName)
+
+		rendered, err := renderMigrator(config, match, migName, data)
+		if err == nil {
+			var transactional bool
+			transactional, err = isTransactional(config, match, rendered.SQL)
+			if err == nil && transactional {
+				err = validateTransactionalSQL(migName, rendered.SQL)
+			}
+		}
+		results = append(results, CheckResult{
+			Name: migName,
+			Err:  err,
+		})
+	}
+
+	err = validateVersions(config, migNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func runCheck(config *Config, args []string) error {
+	results, err := checkMigrators(config)
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failures++
+			fmt.Printf("FAIL  %s: %s\n", result.Name, result.Err.Error())
+			continue
+		}
+		fmt.Printf("PASS  %s\n", result.Name)
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d migrators failed checks", failures, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMigrators(t *testing.T) {
+	config := &Config{Directory: t.TempDir()}
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_good.sql":         "CREATE TABLE {{ .EVO_CHECK_TABLE }} (id INT);",
+		"0002_parse_error.sql":  "CREATE TABLE {{ .EVO_CHECK_TABLE ;",
+		"0003_render_error.sql": "CREATE TABLE {{ index .EVO_CHECK_TABLE 100 }} (id INT);",
+	})
+	t.Setenv("EVO_CHECK_TABLE", "checked")
+
+	results, err := checkMigrators(config)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+
+	assert.Equal(t, "0001_good.sql", results[0].Name)
+	assert.NoError(t, results[0].Err)
+
+	assert.Equal(t, "0002_parse_error.sql", results[1].Name)
+	assert.ErrorContains(t, results[1].Err, "unable to parse migrator as template")
+
+	assert.Equal(t, "0003_render_error.sql", results[2].Name)
+	assert.ErrorContains(t, results[2].Err, "error executing template")
+
+	err = runCheck(config, nil)
+	assert.ErrorContains(t, err, "2 of 3 migrators failed checks")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// appliedChecksum pairs an applied migrator in the directory with its checksum, as stored and as computed from
+// the current file
+type appliedChecksum struct {
+	migName  string
+	key      string
+	stored   *string
+	computed string
+}
+
+// getAppliedChecksums computes the checksum of every applied migrator present in the directory, alongside the
+// checksum stored when it was applied.  a migrator which can no longer be rendered is logged and skipped.
+func getAppliedChecksums(conn *pgx.Conn, config *Config) ([]appliedChecksum, error) {
+	exists, err := migratorTableExists(conn, config)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	records, err := getMigrationRecords(conn, config)
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]*string{}
+	for _, record := range records {
+		key := record.Migrator
+		if record.Version != nil {
+			key = formatVersion(record.Version)
+		}
+		stored[key] = record.Checksum
+	}
+
+	matches, err := findMigrators(config)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := getTemplateData(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var checksums []appliedChecksum
+	for _, match := range matches {
+		migName := migratorName(config, match)
+		key, err := migratorKey(config, migName)
+		if err != nil {
+			return nil, err
+		}
+		storedChecksum, ok := stored[key]
+		if !ok {
+			continue
+		}
+
+		rendered, err := renderMigrator(config, match, migName, data)
+		if err != nil {
+			logf("unable to compute checksum of migrator '%s': %s\n", migName, err.Error())
+			continue
+		}
+		checksums = append(checksums, appliedChecksum{
+			migName:  migName,
+			key:      key,
+			stored:   storedChecksum,
+			computed: rendered.Checksum,
+		})
+	}
+
+	return checksums, nil
+}
+
+// findDrift returns the applied migrators whose file has changed since they were applied.  migrators applied
+// without a checksum cannot be checked, see checksum-backfill.
+func findDrift(conn *pgx.Conn, config *Config) ([]string, error) {
+	checksums, err := getAppliedChecksums(conn, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifted []string
+	for _, checksum := range checksums {
+		if checksum.stored != nil && *checksum.stored != checksum.computed {
+			drifted = append(drifted, checksum.migName)
+		}
+	}
+
+	return drifted, nil
+}
+
+// backfillChecksums stores the checksum of the current file of each applied migrator which was applied without
+// one, returning the migrators which were backfilled
+func backfillChecksums(conn *pgx.Conn, config *Config) ([]string, error) {
+	err := upgradeMigratorTable(conn, config)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums, err := getAppliedChecksums(conn, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []appliedChecksum
+	for _, checksum := range checksums {
+		if checksum.stored == nil {
+			missing = append(missing, checksum)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	for _, checksum := range missing {
+		logf("backfilling checksum of migrator '%s'\n", checksum.migName)
+	}
+	err = requireConfirmation(config, fmt.Sprintf("store the checksums of %d applied migrators, computed from their current files", len(missing)))
+	if err != nil {
+		return nil, err
+	}
+
+	keyColumn := "migrator"
+	if config.TrackBy == TrackByVersion {
+		keyColumn = "version"
+	}
+
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	var backfilled []string
+	for _, checksum := range missing {
+		var key any = checksum.key
+		if config.TrackBy == TrackByVersion {
+			key, err = strconv.ParseInt(checksum.key, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = tx.Exec(context.Background(), fmt.Sprintf("UPDATE %s SET checksum = $1 WHERE %s = $2 AND checksum IS NULL", migratorTable(config), keyColumn), checksum.computed, key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to store checksum of migrator '%s': %w", checksum.migName, err)
+		}
+		backfilled = append(backfilled, checksum.migName)
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("unable to commit checksum backfill: %w", err)
+	}
+
+	return backfilled, nil
+}
+
+func runChecksumBackfill(config *Config, args []string) error {
+	flags := flag.NewFlagSet("checksum-backfill", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "store the checksums without asking for confirmation")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	config.Confirmed = *yes
+
+	logf("connecting to database '%s' as user '%s'\n", config.Database, config.Username)
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	if err != nil {
+		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	err = setSessionRole(conn, config)
+	if err != nil {
+		return err
+	}
+
+	backfilled, err := backfillChecksums(conn, config)
+	if err != nil {
+		return err
+	}
+	logf("%d checksums backfilled\n", len(backfilled))
+
+	return nil
+}
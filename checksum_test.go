@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestChecksumBackfill(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+	defer func(interactive func() bool) {
+		isInteractive = interactive
+	}(isInteractive)
+	isInteractive = func() bool { return false }
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql":  "CREATE TABLE first (id INT);",
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	// as though the migrators had been applied before checksums were recorded
+	_, err = conn.Exec(context.Background(), "UPDATE evo_mg SET checksum = NULL")
+	assert.NoError(t, err)
+
+	_, err = backfillChecksums(conn, config)
+	assert.ErrorContains(t, err, "--yes")
+
+	config.Confirmed = true
+	backfilled, err := backfillChecksums(conn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001_first.sql", "0002_second.sql"}, backfilled)
+
+	var stored string
+	err = conn.QueryRow(context.Background(), "SELECT checksum FROM evo_mg WHERE migrator = '0002_second.sql'").Scan(&stored)
+	assert.NoError(t, err)
+	assert.Equal(t, checksum("CREATE TABLE second (id INT);"), stored)
+
+	// nothing is left to backfill, and an edit is now detected
+	backfilled, err = backfillChecksums(conn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, backfilled)
+
+	drifted, err := findDrift(conn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, drifted)
+
+	err = os.WriteFile(filepath.Join(config.Directory, "0001_first.sql"), []byte("CREATE TABLE first (id BIGINT);"), 0o644)
+	assert.NoError(t, err)
+	drifted, err = findDrift(conn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001_first.sql"}, drifted)
+}
+
+func TestRunValuesNotDrifted(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	config.RunID = "build-1"
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE runs (id TEXT, at TIMESTAMPTZ);",
+		"0002_record_run.sql": "INSERT INTO runs VALUES ('{{ .RunID }}', '{{ .Now.Format \"2006-01-02T15:04:05Z07:00\" }}');",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// a later invocation renders the migrator differently, which is not drift
+	config.RunID = "build-2"
+	config.Clock = func() time.Time {
+		return time.Now().Add(time.Hour)
+	}
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	drifted, err := findDrift(conn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, drifted)
+}
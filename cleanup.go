@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+const cleanupSuffix string = ".cleanup.sql"
+
+// companionSuffixes are the suffixes of files which accompany a migrator, and are not migrators themselves
+var companionSuffixes = []string{cleanupSuffix, downSuffix}
+
+// isCompanion reports whether path is a file accompanying a migrator rather than a migrator
+func isCompanion(path string) bool {
+	for _, suffix := range companionSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupPath returns the path of the cleanup file paired with the migrator at path
+func cleanupPath(path string) string {
+	return strings.TrimSuffix(path, ".sql") + cleanupSuffix
+}
+
+// runCleanup executes the cleanup file paired with a failed non-transactional migrator, if there is one.  this
+// is best effort, any error is logged rather than returned so that the original failure is reported.
+func runCleanup(conn Executable, config *Config, path string, migName string, data map[string]any) {
+	cleanup := cleanupPath(path)
+	_, err := statMigratorFile(config, cleanup)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		logf("unable to stat cleanup for migrator '%s': %s\n", migName, err.Error())
+		return
+	}
+
+	logf("executing cleanup for failed migrator '%s'...\n", migName)
+	rendered, err := renderMigrator(config, cleanup, migName, data)
+	if err != nil {
+		logf("unable to render cleanup for migrator '%s': %s\n", migName, err.Error())
+		return
+	}
+
+	_, err = conn.Exec(context.Background(), rendered.SQL)
+	if err != nil {
+		logf("error executing cleanup for migrator '%s': %s\n", migName, err.Error())
+		return
+	}
+	logf("cleanup for migrator '%s' complete\n", migName)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestCleanupOnFailedMigrator(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_partial_notrans.sql":         "CREATE TABLE partial (id INT);\nCOMMIT;\nSELECT * FROM does_not_exist;\n",
+		"0001_partial_notrans.cleanup.sql": "DROP TABLE IF EXISTS partial;\nCREATE TABLE cleaned_up (migrator TEXT);\nINSERT INTO cleaned_up VALUES ('{{ .MigratorName }}');\n",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "0001_partial_notrans.sql")
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	var migrator string
+	err = standardConn.QueryRow(context.Background(), "SELECT migrator FROM cleaned_up").Scan(&migrator)
+	assert.NoError(t, err)
+	assert.Equal(t, "0001_partial_notrans.sql", migrator)
+
+	var exists bool
+	err = standardConn.QueryRow(context.Background(), "SELECT to_regclass('partial') IS NOT NULL").Scan(&exists)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// the cleanup file is not a migrator in its own right
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, pastMigrations)
+}
+
+func TestCleanupFilesNotMigrators(t *testing.T) {
+	config := &Config{Directory: t.TempDir()}
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first_notrans.sql":         "SELECT 1;",
+		"0001_first_notrans.cleanup.sql": "SELECT 2;",
+	})
+
+	matches, err := findMigrators(config)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, "0001_first_notrans.sql", migratorName(config, matches[0]))
+	assert.Equal(t, "0001_first_notrans.cleanup.sql", migratorName(config, cleanupPath(matches[0])))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultConfigFile is read from the working directory, if present, when EVO_CONFIG is not set
+const defaultConfigFile string = "evo.json"
+
+// secretSettings may only be supplied by the environment, never by a config file checked into a repository
+var secretSettings = map[string]struct{}{
+	"EVO_DB_PASSWORD":       {},
+	"EVO_DB_ADMIN_PASSWORD": {},
+	// a command run by evo is not taken from a file which anyone able to commit to the repository may change
+	"EVO_PASSWORD_DECRYPT_CMD": {},
+}
+
+// configSettings are the settings of a config file, keyed by the environment variable each stands in for, with
+// a record of which have been looked up
+type configSettings struct {
+	values map[string]string
+	used   map[string]struct{}
+}
+
+// settingName maps a config file key to its environment variable, e.g. "db_host" to EVO_DB_HOST
+func settingName(key string) string {
+	return "EVO_" + strings.ToUpper(key)
+}
+
+// settingValue converts a json value to the string form of an environment variable.  booleans become 1 or 0 and
+// arrays become comma separated lists.
+func settingValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		entries := make([]string, 0, len(v))
+		for _, entry := range v {
+			entryStr, err := settingValue(entry)
+			if err != nil {
+				return "", err
+			}
+			entries = append(entries, entryStr)
+		}
+		return strings.Join(entries, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// loadConfigFile reads the config file named by EVO_CONFIG, or evo.json in the working directory if it exists.
+// keys are the names of environment variables without the EVO_ prefix, in lower case, e.g. "db_host".
+func loadConfigFile() (*configSettings, error) {
+	settings := &configSettings{
+		values: map[string]string{},
+		used:   map[string]struct{}{},
+	}
+
+	path := os.Getenv("EVO_CONFIG")
+	required := len(path) > 0
+	if !required {
+		path = defaultConfigFile
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file '%s': %w", path, err)
+	}
+
+	var values map[string]any
+	err = json.Unmarshal(content, &values)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config file '%s': %w", path, err)
+	}
+
+	for key, value := range values {
+		name := settingName(key)
+		if _, ok := secretSettings[name]; ok || strings.HasPrefix(name, secretPrefix) {
+			return nil, fmt.Errorf("config file '%s' must not contain the secret '%s', it is read from %s only", path, key, name)
+		}
+		settings.values[name], err = settingValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of '%s' in config file '%s': %w", key, path, err)
+		}
+	}
+
+	return settings, nil
+}
+
+// get returns the setting name, from the environment when it is set there and otherwise from the config file
+func (s *configSettings) get(name string) string {
+	s.used[name] = struct{}{}
+	if value := os.Getenv(name); len(value) > 0 {
+		return value
+	}
+	return s.values[name]
+}
+
+// checkUnknown returns an error naming the keys of the config file which correspond to no setting, most likely
+// misspelled
+func (s *configSettings) checkUnknown() error {
+	var unknown []string
+	for name := range s.values {
+		if _, ok := s.used[name]; !ok {
+			unknown = append(unknown, strings.ToLower(strings.TrimPrefix(name, "EVO_")))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown settings in config file: %s", strings.Join(unknown, ", "))
+}
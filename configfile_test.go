@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), "evo.json")
+	err := os.WriteFile(path, []byte(content), 0o644)
+	assert.NoError(t, err)
+	t.Setenv("EVO_CONFIG", path)
+}
+
+func TestConfigFile(t *testing.T) {
+	t.Setenv("EVO_DB_HOST", "")
+	t.Setenv("EVO_DB_USERNAME", "")
+	t.Setenv("EVO_DB_PASSWORD", "password")
+	t.Setenv("EVO_DB_ADMIN_PASSWORD", "admin")
+	writeConfigFile(t, `{
+	"db_host": "file-host:5432",
+	"db_database": "app",
+	"db_username": "app_user",
+	"db_admin_username": "admin",
+	"track_by": "version",
+	"fail_fast": true,
+	"parallel": 4,
+	"user_role_membership": ["readers", "writers"]
+}`)
+
+	config, err := getConfig(t.TempDir(), ConnectAdmin)
+	assert.NoError(t, err)
+	assert.Equal(t, "file-host:5432", config.Hostname)
+	assert.Equal(t, "app_user", config.Username)
+	assert.Equal(t, "password", config.Password)
+	assert.Equal(t, TrackByVersion, config.TrackBy)
+	assert.True(t, config.FailFast)
+	assert.Equal(t, 4, config.Parallel)
+	assert.Equal(t, []string{"readers", "writers"}, config.UserRoleMembership)
+
+	// the environment overrides the file
+	t.Setenv("EVO_DB_HOST", "env-host:5432")
+	config, err = getConfig(t.TempDir(), ConnectAdmin)
+	assert.NoError(t, err)
+	assert.Equal(t, "env-host:5432", config.Hostname)
+}
+
+func TestConfigFileRejected(t *testing.T) {
+	writeConfigFile(t, `{"db_password": "hunter2"}`)
+	_, err := getConfig(t.TempDir(), ConnectNone)
+	assert.ErrorContains(t, err, "must not contain the secret 'db_password'")
+
+	writeConfigFile(t, `{"db_hots": "localhost"}`)
+	_, err = getConfig(t.TempDir(), ConnectNone)
+	assert.ErrorContains(t, err, "unknown settings in config file: db_hots")
+
+	t.Setenv("EVO_CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+	_, err = getConfig(t.TempDir(), ConnectNone)
+	assert.ErrorContains(t, err, "unable to read config file")
+}
+
+func TestConfigFileS3Endpoint(t *testing.T) {
+	// the endpoint is only used by remote sources, but a shared file naming it serves local runs too
+	writeConfigFile(t, `{"s3_endpoint": "https://s3.example.com"}`)
+	_, err := getConfig(t.TempDir(), ConnectNone)
+	assert.NoError(t, err)
+}
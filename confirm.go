@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirmInput is read for an interactive confirmation
+var confirmInput io.Reader = os.Stdin
+
+// isInteractive reports whether a confirmation can be requested on stdin
+var isInteractive = func() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// destructiveStatements returns the statements of sql which drop or truncate objects
+func destructiveStatements(sql string) []string {
+	var destructive []string
+	for _, statement := range splitStatements(sql) {
+		keywords := statementKeywords(statement, 2)
+		if len(keywords) == 0 {
+			continue
+		}
+		switch keywords[0] {
+		case "DROP", "TRUNCATE":
+			destructive = append(destructive, strings.Join(keywords, " "))
+		}
+	}
+
+	return destructive
+}
+
+// confirm guards a destructive action when EVO_REQUIRE_CONFIRM is set
+func confirm(config *Config, action string) error {
+	if !config.RequireConfirm {
+		return nil
+	}
+	return requireConfirmation(config, action)
+}
+
+// requireConfirmation describes an action, which proceeds only when --yes was passed or, on a terminal, the
+// operator answers yes
+func requireConfirmation(config *Config, action string) error {
+	if config.Confirmed {
+		return nil
+	}
+
+	logf("about to %s\n", action)
+	if !isInteractive() {
+		return fmt.Errorf("refusing to %s without confirmation: pass --yes to proceed", action)
+	}
+
+	logf("proceed? [y/N] ")
+	answer, err := bufio.NewReader(confirmInput).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("unable to read confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+
+	return fmt.Errorf("refusing to %s, it was not confirmed", action)
+}
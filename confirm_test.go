@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestDestructiveStatements(t *testing.T) {
+	destructive := destructiveStatements(`-- drop the old table
+DROP TABLE old;
+CREATE TABLE new (id INT);
+INSERT INTO new VALUES (1); -- truncate nothing
+truncate audit;
+SELECT 'DROP TABLE new';`)
+	assert.Equal(t, []string{"DROP TABLE", "TRUNCATE AUDIT"}, destructive)
+	assert.Empty(t, destructiveStatements("CREATE TABLE new (id INT);"))
+}
+
+func TestConfirm(t *testing.T) {
+	defer func(interactive func() bool, input io.Reader) {
+		isInteractive = interactive
+		confirmInput = input
+	}(isInteractive, confirmInput)
+
+	isInteractive = func() bool { return false }
+	assert.NoError(t, confirm(&Config{}, "drop everything"))
+	assert.NoError(t, confirm(&Config{RequireConfirm: true, Confirmed: true}, "drop everything"))
+	assert.ErrorContains(t, confirm(&Config{RequireConfirm: true}, "drop everything"), "--yes")
+
+	isInteractive = func() bool { return true }
+	confirmInput = strings.NewReader("yes\n")
+	assert.NoError(t, confirm(&Config{RequireConfirm: true}, "drop everything"))
+	confirmInput = strings.NewReader("n\n")
+	assert.ErrorContains(t, confirm(&Config{RequireConfirm: true}, "drop everything"), "not confirmed")
+}
+
+func TestRequireConfirm(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	defer func(interactive func() bool) {
+		isInteractive = interactive
+	}(isInteractive)
+	isInteractive = func() bool { return false }
+
+	config.Directory = t.TempDir()
+	config.RequireConfirm = true
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_create.sql": "CREATE TABLE doomed (id INT);",
+		"0002_drop.sql":   "DROP TABLE doomed;",
+	})
+
+	// without a terminal to confirm on, the drop is blocked
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "0002_drop.sql")
+	assert.ErrorContains(t, err, "--yes")
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Contains(t, pastMigrations, "0001_create.sql")
+	assert.NotContains(t, pastMigrations, "0002_drop.sql")
+
+	config.Confirmed = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	pastMigrations, err = getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Contains(t, pastMigrations, "0002_drop.sql")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DirectiveCopy bulk loads a csv file into a table once the migrator has executed, e.g.
+// "-- evo: copy table=countries file=countries.csv columns=code,name header=true"
+const DirectiveCopy string = "copy"
+
+// CopySpec describes a csv file loaded into a table with COPY
+type CopySpec struct {
+	// Table is the, optionally schema qualified, table loaded
+	Table string
+	// File is the path of the csv file, resolved against the migrator's directory
+	File string
+	// Columns are the columns of the table the csv columns are loaded into, all of them when empty
+	Columns []string
+	// Header skips the first line of the file
+	Header bool
+}
+
+// parseCopies extracts the copy directives of a migrator at path, of which there may be several
+func parseCopies(path string, source string) ([]CopySpec, error) {
+	var copies []CopySpec
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, directivePrefix) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, directivePrefix))
+		if len(fields) == 0 || strings.ToLower(fields[0]) != DirectiveCopy {
+			continue
+		}
+
+		spec := CopySpec{}
+		for _, option := range fields[1:] {
+			name, value, _ := strings.Cut(option, "=")
+			switch strings.ToLower(name) {
+			case "table":
+				spec.Table = value
+			case "file":
+				spec.File = filepath.Join(filepath.Dir(path), filepath.FromSlash(value))
+			case "columns":
+				spec.Columns = splitList(value)
+			case "header":
+				spec.Header = value == "true"
+			default:
+				return nil, fmt.Errorf("unknown copy option '%s'", name)
+			}
+		}
+		if len(spec.Table) == 0 || len(spec.File) == 0 {
+			return nil, fmt.Errorf("copy directive '%s' requires both table= and file=", line)
+		}
+		copies = append(copies, spec)
+	}
+
+	return copies, nil
+}
+
+// copyStatement builds the COPY FROM STDIN statement loading spec
+func copyStatement(spec CopySpec) string {
+	columns := ""
+	if len(spec.Columns) > 0 {
+		sanitized := make([]string, 0, len(spec.Columns))
+		for _, column := range spec.Columns {
+			sanitized = append(sanitized, pgx.Identifier{column}.Sanitize())
+		}
+		columns = fmt.Sprintf(" (%s)", strings.Join(sanitized, ", "))
+	}
+
+	header := ""
+	if spec.Header {
+		header = ", HEADER true"
+	}
+
+	return fmt.Sprintf("COPY %s%s FROM STDIN WITH (FORMAT csv%s)", pgx.Identifier(strings.Split(spec.Table, ".")).Sanitize(), columns, header)
+}
+
+// pgConn returns the underlying connection of conn, on which a transaction's COPY also executes
+func pgConn(conn Executable) (*pgconn.PgConn, error) {
+	switch c := conn.(type) {
+	case *pgx.Conn:
+		return c.PgConn(), nil
+	case pgx.Tx:
+		return c.Conn().PgConn(), nil
+	}
+	return nil, fmt.Errorf("connection of type %T does not support COPY", conn)
+}
+
+// copyData loads each csv file of a migrator into its table
+func copyData(conn Executable, config *Config, migName string, copies []CopySpec) error {
+	if len(copies) == 0 {
+		return nil
+	}
+
+	pg, err := pgConn(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range copies {
+		content, err := readMigratorFile(config, spec.File)
+		if err != nil {
+			return fmt.Errorf("unable to read copy file '%s': %w", spec.File, err)
+		}
+
+		tag, err := pg.CopyFrom(context.Background(), bytes.NewReader(content), copyStatement(spec))
+		if err != nil {
+			return fmt.Errorf("unable to copy '%s' into table '%s': %w", spec.File, spec.Table, err)
+		}
+		logf("migrator '%s' copied %d rows into table '%s'\n", migName, tag.RowsAffected(), spec.Table)
+	}
+
+	return nil
+}
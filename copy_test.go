@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseCopies(t *testing.T) {
+	copies, err := parseCopies("/migrations/0002_seed.sql", `-- evo: copy table=countries file=countries.csv columns=code,name header=true
+-- evo: COPY table=ref.regions file=data/regions.csv
+INSERT INTO countries VALUES ('xx', 'nowhere');`)
+	assert.NoError(t, err)
+	assert.Equal(t, []CopySpec{
+		{Table: "countries", File: "/migrations/countries.csv", Columns: []string{"code", "name"}, Header: true},
+		{Table: "ref.regions", File: "/migrations/data/regions.csv"},
+	}, copies)
+
+	assert.Equal(t, `COPY "countries" ("code", "name") FROM STDIN WITH (FORMAT csv, HEADER true)`, copyStatement(copies[0]))
+	assert.Equal(t, `COPY "ref"."regions" FROM STDIN WITH (FORMAT csv)`, copyStatement(copies[1]))
+
+	_, err = parseCopies("/migrations/0002_seed.sql", "-- evo: copy table=countries")
+	assert.ErrorContains(t, err, "requires both")
+	_, err = parseCopies("/migrations/0002_seed.sql", "-- evo: copy table=countries file=countries.csv delimiter=;")
+	assert.ErrorContains(t, err, "unknown copy option 'delimiter'")
+}
+
+func TestCopyMigrator(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_countries.sql":      "-- evo: copy table=countries file=countries.csv columns=code,name header=true\nCREATE TABLE countries (id SERIAL PRIMARY KEY, code TEXT NOT NULL, name TEXT NOT NULL);",
+		"0002_broken_notrans.sql": "-- evo: copy table=countries file=missing.csv\nSELECT 1;",
+	})
+	err = os.WriteFile(filepath.Join(config.Directory, "countries.csv"), []byte("code,name\nfr,France\nde,Germany\nnz,\"New Zealand, Aotearoa\"\n"), 0o644)
+	assert.NoError(t, err)
+
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "missing.csv")
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	var count int
+	err = standardConn.QueryRow(context.Background(), "SELECT COUNT(*) FROM countries").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	var name string
+	err = standardConn.QueryRow(context.Background(), "SELECT name FROM countries WHERE code = 'nz'").Scan(&name)
+	assert.NoError(t, err)
+	assert.Equal(t, "New Zealand, Aotearoa", name)
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Contains(t, pastMigrations, "0001_countries.sql")
+	assert.NotContains(t, pastMigrations, "0002_broken_notrans.sql")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultDatabaseWaitTimeout is how long to wait for a missing database when EVO_WAIT_FOR_DATABASE_TIMEOUT is
+// not set
+const defaultDatabaseWaitTimeout = 5 * time.Minute
+
+// databasePollInterval is the delay between checks while waiting for a database to appear
+var databasePollInterval = 2 * time.Second
+
+// databaseExists reports whether the database name exists in the cluster
+func databaseExists(conn Queryable, name string) (bool, error) {
+	var exists bool
+	err := conn.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1)", name).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("unable to query database for existing database by name: %w", err)
+	}
+	return exists, nil
+}
+
+// isCreateDatabaseDisallowed reports whether CREATE DATABASE failed because the cluster does not permit it,
+// either for lack of privilege or because database creation is managed elsewhere
+func isCreateDatabaseDisallowed(err error) bool {
+	// insufficient_privilege, feature_not_supported
+	return hasCode(err, "42501", "0A000")
+}
+
+// waitForDatabase polls until the configured database appears, for a database provisioned asynchronously by
+// another system
+func waitForDatabase(conn Queryable, config *Config) error {
+	deadline := time.Now().Add(config.DatabaseWaitTimeout)
+	for {
+		exists, err := databaseExists(conn, config.Database)
+		if err != nil {
+			return err
+		}
+		if exists {
+			logf("database '%s' is available\n", config.Database)
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("database '%s' did not appear within %s", config.Database, config.DatabaseWaitTimeout)
+		}
+
+		logf("waiting for database '%s' to be provisioned\n", config.Database)
+		time.Sleep(databasePollInterval)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestWaitForDatabase(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	databasePollInterval = 100 * time.Millisecond
+	config.SkipCreateDatabase = true
+
+	// without waiting, a missing database is reported rather than created
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "EVO_SKIP_CREATE_DATABASE")
+
+	// another system provisions the database a little later
+	provisioned := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Second)
+		adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+		if err != nil {
+			provisioned <- err
+			return
+		}
+		defer func() {
+			_ = adminConn.Close(context.Background())
+		}()
+		_, err = adminConn.Exec(context.Background(), fmt.Sprintf("CREATE DATABASE %s", pgx.Identifier{config.Database}.Sanitize()))
+		provisioned <- err
+	}()
+
+	config.WaitForDatabase = true
+	config.DatabaseWaitTimeout = time.Minute
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, <-provisioned)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 5)
+}
+
+func TestWaitForDatabaseTimeout(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	databasePollInterval = 100 * time.Millisecond
+	config.SkipCreateDatabase = true
+	config.WaitForDatabase = true
+	config.DatabaseWaitTimeout = 500 * time.Millisecond
+
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "did not appear")
+}
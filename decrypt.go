@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// decryptTimeout bounds the time the password decrypt command may take
+const decryptTimeout = 30 * time.Second
+
+// decryptPassword runs command, its name and arguments separated by spaces (e.g. "age --decrypt -i key.txt"), with
+// the encrypted value on stdin, returning its stdout without the trailing newline as the password
+func decryptPassword(command string, setting string, encrypted string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return encrypted, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), decryptTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(encrypted)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); len(message) > 0 {
+			err = fmt.Errorf("%w: %s", err, message)
+		}
+		return "", fmt.Errorf("unable to decrypt %s with EVO_PASSWORD_DECRYPT_CMD '%s': %w", setting, args[0], err)
+	}
+
+	password := strings.TrimSuffix(strings.TrimSuffix(stdout.String(), "\n"), "\r")
+	if len(password) == 0 {
+		return "", fmt.Errorf("EVO_PASSWORD_DECRYPT_CMD '%s' decrypted %s to an empty password", args[0], setting)
+	}
+	return password, nil
+}
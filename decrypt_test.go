@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptPassword(t *testing.T) {
+	t.Setenv("EVO_CONFIG", "")
+	t.Setenv("EVO_DB_HOST", "localhost:5432")
+	t.Setenv("EVO_DB_DATABASE", "app")
+	t.Setenv("EVO_DB_ADMIN_USERNAME", "admin")
+	t.Setenv("EVO_DB_ADMIN_PASSWORD", base64.StdEncoding.EncodeToString([]byte("admin secret\n")))
+	t.Setenv("EVO_DB_USERNAME", "app_user")
+	t.Setenv("EVO_DB_PASSWORD", base64.StdEncoding.EncodeToString([]byte("user secret")))
+	t.Setenv("EVO_PASSWORD_DECRYPT_CMD", "base64 -d")
+
+	config, err := getConfig(t.TempDir(), ConnectAdmin)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin secret", config.AdminPassword)
+	assert.Equal(t, "user secret", config.Password)
+
+	// a failing command aborts rather than passing the encrypted value on
+	t.Setenv("EVO_DB_PASSWORD", "not base64!")
+	_, err = getConfig(t.TempDir(), ConnectAdmin)
+	assert.ErrorContains(t, err, "unable to decrypt EVO_DB_PASSWORD with EVO_PASSWORD_DECRYPT_CMD 'base64'")
+
+	t.Setenv("EVO_PASSWORD_DECRYPT_CMD", "evo-missing-decrypt-command")
+	_, err = getConfig(t.TempDir(), ConnectAdmin)
+	assert.ErrorContains(t, err, "unable to decrypt EVO_DB_ADMIN_PASSWORD")
+}
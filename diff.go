@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SchemaObject is an object of a database schema, identified by its kind and qualified name
+type SchemaObject struct {
+	Kind       string
+	Name       string
+	Definition string
+}
+
+// SchemaChange is an object added (+), dropped (-) or changed (~) by the pending migrators
+type SchemaChange struct {
+	Change string
+	Object SchemaObject
+	// Before is the definition of a changed object prior to the pending migrators
+	Before string
+}
+
+// schemaObjectKinds are the queries listing each kind of object compared by diff, in the order they are reported.
+// each query returns the qualified name and definition of an object per row.  definitions which span lines are
+// compared but not shown.
+var schemaObjectKinds = []struct {
+	kind           string
+	showDefinition bool
+	query          string
+}{
+	{
+		kind: "schema",
+		query: `SELECT format('%I', n.nspname), ''
+			FROM pg_namespace n
+			WHERE ` + userNamespace + `
+			AND NOT EXISTS (SELECT 1 FROM pg_depend x WHERE x.classid = 'pg_namespace'::regclass AND x.objid = n.oid AND x.deptype = 'e')`,
+	},
+	{
+		kind:           "type",
+		showDefinition: true,
+		query: `SELECT format('%I.%I', n.nspname, t.typname),
+				'ENUM (' || (SELECT string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder) FROM pg_enum e WHERE e.enumtypid = t.oid) || ')'
+			FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+			WHERE t.typtype = 'e' AND ` + userNamespace + `
+			AND NOT EXISTS (SELECT 1 FROM pg_depend x WHERE x.classid = 'pg_type'::regclass AND x.objid = t.oid AND x.deptype = 'e')`,
+	},
+	{
+		kind:           "sequence",
+		showDefinition: true,
+		query: `SELECT format('%I.%I', n.nspname, c.relname), format_type(s.seqtypid, NULL)
+			FROM pg_sequence s JOIN pg_class c ON c.oid = s.seqrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE ` + userRelation,
+	},
+	{
+		kind: "table",
+		query: `SELECT format('%I.%I', n.nspname, c.relname), ''
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p') AND ` + userRelation,
+	},
+	{
+		kind:           "column",
+		showDefinition: true,
+		query: `SELECT format('%I.%I.%I', n.nspname, c.relname, a.attname),
+				format_type(a.atttypid, a.atttypmod)
+				|| CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END
+				|| coalesce(' DEFAULT ' || pg_get_expr(d.adbin, d.adrelid), '')
+			FROM pg_attribute a JOIN pg_class c ON c.oid = a.attrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+			LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+			WHERE c.relkind IN ('r', 'p') AND a.attnum > 0 AND NOT a.attisdropped AND ` + userRelation,
+	},
+	{
+		kind:           "constraint",
+		showDefinition: true,
+		query: `SELECT format('%I.%I.%I', n.nspname, c.relname, con.conname), pg_get_constraintdef(con.oid)
+			FROM pg_constraint con JOIN pg_class c ON c.oid = con.conrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p') AND ` + userRelation,
+	},
+	{
+		kind:           "index",
+		showDefinition: true,
+		query: `SELECT format('%I.%I', n.nspname, ic.relname), pg_get_indexdef(i.indexrelid)
+			FROM pg_index i JOIN pg_class ic ON ic.oid = i.indexrelid
+			JOIN pg_class c ON c.oid = i.indrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p', 'm') AND ` + userRelation,
+	},
+	{
+		kind: "view",
+		query: `SELECT format('%I.%I', n.nspname, c.relname), pg_get_viewdef(c.oid)
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('v', 'm') AND ` + userRelation,
+	},
+	{
+		kind: "function",
+		query: `SELECT p.oid::regprocedure::text, pg_get_functiondef(p.oid)
+			FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE p.prokind IN ('f', 'p') AND ` + userNamespace + `
+			AND NOT EXISTS (SELECT 1 FROM pg_depend x WHERE x.classid = 'pg_proc'::regclass AND x.objid = p.oid AND x.deptype = 'e')`,
+	},
+}
+
+// snapshotSchema lists the objects of the connected database, keyed by kind and name
+func snapshotSchema(conn *pgx.Conn) (map[string]SchemaObject, error) {
+	objects := map[string]SchemaObject{}
+	for _, kind := range schemaObjectKinds {
+		rows, err := conn.Query(context.Background(), kind.query)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list %s objects: %w", kind.kind, err)
+		}
+		for rows.Next() {
+			object := SchemaObject{Kind: kind.kind}
+			if err := rows.Scan(&object.Name, &object.Definition); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("unable to list %s objects: %w", kind.kind, err)
+			}
+			objects[object.Kind+" "+object.Name] = object
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return nil, fmt.Errorf("unable to list %s objects: %w", kind.kind, rows.Err())
+		}
+	}
+
+	return objects, nil
+}
+
+// diffSchemas compares two snapshots, returning the changes in the order of schemaObjectKinds and then by name
+func diffSchemas(before map[string]SchemaObject, after map[string]SchemaObject) []SchemaChange {
+	var changes []SchemaChange
+	for key, object := range after {
+		previous, existed := before[key]
+		switch {
+		case !existed:
+			changes = append(changes, SchemaChange{Change: "+", Object: object})
+		case previous.Definition != object.Definition:
+			changes = append(changes, SchemaChange{Change: "~", Object: object, Before: previous.Definition})
+		}
+	}
+	for key, object := range before {
+		if _, exists := after[key]; !exists {
+			changes = append(changes, SchemaChange{Change: "-", Object: object})
+		}
+	}
+
+	order := map[string]int{}
+	for i, kind := range schemaObjectKinds {
+		order[kind.kind] = i
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Object.Kind != changes[j].Object.Kind {
+			return order[changes[i].Object.Kind] < order[changes[j].Object.Kind]
+		}
+		return changes[i].Object.Name < changes[j].Object.Name
+	})
+
+	return changes
+}
+
+// formatSchemaChanges renders one line per change, e.g. "+ column public.things.name text"
+func formatSchemaChanges(changes []SchemaChange) string {
+	showDefinition := map[string]bool{}
+	for _, kind := range schemaObjectKinds {
+		showDefinition[kind.kind] = kind.showDefinition
+	}
+
+	var b strings.Builder
+	for _, change := range changes {
+		fmt.Fprintf(&b, "%s %s %s", change.Change, change.Object.Kind, change.Object.Name)
+		if showDefinition[change.Object.Kind] {
+			switch change.Change {
+			case "~":
+				fmt.Fprintf(&b, ": %s -> %s", change.Before, change.Object.Definition)
+			case "+":
+				fmt.Fprintf(&b, " %s", change.Object.Definition)
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// diffPending reports the net effect on the schema of the pending migrators.  the schema and migration history of
+// the configured database are copied into a temporary database, owned by the user, in which the pending migrators
+// are applied.  both snapshots are taken in the temporary database, so that any difference between the copy and
+// the original does not show up as a change.  the configured database is only read, and the temporary database is
+// dropped afterwards.
+func diffPending(config *Config) ([]SchemaChange, error) {
+	logf("connecting to database '%s' as user '%s' (read only)\n", config.Database, config.Username)
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	schema, err := extractSchema(conn)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := migratorTableExists(conn, config)
+	if err != nil {
+		return nil, err
+	}
+	var history bytes.Buffer
+	if exists {
+		records, err := getMigrationRecords(conn, config)
+		if err != nil {
+			return nil, err
+		}
+		err = writeRecordsCSV(&history, records)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl(maintenanceDatabase))
+	if err != nil {
+		return nil, connectError(fmt.Errorf("unable to connect to database: %w", err))
+	}
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+
+	temp := tempConfig(config, tempDatabaseName("evo_diff"))
+	// the temporary database is created here, owned by the user, which is left as it is
+	temp.SkipCreateDatabase = true
+	temp.ExternalUser = true
+	temp.RecordObjects = false
+
+	logf("creating temporary database '%s'\n", temp.Database)
+	_, err = adminConn.Exec(context.Background(), fmt.Sprintf("CREATE DATABASE %s OWNER %s", pgx.Identifier{temp.Database}.Sanitize(), pgx.Identifier{config.Username}.Sanitize()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary database '%s': %w", temp.Database, err)
+	}
+	defer func() {
+		err := dropDatabase(adminConn, temp.Database)
+		if err != nil {
+			logf("%s\n", err.Error())
+		}
+	}()
+
+	tempConn, err := pgx.Connect(context.Background(), temp.GetUserConnUrl())
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to temporary database '%s': %w", temp.Database, err)
+	}
+	defer func() {
+		_ = tempConn.Close(context.Background())
+	}()
+	// the copy is owned as the original is, by the session role when there is one
+	err = setSessionRole(tempConn, temp)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tempConn.Exec(context.Background(), schema)
+	if err != nil {
+		return nil, fmt.Errorf("unable to copy the schema of database '%s': %w", config.Database, err)
+	}
+	if exists {
+		_, err = importRecords(tempConn, temp, ExportFormatCSV, &history)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	before, err := snapshotSchema(tempConn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = doMigration(temp, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := snapshotSchema(tempConn)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSchemas(before, after), nil
+}
+
+func runDiff(config *Config, args []string) error {
+	// keep stdout clean for the diff
+	logOutput = os.Stderr
+
+	changes, err := diffPending(config)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		logf("the pending migrators make no schema changes\n")
+		return nil
+	}
+
+	_, err = fmt.Fprint(os.Stdout, formatSchemaChanges(changes))
+	return err
+}
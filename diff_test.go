@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestFormatSchemaChanges(t *testing.T) {
+	before := map[string]SchemaObject{
+		"table public.things":        {Kind: "table", Name: "public.things"},
+		"column public.things.id":    {Kind: "column", Name: "public.things.id", Definition: "integer"},
+		"index public.things_old":    {Kind: "index", Name: "public.things_old", Definition: "CREATE INDEX things_old ON public.things USING btree (id)"},
+		"function public.f(integer)": {Kind: "function", Name: "public.f(integer)", Definition: "CREATE FUNCTION ...\nSELECT 1"},
+	}
+	after := map[string]SchemaObject{
+		"table public.things":        {Kind: "table", Name: "public.things"},
+		"column public.things.id":    {Kind: "column", Name: "public.things.id", Definition: "bigint"},
+		"column public.things.name":  {Kind: "column", Name: "public.things.name", Definition: "text NOT NULL"},
+		"function public.f(integer)": {Kind: "function", Name: "public.f(integer)", Definition: "CREATE FUNCTION ...\nSELECT 2"},
+	}
+
+	assert.Equal(t, `~ column public.things.id: integer -> bigint
++ column public.things.name text NOT NULL
+- index public.things_old
+~ function public.f(integer)
+`, formatSchemaChanges(diffSchemas(before, after)))
+	assert.Empty(t, diffSchemas(before, before))
+}
+
+func TestDiff(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT PRIMARY KEY);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_add_name.sql": "ALTER TABLE things ADD COLUMN name TEXT;",
+	})
+	changes, err := diffPending(config)
+	assert.NoError(t, err)
+	assert.Equal(t, []SchemaChange{
+		{Change: "+", Object: SchemaObject{Kind: "column", Name: "public.things.name", Definition: "text"}},
+	}, changes)
+
+	// the database itself is untouched and the temporary database is gone
+	conn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.NotContains(t, pastMigrations, "0002_add_name.sql")
+
+	var temporary int
+	err = conn.QueryRow(context.Background(), "SELECT count(*) FROM pg_database WHERE datname LIKE 'evo\\_diff\\_%'").Scan(&temporary)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, temporary)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	directivePrefix string = "-- evo:"
+	labelsPrefix    string = "-- evo-labels:"
+	subsumesPrefix  string = "-- evo-subsumes:"
+	orderPrefix     string = "-- evo-order:"
+)
+
+const (
+	// DirectiveIrreversible marks a migrator which cannot be safely rolled back
+	DirectiveIrreversible string = "irreversible"
+	// DirectiveTransaction opts a migrator in to a transaction when EVO_DEFAULT_TRANSACTION is false
+	DirectiveTransaction string = "transaction"
+	// DirectiveRole names the role a migrator is executed as, e.g. "-- evo: role=app_owner"
+	DirectiveRole string = "role"
+	// DirectiveEnvironments restricts a migrator to the listed values of EVO_ENV, e.g. "-- evo: environments=dev,staging"
+	DirectiveEnvironments string = "environments"
+	// DirectiveExcludeEnvironments keeps a migrator from the listed values of EVO_ENV, e.g.
+	// "-- evo: exclude-environments=production"
+	DirectiveExcludeEnvironments string = "exclude-environments"
+)
+
+// parseDirectives extracts the directives from the lines of a migrator beginning with "-- evo:", e.g.
+// "-- evo: irreversible" or "-- evo: role=app_owner".  each directive maps its name to the remainder of the
+// line, or to the value following its "=", if any.
+func parseDirectives(source string) map[string]string {
+	directives := map[string]string{}
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, directivePrefix) {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, directivePrefix))
+		if len(fields) == 0 {
+			continue
+		}
+		name, value, hasValue := strings.Cut(fields[0], "=")
+		if hasValue {
+			directives[strings.ToLower(name)] = value
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Join(fields[1:], " ")
+	}
+
+	return directives
+}
+
+// parseList extracts the comma separated entries from every line of a migrator beginning with prefix
+func parseList(source string, prefix string) []string {
+	var entries []string
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		for _, entry := range strings.Split(strings.TrimPrefix(line, prefix), ",") {
+			entry = strings.TrimSpace(entry)
+			if len(entry) > 0 {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	return entries
+}
+
+// parseLabels extracts the labels from the lines of a migrator beginning with "-- evo-labels:", e.g.
+// "-- evo-labels: billing,hotfix"
+func parseLabels(source string) []string {
+	return parseList(source, labelsPrefix)
+}
+
+// parseSubsumes extracts the migrators subsumed by a baseline from the lines beginning with "-- evo-subsumes:",
+// e.g. "-- evo-subsumes: 0001_make_table.sql"
+func parseSubsumes(source string) []string {
+	return parseList(source, subsumesPrefix)
+}
+
+// parseOrder extracts the explicit position of a migrator from the line beginning with "-- evo-order:", e.g.
+// "-- evo-order: 120", reporting whether there is one
+func parseOrder(source string) (int, bool, error) {
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, orderPrefix) {
+			continue
+		}
+
+		value := strings.TrimSpace(strings.TrimPrefix(line, orderPrefix))
+		order, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, false, fmt.Errorf("order '%s' is not an integer", value)
+		}
+		return order, true, nil
+	}
+
+	return 0, false, nil
+}
+
+// splitEnvironments splits the comma separated value of an environments directive
+func splitEnvironments(value string) []string {
+	var environments []string
+	for _, environment := range strings.Split(value, ",") {
+		environment = strings.TrimSpace(environment)
+		if len(environment) > 0 {
+			environments = append(environments, environment)
+		}
+	}
+	return environments
+}
+
+// allowedInEnvironment reports whether a migrator applies in the environment env, which is EVO_ENV, as restricted by
+// its environments and exclude-environments directives.  a migrator restricted to some environments does not
+// apply when env is not set.
+func allowedInEnvironment(source string, env string) bool {
+	directives := parseDirectives(source)
+	if value, ok := directives[DirectiveEnvironments]; ok && !slices.Contains(splitEnvironments(value), env) {
+		return false
+	}
+	if value, ok := directives[DirectiveExcludeEnvironments]; ok && slices.Contains(splitEnvironments(value), env) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseDirectives(t *testing.T) {
+	directives := parseDirectives("-- drops the legacy column\n-- evo: irreversible\n  --  evo: Note  keep   this\nALTER TABLE users DROP COLUMN legacy;\n-- evo:\n")
+	assert.Equal(t, map[string]string{
+		DirectiveIrreversible: "",
+	}, directives)
+
+	directives = parseDirectives("--evo: irreversible\n-- evo: note keep this\nSELECT 1;")
+	assert.Equal(t, map[string]string{"note": "keep this"}, directives)
+
+	directives = parseDirectives("-- evo: role=app_owner\n-- evo: Role=\nSELECT 1;")
+	assert.Equal(t, map[string]string{DirectiveRole: ""}, directives)
+	assert.Equal(t, "app_owner", parseDirectives("-- evo: role=app_owner\nSELECT 1;")[DirectiveRole])
+}
+
+func TestParseLabels(t *testing.T) {
+	assert.Equal(t, []string{"billing", "hotfix", "reporting"}, parseLabels("-- evo-labels: billing, hotfix\n-- evo-labels: reporting,\nSELECT 1;"))
+	assert.Empty(t, parseLabels("-- evo: irreversible\nSELECT 1;"))
+}
+
+func TestLabelFilter(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE accounts (id INT);",
+		"0002_feature.sql":    "-- evo-labels: billing\nCREATE TABLE plans (id INT);",
+		"0003_unlabelled.sql": "ALTER TABLE accounts ADD COLUMN name TEXT;",
+		"0004_fix.sql":        "-- evo-labels: billing, hotfix\nCREATE INDEX plans_id ON plans (id);",
+	})
+	config.Label = "billing"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{
+		"0002_feature.sql": {},
+		"0004_fix.sql":     {},
+	}, pastMigrations)
+
+	// the skipped migrators remain pending for an unfiltered run
+	config.Label = ""
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	pastMigrations, err = getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 4)
+}
+
+func TestAllowedInEnvironment(t *testing.T) {
+	restricted := "-- evo: environments=dev,staging\nINSERT INTO accounts VALUES (1);"
+	assert.True(t, allowedInEnvironment(restricted, "staging"))
+	assert.False(t, allowedInEnvironment(restricted, "production"))
+	assert.False(t, allowedInEnvironment(restricted, ""))
+
+	excluded := "-- evo: exclude-environments=production\nINSERT INTO accounts VALUES (1);"
+	assert.True(t, allowedInEnvironment(excluded, "dev"))
+	assert.True(t, allowedInEnvironment(excluded, ""))
+	assert.False(t, allowedInEnvironment(excluded, "production"))
+
+	assert.True(t, allowedInEnvironment("INSERT INTO accounts VALUES (1);", "production"))
+}
+
+func TestEnvironmentFilter(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE accounts (id INT);",
+		"0002_seed.sql":       "-- evo: environments=dev,staging\nINSERT INTO accounts VALUES (1);",
+		"0003_more_seed.sql":  "-- evo: exclude-environments=production\nINSERT INTO accounts VALUES (2);",
+	})
+	config.Env = "production"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{
+		"0001_make_table.sql": {},
+	}, pastMigrations)
+	var count int
+	err = standardConn.QueryRow(context.Background(), "SELECT count(*) FROM accounts").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// nor are the skipped migrators pending, planned or asserted in the environment
+	status, err := getMigrationStatus(standardConn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, status.Pending)
+	plan, err := getPlan(standardConn, config, false)
+	assert.NoError(t, err)
+	assert.Empty(t, plan)
+	assert.NoError(t, assertApplied(standardConn, config))
+
+	// the skipped migrators remain pending for an environment they are meant for
+	config.Env = "staging"
+	status, err = getMigrationStatus(standardConn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0002_seed.sql", "0003_more_seed.sql"}, status.Pending)
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	pastMigrations, err = getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 3)
+}
+
+func TestParseOrder(t *testing.T) {
+	order, ok, err := parseOrder("-- evo-order: 120\nSELECT 1;")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 120, order)
+
+	_, ok, err = parseOrder("-- evo: irreversible\nSELECT 1;")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = parseOrder("-- evo-order: first\nSELECT 1;")
+	assert.ErrorContains(t, err, "order 'first' is not an integer")
+}
+
+func TestMigratorOrder(t *testing.T) {
+	config := &Config{Directory: t.TempDir()}
+	writeMigrators(t, config.Directory, map[string]string{
+		"a_create_users.sql": "-- evo-order: 2\nCREATE TABLE users (id INT, account_id INT);",
+		"b_unordered.sql":    "ALTER TABLE users ADD COLUMN name TEXT;",
+		"c_add_fk.sql":       "-- evo-order: 10\nALTER TABLE users ADD FOREIGN KEY (account_id) REFERENCES accounts (id);",
+		"z_create_accts.sql": "-- evo-order: 1\nCREATE TABLE accounts (id INT PRIMARY KEY);",
+	})
+
+	// the declared orders override the lexical order of the names, b_unordered keeping its position of 2
+	matches, err := findMigrators(config)
+	assert.NoError(t, err)
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, migratorName(config, match))
+	}
+	assert.Equal(t, []string{"z_create_accts.sql", "a_create_users.sql", "b_unordered.sql", "c_add_fk.sql"}, names)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"d_duplicate.sql": "-- evo-order: 10\nSELECT 1;",
+	})
+	_, err = findMigrators(config)
+	assert.ErrorContains(t, err, "migrators 'c_add_fk.sql' and 'd_duplicate.sql' both declare order 10")
+}
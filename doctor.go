@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DoctorCheck is the outcome of one of the checks of the doctor command.  a check which could not be made because
+// one it depends on failed is skipped.
+type DoctorCheck struct {
+	Name    string
+	Err     error
+	Skipped bool
+	// Detail qualifies a passing or skipped check
+	Detail string
+	// Hint suggests a remedy for a failing check
+	Hint string
+}
+
+// doctor checks, without migrating or changing anything, that the migrators can be read and rendered, that both
+// connections succeed, that the admin user can provision the database and user, and that the evo_mg table can be
+// used
+func doctor(config *Config) []DoctorCheck {
+	var checks []DoctorCheck
+	add := func(check DoctorCheck) bool {
+		checks = append(checks, check)
+		return check.Err == nil && !check.Skipped
+	}
+
+	directoryOk := add(doctorDirectory(config))
+	if directoryOk {
+		add(doctorTemplates(config))
+	} else {
+		add(DoctorCheck{Name: "templates", Skipped: true, Detail: "the migrators could not be read"})
+	}
+
+	logf("connecting to maintenance database '%s'\n", maintenanceDatabase)
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl(maintenanceDatabase))
+	if !add(DoctorCheck{Name: "admin connection", Err: connectErrorOrNil(err), Hint: "check EVO_DB_HOST, EVO_DB_ADMIN_USERNAME and EVO_DB_ADMIN_PASSWORD"}) {
+		add(DoctorCheck{Name: "admin privileges", Skipped: true, Detail: "the admin connection failed"})
+		add(DoctorCheck{Name: "user connection", Skipped: true, Detail: "the admin connection failed"})
+		add(DoctorCheck{Name: "migration table", Skipped: true, Detail: "the admin connection failed"})
+		return checks
+	}
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+
+	add(DoctorCheck{Name: "admin privileges", Err: preflight(adminConn, config)})
+
+	var databaseExists, userExists bool
+	err = adminConn.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1), EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $2)", config.Database, config.Username).Scan(&databaseExists, &userExists)
+	if err != nil {
+		add(DoctorCheck{Name: "user connection", Err: fmt.Errorf("unable to query for existing database and user: %w", err)})
+		add(DoctorCheck{Name: "migration table", Skipped: true, Detail: "the user connection was not attempted"})
+		return checks
+	}
+	var notYet []string
+	if !databaseExists {
+		notYet = append(notYet, fmt.Sprintf("database '%s'", config.Database))
+	}
+	if !userExists {
+		notYet = append(notYet, fmt.Sprintf("user '%s'", config.Username))
+	}
+	if len(notYet) > 0 {
+		detail := fmt.Sprintf("%s not yet created, evo creates it on the first run", strings.Join(notYet, " and "))
+		add(DoctorCheck{Name: "user connection", Skipped: true, Detail: detail})
+		add(DoctorCheck{Name: "migration table", Skipped: true, Detail: detail})
+		return checks
+	}
+
+	logf("connecting to database '%s' as user '%s'\n", config.Database, config.Username)
+	userConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	hint := "check EVO_DB_USERNAME and EVO_DB_PASSWORD, set EVO_AUTO_UPDATE_PASSWORD=1 to have evo reset a password which differs"
+	if !add(DoctorCheck{Name: "user connection", Err: connectErrorOrNil(err), Hint: hint}) {
+		add(DoctorCheck{Name: "migration table", Skipped: true, Detail: "the user connection failed"})
+		return checks
+	}
+	defer func() {
+		_ = userConn.Close(context.Background())
+	}()
+
+	add(doctorMigratorTable(userConn, config))
+	return checks
+}
+
+// connectErrorOrNil passes a failure to connect through connectError
+func connectErrorOrNil(err error) error {
+	if err == nil {
+		return nil
+	}
+	return connectError(err)
+}
+
+// doctorDirectory checks that the directory holds migrators, each of which can be read
+func doctorDirectory(config *Config) DoctorCheck {
+	check := DoctorCheck{Name: "migrator directory", Hint: "check the path of the directory and that it is readable"}
+	matches, err := findMigrators(config)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	if len(matches) == 0 {
+		check.Err = fmt.Errorf("no migrators found in '%s'", config.Directory)
+		check.Hint = "migrators are files with the extension .sql"
+		return check
+	}
+	for _, match := range matches {
+		_, err = readMigrator(config, match)
+		if err != nil {
+			check.Err = err
+			return check
+		}
+	}
+
+	check.Detail = fmt.Sprintf("%d migrators", len(matches))
+	return check
+}
+
+// doctorTemplates checks that every migrator renders, as the check command does
+func doctorTemplates(config *Config) DoctorCheck {
+	check := DoctorCheck{Name: "templates", Hint: fmt.Sprintf("run evo check %s for the error of each migrator", config.Directory)}
+	results, err := checkMigrators(config)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	var failed []string
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result.Name)
+		}
+	}
+	if len(failed) > 0 {
+		check.Err = fmt.Errorf("%d of %d migrators failed to render: %s", len(failed), len(results), strings.Join(failed, ", "))
+	}
+	return check
+}
+
+// doctorMigratorTable checks that the user can read the evo_mg table, or create it when it does not yet exist
+func doctorMigratorTable(conn *pgx.Conn, config *Config) DoctorCheck {
+	check := DoctorCheck{Name: "migration table"}
+	exists, err := migratorTableExists(conn, config)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	schema := migrationSchema(config)
+	if exists {
+		var count int
+		err = conn.QueryRow(context.Background(), fmt.Sprintf("SELECT count(*) FROM %s", migratorTable(config))).Scan(&count)
+		if err != nil {
+			check.Err = fmt.Errorf("unable to read evo migration table %s: %w", migratorTable(config), err)
+			check.Hint = fmt.Sprintf("GRANT SELECT, INSERT, DELETE ON %s TO %s", migratorTable(config), pgx.Identifier{config.Username}.Sanitize())
+			return check
+		}
+		check.Detail = fmt.Sprintf("%d migrators applied", count)
+		return check
+	}
+
+	var schemaExists, canCreate bool
+	err = conn.QueryRow(context.Background(), `SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1),
+		CASE WHEN EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1) THEN has_schema_privilege($1, 'CREATE')
+		ELSE has_database_privilege(current_database(), 'CREATE') END`, schema).Scan(&schemaExists, &canCreate)
+	if err != nil {
+		check.Err = fmt.Errorf("unable to query privileges of user '%s': %w", config.Username, err)
+		return check
+	}
+	if !canCreate {
+		if schemaExists {
+			check.Err = fmt.Errorf("user '%s' may not create the evo migration table in schema '%s'", config.Username, schema)
+			check.Hint = fmt.Sprintf("GRANT CREATE ON SCHEMA %s TO %s", pgx.Identifier{schema}.Sanitize(), pgx.Identifier{config.Username}.Sanitize())
+		} else {
+			check.Err = fmt.Errorf("user '%s' may not create schema '%s' for the evo migration table", config.Username, schema)
+			check.Hint = fmt.Sprintf("GRANT CREATE ON DATABASE %s TO %s", pgx.Identifier{config.Database}.Sanitize(), pgx.Identifier{config.Username}.Sanitize())
+		}
+		return check
+	}
+
+	check.Detail = fmt.Sprintf("not yet created, user '%s' may create it in schema '%s'", config.Username, schema)
+	return check
+}
+
+// writeDoctor reports each check, returning the number which failed
+func writeDoctor(w io.Writer, checks []DoctorCheck) (int, error) {
+	failures := 0
+	for _, check := range checks {
+		var err error
+		switch {
+		case check.Err != nil:
+			failures++
+			_, err = fmt.Fprintf(w, "FAIL  %s: %s\n", check.Name, check.Err.Error())
+			if err == nil && len(check.Hint) > 0 {
+				_, err = fmt.Fprintf(w, "      hint: %s\n", check.Hint)
+			}
+		case check.Skipped:
+			_, err = fmt.Fprintf(w, "SKIP  %s: %s\n", check.Name, check.Detail)
+		case len(check.Detail) > 0:
+			_, err = fmt.Fprintf(w, "PASS  %s: %s\n", check.Name, check.Detail)
+		default:
+			_, err = fmt.Fprintf(w, "PASS  %s\n", check.Name)
+		}
+		if err != nil {
+			return failures, err
+		}
+	}
+	return failures, nil
+}
+
+func runDoctor(config *Config, args []string) error {
+	// keep stdout to the report
+	logOutput = os.Stderr
+
+	checks := doctor(config)
+	failures, err := writeDoctor(os.Stdout, checks)
+	if err != nil {
+		return err
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d doctor checks failed", failures, len(checks))
+	}
+	return nil
+}
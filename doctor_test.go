@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// doctorOutcomes maps the name of each check to PASS, FAIL or SKIP
+func doctorOutcomes(checks []DoctorCheck) map[string]string {
+	outcomes := map[string]string{}
+	for _, check := range checks {
+		switch {
+		case check.Err != nil:
+			outcomes[check.Name] = "FAIL"
+		case check.Skipped:
+			outcomes[check.Name] = "SKIP"
+		default:
+			outcomes[check.Name] = "PASS"
+		}
+	}
+	return outcomes
+}
+
+func TestDoctorBrokenWithoutDatabase(t *testing.T) {
+	config := &Config{
+		Directory:     filepath.Join(t.TempDir(), "missing"),
+		Hostname:      "127.0.0.1:1",
+		Database:      Database,
+		AdminUsername: AdminUsername,
+		AdminPassword: AdminPassword,
+		Username:      Username,
+		Password:      Password,
+		Params:        url.Values{"connect_timeout": {"1"}},
+	}
+	checks := doctor(config)
+	assert.Equal(t, map[string]string{
+		"migrator directory": "FAIL",
+		"templates":          "SKIP",
+		"admin connection":   "FAIL",
+		"admin privileges":   "SKIP",
+		"user connection":    "SKIP",
+		"migration table":    "SKIP",
+	}, doctorOutcomes(checks))
+
+	var report bytes.Buffer
+	failures, err := writeDoctor(&report, checks)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, failures)
+	assert.Contains(t, report.String(), "FAIL  admin connection: ")
+	assert.Contains(t, report.String(), "      hint: check EVO_DB_HOST, EVO_DB_ADMIN_USERNAME and EVO_DB_ADMIN_PASSWORD\n")
+	assert.Contains(t, report.String(), "SKIP  admin privileges: the admin connection failed\n")
+
+	// an empty directory, and one holding a migrator which does not render
+	config.Directory = t.TempDir()
+	assert.ErrorContains(t, doctorDirectory(config).Err, "no migrators found")
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_good.sql":         "CREATE TABLE things (id INT);",
+		"0002_render_error.sql": "CREATE TABLE {{ index .EVO_DOCTOR_MISSING 100 }} (id INT);",
+	})
+	assert.NoError(t, doctorDirectory(config).Err)
+	assert.ErrorContains(t, doctorTemplates(config).Err, "1 of 2 migrators failed to render: 0002_render_error.sql")
+}
+
+func TestDoctor(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT);",
+	})
+
+	// a fresh cluster, in which evo is yet to create the database and the user
+	checks := doctor(config)
+	assert.Equal(t, map[string]string{
+		"migrator directory": "PASS",
+		"templates":          "PASS",
+		"admin connection":   "PASS",
+		"admin privileges":   "PASS",
+		"user connection":    "SKIP",
+		"migration table":    "SKIP",
+	}, doctorOutcomes(checks))
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	checks = doctor(config)
+	assert.Equal(t, map[string]string{
+		"migrator directory": "PASS",
+		"templates":          "PASS",
+		"admin connection":   "PASS",
+		"admin privileges":   "PASS",
+		"user connection":    "PASS",
+		"migration table":    "PASS",
+	}, doctorOutcomes(checks))
+
+	// the user may no longer read the migration table
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	_, err = adminConn.Exec(context.Background(), "ALTER TABLE evo_mg OWNER TO admin")
+	assert.NoError(t, err)
+	_, err = adminConn.Exec(context.Background(), "REVOKE ALL ON evo_mg FROM username")
+	assert.NoError(t, err)
+	checks = doctor(config)
+	assert.Equal(t, "FAIL", doctorOutcomes(checks)["migration table"])
+
+	// a wrong user password
+	wrongPassword := *config
+	wrongPassword.Password = "wrong"
+	checks = doctor(&wrongPassword)
+	assert.Equal(t, "FAIL", doctorOutcomes(checks)["user connection"])
+	assert.Equal(t, "SKIP", doctorOutcomes(checks)["migration table"])
+
+	// an admin user without the attributes to create the database and the user
+	_, err = adminConn.Exec(context.Background(), "CREATE ROLE weak_admin LOGIN PASSWORD 'weak'")
+	assert.NoError(t, err)
+	weakAdmin := *config
+	weakAdmin.AdminUsername = "weak_admin"
+	weakAdmin.AdminPassword = "weak"
+	weakAdmin.Database = "otherdb"
+	weakAdmin.Username = "other_user"
+	checks = doctor(&weakAdmin)
+	assert.Equal(t, "PASS", doctorOutcomes(checks)["admin connection"])
+	assert.Equal(t, "FAIL", doctorOutcomes(checks)["admin privileges"])
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const downSuffix string = ".down.sql"
+
+// downPath returns the path of the down file which reverses the migrator at path
+func downPath(path string) string {
+	return strings.TrimSuffix(path, ".sql") + downSuffix
+}
+
+// appliedMigrators returns the migrator files which have been applied, in the order in which they are applied
+func appliedMigrators(conn *pgx.Conn, config *Config) ([]string, error) {
+	exists, err := migratorTableExists(conn, config)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	existingMigrators, err := getAppliedKeys(conn, config)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := findMigrators(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, match := range matches {
+		key, err := migratorKey(config, migratorName(config, match))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := existingMigrators[key]; ok {
+			applied = append(applied, match)
+		}
+	}
+
+	return applied, nil
+}
+
+// reversal is the rendered down file of an applied migrator, ready to be executed
+type reversal struct {
+	migName       string
+	sql           string
+	transactional bool
+}
+
+// prepareReversal renders the down file of the migrator at match.  an irreversible migrator is an error unless
+// forceIrreversible is set, in which case its down file is optional.
+func prepareReversal(config *Config, match string, data map[string]any, forceIrreversible bool) (*reversal, error) {
+	migName := migratorName(config, match)
+	source, err := readMigrator(config, match)
+	if err != nil {
+		return nil, err
+	}
+	_, irreversible := parseDirectives(source)[DirectiveIrreversible]
+	if irreversible && !forceIrreversible {
+		return nil, fmt.Errorf("migrator '%s' is irreversible, pass --force-irreversible to roll it back regardless", migName)
+	}
+
+	sql := ""
+	down := downPath(match)
+	_, err = statMigratorFile(config, down)
+	switch {
+	case err == nil:
+		rendered, err := renderMigrator(config, down, migName, data)
+		if err != nil {
+			return nil, err
+		}
+		sql = rendered.SQL
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("unable to stat down file for migrator '%s': %w", migName, err)
+	case !irreversible:
+		return nil, fmt.Errorf("migrator '%s' has no down file '%s'", migName, migratorName(config, down))
+	}
+
+	transactional, err := isTransactional(config, match, source)
+	if err != nil {
+		return nil, err
+	}
+	if transactional {
+		err = validateTransactionalSQL(migName, sql)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &reversal{migName: migName, sql: sql, transactional: transactional}, nil
+}
+
+// apply executes the down file and removes the migrator's record, within a single transaction unless the migrator
+// is non-transactional, auditing the rollback when EVO_AUDIT is set
+func (r *reversal) apply(conn *pgx.Conn, config *Config) error {
+	logf("rolling back migrator '%s'...\n", r.migName)
+	var err error
+	if r.transactional {
+		err = reverseTransactionalMigrator(r.sql, conn, config, r.migName)
+	} else {
+		err = reverseMigrator(r.sql, conn, config, r.migName)
+	}
+	writeAudit(conn, config, AuditRollback, r.migName, config.Username, err)
+	return err
+}
+
+// rollback reverses the most recently applied migrators, up to steps of them, in the opposite order to that in
+// which they are applied.  each is reversed by executing its down file and removing its record, within a single
+// transaction unless the migrator is non-transactional.  an irreversible migrator stops the rollback, along with
+// everything preceding it, unless forceIrreversible is set, in which case its down file is executed if there is
+// one and its record is removed.
+func rollback(conn *pgx.Conn, config *Config, steps int, forceIrreversible bool) error {
+	applied, err := appliedMigrators(conn, config)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		logf("no migrators have been applied\n")
+		return nil
+	}
+
+	data, err := getTemplateData(config)
+	if err != nil {
+		return err
+	}
+
+	rolledBack := 0
+	for i := len(applied) - 1; i >= 0 && rolledBack < steps; i-- {
+		r, err := prepareReversal(config, applied[i], data, forceIrreversible)
+		if err != nil {
+			return err
+		}
+		err = r.apply(conn, config)
+		if err != nil {
+			return err
+		}
+		rolledBack++
+	}
+
+	logf("%d migrators rolled back\n", rolledBack)
+	return nil
+}
+
+// rollbackTo reverses every migrator applied after target, which is left applied, in the opposite order to that in
+// which they are applied.  unlike rollback, the whole range is checked before anything is reversed, so a migrator
+// in it lacking a down file, or marked irreversible without forceIrreversible, refuses the rollback outright.
+func rollbackTo(conn *pgx.Conn, config *Config, target string, forceIrreversible bool) error {
+	applied, err := appliedMigrators(conn, config)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(applied, func(match string) bool {
+		return migratorName(config, match) == target
+	})
+	if i < 0 {
+		return fmt.Errorf("migrator '%s' is not an applied migrator", target)
+	}
+
+	data, err := getTemplateData(config)
+	if err != nil {
+		return err
+	}
+
+	var reversals []*reversal
+	for j := len(applied) - 1; j > i; j-- {
+		r, err := prepareReversal(config, applied[j], data, forceIrreversible)
+		if err != nil {
+			return fmt.Errorf("unable to roll back to migrator '%s': %w", target, err)
+		}
+		reversals = append(reversals, r)
+	}
+
+	for _, r := range reversals {
+		err = r.apply(conn, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	logf("%d migrators rolled back to '%s'\n", len(reversals), target)
+	return nil
+}
+
+// reverseMigrator executes the down sql of a migrator and removes its record
+func reverseMigrator(sql string, conn Executable, config *Config, migName string) error {
+	if len(sql) > 0 {
+		_, err := conn.Exec(context.Background(), sql)
+		if err != nil {
+			return fmt.Errorf("error rolling back migrator '%s': %w", migName, err)
+		}
+	}
+
+	return unrecordMigrator(conn, config, migName)
+}
+
+// reverseTransactionalMigrator executes the down sql of a migrator and removes its record within a single
+// transaction
+func reverseTransactionalMigrator(sql string, conn *pgx.Conn, config *Config, migName string) error {
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	err = reverseMigrator(sql, tx, config, migName)
+	if err != nil {
+		_ = tx.Rollback(context.Background())
+		return err
+	}
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to commit rollback of migrator '%s': %w", migName, err)
+	}
+
+	return nil
+}
+
+func runDown(config *Config, args []string) error {
+	flags := flag.NewFlagSet("down", flag.ContinueOnError)
+	steps := flags.Int("steps", 1, "number of applied migrators to roll back")
+	to := flags.String("to", "", "roll back every migrator applied after the named one")
+	forceIrreversible := flags.Bool("force-irreversible", false, "roll back migrators marked irreversible")
+	set := flags.String("set", "", "the migration set to roll back, required when EVO_PER_DIRECTORY is set")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if config.PerDirectory {
+		// the order in which migrators of different sets were applied is not recorded, so one set is rolled back at a time
+		if len(*set) == 0 {
+			return fmt.Errorf("--set is required when EVO_PER_DIRECTORY is set")
+		}
+		sets, err := migrationSets(config)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(sets, *set) {
+			return fmt.Errorf("no migration set '%s' in '%s'", *set, config.Directory)
+		}
+		config, err = migrationSetConfig(config, *set)
+		if err != nil {
+			return err
+		}
+	} else if len(*set) > 0 {
+		return fmt.Errorf("--set requires EVO_PER_DIRECTORY")
+	}
+	if *steps < 1 {
+		return fmt.Errorf("--steps must be at least 1")
+	}
+	stepsSet := false
+	flags.Visit(func(f *flag.Flag) {
+		stepsSet = stepsSet || f.Name == "steps"
+	})
+	if stepsSet && len(*to) > 0 {
+		return fmt.Errorf("--steps and --to may not be combined")
+	}
+
+	logf("initiating concurrency mitigation\n")
+	concurrencyConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl(maintenanceDatabase))
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer func() {
+		_ = concurrencyConn.Close(context.Background())
+	}()
+
+	release, err := acquireLock(concurrencyConn, config)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	logf("connecting to database '%s' as user '%s'\n", config.Database, config.Username)
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	if err != nil {
+		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	err = setSessionRole(conn, config)
+	if err != nil {
+		return err
+	}
+	if config.Audit {
+		err = ensureAuditTable(conn, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(*to) > 0 {
+		return rollbackTo(conn, config, *to, *forceIrreversible)
+	}
+	return rollback(conn, config, *steps, *forceIrreversible)
+}
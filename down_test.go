@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestDownStopsAtIrreversible(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql":       "CREATE TABLE users (id INT, legacy TEXT);",
+		"0001_make_table.down.sql":  "DROP TABLE users;",
+		"0002_drop_legacy.sql":      "-- evo: irreversible\nALTER TABLE users DROP COLUMN legacy;",
+		"0003_add_name.sql":         "ALTER TABLE users ADD COLUMN name TEXT;",
+		"0003_add_name.down.sql":    "ALTER TABLE users DROP COLUMN name;",
+		"0004_add_email.sql":        "ALTER TABLE users ADD COLUMN email TEXT;",
+		"0004_add_email.down.sql":   "ALTER TABLE users DROP COLUMN email;",
+		"0005_index_email.sql":      "CREATE INDEX users_email ON users (email);",
+		"0005_index_email.down.sql": "DROP INDEX users_email;",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	// down files are not migrators in their own right
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 5)
+
+	err = rollback(standardConn, config, 1, false)
+	assert.NoError(t, err)
+	pastMigrations, err = getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.NotContains(t, pastMigrations, "0005_index_email.sql")
+	assert.Contains(t, pastMigrations, "0004_add_email.sql")
+
+	// the rollback stops at the irreversible migrator, leaving it and everything before it applied
+	err = rollback(standardConn, config, 10, false)
+	assert.ErrorContains(t, err, "migrator '0002_drop_legacy.sql' is irreversible")
+	pastMigrations, err = getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{
+		"0001_make_table.sql":  {},
+		"0002_drop_legacy.sql": {},
+	}, pastMigrations)
+
+	err = rollback(standardConn, config, 10, true)
+	assert.NoError(t, err)
+	pastMigrations, err = getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, pastMigrations)
+
+	var exists bool
+	err = standardConn.QueryRow(context.Background(), "SELECT to_regclass('users') IS NOT NULL").Scan(&exists)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestDownTo(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql":       "CREATE TABLE users (id INT);",
+		"0001_make_table.down.sql":  "DROP TABLE users;",
+		"0002_add_name.sql":         "ALTER TABLE users ADD COLUMN name TEXT;",
+		"0002_add_name.down.sql":    "ALTER TABLE users DROP COLUMN name;",
+		"0003_add_email.sql":        "ALTER TABLE users ADD COLUMN email TEXT;",
+		"0003_add_email.down.sql":   "ALTER TABLE users DROP COLUMN email;",
+		"0004_index_email.sql":      "CREATE INDEX users_email ON users (email);",
+		"0004_index_email.down.sql": "DROP INDEX users_email;",
+		"0005_add_age.sql":          "ALTER TABLE users ADD COLUMN age INT;",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	// a migrator in the range without a down file refuses the rollback before anything is reversed
+	err = rollbackTo(standardConn, config, "0003_add_email.sql", false)
+	assert.ErrorContains(t, err, "migrator '0005_add_age.sql' has no down file")
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 5)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0005_add_age.down.sql": "ALTER TABLE users DROP COLUMN age;",
+	})
+	err = rollbackTo(standardConn, config, "0003_add_email.sql", false)
+	assert.NoError(t, err)
+	pastMigrations, err = getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{
+		"0001_make_table.sql": {},
+		"0002_add_name.sql":   {},
+		"0003_add_email.sql":  {},
+	}, pastMigrations)
+
+	var hasEmail, hasAge, hasIndex bool
+	err = standardConn.QueryRow(context.Background(), `SELECT
+		EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'email'),
+		EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'age'),
+		to_regclass('users_email') IS NOT NULL`).Scan(&hasEmail, &hasAge, &hasIndex)
+	assert.NoError(t, err)
+	assert.True(t, hasEmail)
+	assert.False(t, hasAge)
+	assert.False(t, hasIndex)
+
+	// the target must be applied
+	err = rollbackTo(standardConn, config, "0004_index_email.sql", false)
+	assert.ErrorContains(t, err, "migrator '0004_index_email.sql' is not an applied migrator")
+}
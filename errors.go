@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+var (
+	// ErrConnect is returned when a connection to the server could not be established
+	ErrConnect = errors.New("unable to connect")
+	// ErrAuthFailed is returned when the server rejects the credentials of the admin user or the user
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrLockContended is returned when the lock is held elsewhere and could not be obtained in time, which
+	// requires a lock_timeout to be set, e.g. through EVO_DB_PARAMS, or EVO_ON_LOCK_HELD to be fail or retry
+	ErrLockContended = errors.New("lock contended")
+)
+
+// ErrMigratorFailed is returned when a migrator could not be rendered, validated or executed
+type ErrMigratorFailed struct {
+	Name string
+	Err  error
+}
+
+func (e *ErrMigratorFailed) Error() string {
+	// the underlying errors already name the migrator
+	return e.Err.Error()
+}
+
+func (e *ErrMigratorFailed) Unwrap() error {
+	return e.Err
+}
+
+// kindError attaches one of the sentinel errors to an error without altering its message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+func withKind(kind error, err error) error {
+	return &kindError{
+		kind: kind,
+		err:  err,
+	}
+}
+
+// hasCode reports whether err wraps a postgres error with one of the given SQLSTATE codes
+func hasCode(err error, codes ...string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	for _, code := range codes {
+		if pgErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// protocolMismatch reports whether a failure to connect looks like the server answered in a protocol other than
+// postgres', such as http or redis, whose replies are either cut short or rejected as malformed messages
+func protocolMismatch(err error) bool {
+	// an error reported by the server shows it speaks the protocol
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return false
+	}
+	var bodyErr *pgproto3.ExceededMaxBodyLenErr
+	if errors.As(err, &bodyErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	message := err.Error()
+	for _, symptom := range []string{"unknown message type", "invalid message length", "received unexpected message"} {
+		if strings.Contains(message, symptom) {
+			return true
+		}
+	}
+	return false
+}
+
+// connectError marks a failure to connect as ErrAuthFailed when the server rejected the credentials, or as
+// ErrConnect otherwise, with a hint when the server does not appear to be postgres at all
+func connectError(err error) error {
+	if hasCode(err, "28P01", "28000") {
+		return withKind(ErrAuthFailed, err)
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) && protocolMismatch(err) {
+		address := net.JoinHostPort(connectErr.Config.Host, strconv.Itoa(int(connectErr.Config.Port)))
+		err = fmt.Errorf("the server at %s does not appear to speak the postgres protocol, check the host and port of EVO_DB_HOST: %w", address, err)
+	}
+	return withKind(ErrConnect, err)
+}
+
+// lockError marks a failure to obtain the lock as ErrLockContended when it timed out waiting for another holder
+func lockError(err error) error {
+	if hasCode(err, "55P03") {
+		return withKind(ErrLockContended, err)
+	}
+	return err
+}
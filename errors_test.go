@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestErrConnect(t *testing.T) {
+	config := &Config{
+		Hostname:      "127.0.0.1:1",
+		Database:      Database,
+		AdminUsername: AdminUsername,
+		AdminPassword: AdminPassword,
+		Username:      Username,
+		Password:      Password,
+		Params:        url.Values{"connect_timeout": {"1"}},
+	}
+	err := doMigration(config, nil)
+	assert.ErrorIs(t, err, ErrConnect)
+	assert.NotErrorIs(t, err, ErrAuthFailed)
+	assert.ErrorContains(t, err, "unable to connect to database")
+}
+
+func TestTypedErrors(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE first (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConfig := *config
+	adminConfig.AdminPassword = "wrong"
+	err = doMigration(&adminConfig, nil)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+
+	userConfig := *config
+	userConfig.Password = "wrong"
+	userConfig.AutoUpdatePassword = false
+	err = doMigration(&userConfig, nil)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+
+	// hold the lock elsewhere while a runner with a lock timeout waits on it
+	holder, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = holder.Close(context.Background())
+	}()
+	release, err := acquireLock(holder, config)
+	assert.NoError(t, err)
+	lockConfig := *config
+	lockConfig.Params = url.Values{"options": {"-c lock_timeout=100"}}
+	err = doMigration(&lockConfig, nil)
+	assert.ErrorIs(t, err, ErrLockContended)
+	release()
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_broken.sql": "CREATE TABLE first (id INT);",
+	})
+	err = doMigration(config, nil)
+	var migratorErr *ErrMigratorFailed
+	assert.ErrorAs(t, err, &migratorErr)
+	assert.Equal(t, "0002_broken.sql", migratorErr.Name)
+	var pgErr *pgconn.PgError
+	assert.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "42P07", pgErr.Code)
+}
+
+func TestErrNotPostgres(t *testing.T) {
+	// a server speaking another protocol, which answers anything with an http error
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Read(make([]byte, 1024))
+			_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n"))
+			_ = conn.Close()
+		}
+	}()
+
+	config := &Config{
+		Hostname:      listener.Addr().String(),
+		Database:      Database,
+		AdminUsername: AdminUsername,
+		AdminPassword: AdminPassword,
+		Username:      Username,
+		Password:      Password,
+	}
+	err = doMigration(config, nil)
+	assert.ErrorIs(t, err, ErrConnect)
+	assert.ErrorContains(t, err, "the server at "+listener.Addr().String()+" does not appear to speak the postgres protocol")
+
+	_, err = verifyUserPassword(config, nil)
+	assert.ErrorContains(t, connectError(err), "does not appear to speak the postgres protocol")
+
+	// a server which is simply not listening gets no such hint
+	config.Hostname = "127.0.0.1:1"
+	err = doMigration(config, nil)
+	assert.ErrorIs(t, err, ErrConnect)
+	assert.NotContains(t, err.Error(), "postgres protocol")
+}
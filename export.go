@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ExportFormatCSV string = "csv"
+	ExportFormatSQL string = "sql"
+)
+
+var exportColumns = []string{"migrator", "version", "created_at", "release", "checksum", "run_id"}
+
+// legacyExportColumns is the header of exports which predate the checksum and run_id columns, still accepted by import
+var legacyExportColumns = exportColumns[:4]
+
+// MigrationRecord is a row of the evo migration table
+type MigrationRecord struct {
+	Migrator  string
+	Version   *int64
+	CreatedAt time.Time
+	Release   *string
+	Checksum  *string
+	RunID     *string
+}
+
+// getMigrationRecords reads every row of the evo migration table, in order of application.  when config.Since
+// is set, only the rows created at or after it are read.
+func getMigrationRecords(conn *pgx.Conn, config *Config) ([]MigrationRecord, error) {
+	versionColumn := "NULL::BIGINT"
+	if config.TrackBy == TrackByVersion {
+		versionColumn = "version"
+	}
+
+	// tables which predate the release, checksum and run_id columns are read without them, as the read may be over
+	// a read only connection
+	releaseColumn := "NULL::TEXT"
+	hasRelease, err := hasMigratorColumn(conn, config, "release")
+	if err != nil {
+		return nil, err
+	}
+	if hasRelease {
+		releaseColumn = "release"
+	}
+
+	checksumColumn := "NULL::TEXT"
+	hasChecksum, err := hasMigratorColumn(conn, config, "checksum")
+	if err != nil {
+		return nil, err
+	}
+	if hasChecksum {
+		checksumColumn = "checksum"
+	}
+
+	runIDColumn := "NULL::TEXT"
+	hasRunID, err := hasMigratorColumn(conn, config, "run_id")
+	if err != nil {
+		return nil, err
+	}
+	if hasRunID {
+		runIDColumn = "run_id"
+	}
+
+	query := fmt.Sprintf("SELECT migrator, %s, created_at, %s, %s, %s FROM %s", versionColumn, releaseColumn, checksumColumn, runIDColumn, migratorTable(config))
+	var args []any
+	if config.Since != nil {
+		query += " WHERE created_at >= $1"
+		args = append(args, *config.Since)
+	}
+	rows, err := conn.Query(context.Background(), query+" ORDER BY created_at, migrator", args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read evo migration table: %w", err)
+	}
+	defer rows.Close()
+
+	var records []MigrationRecord
+	for rows.Next() {
+		var record MigrationRecord
+		if err := rows.Scan(&record.Migrator, &record.Version, &record.CreatedAt, &record.Release, &record.Checksum, &record.RunID); err != nil {
+			return nil, fmt.Errorf("failed to read migration record: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func formatVersion(version *int64) string {
+	if version == nil {
+		return ""
+	}
+	return strconv.FormatInt(*version, 10)
+}
+
+// formatOptional formats an optional text column, a missing value being empty
+func formatOptional(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// parseOptional is the inverse of formatOptional
+func parseOptional(value string) *string {
+	if len(value) == 0 {
+		return nil
+	}
+	return &value
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func writeRecordsCSV(w io.Writer, records []MigrationRecord) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(exportColumns)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		err = writer.Write([]string{record.Migrator, formatVersion(record.Version), record.CreatedAt.UTC().Format(time.RFC3339Nano), formatOptional(record.Release), formatOptional(record.Checksum), formatOptional(record.RunID)})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func writeRecordsSQL(w io.Writer, records []MigrationRecord) error {
+	for _, record := range records {
+		columns := "migrator, created_at"
+		values := fmt.Sprintf("%s, %s", quoteLiteral(record.Migrator), quoteLiteral(record.CreatedAt.UTC().Format(time.RFC3339Nano)))
+		if record.Version != nil {
+			columns = "version, " + columns
+			values = formatVersion(record.Version) + ", " + values
+		}
+		if record.Release != nil {
+			columns += ", release"
+			values += ", " + quoteLiteral(*record.Release)
+		}
+		if record.Checksum != nil {
+			columns += ", checksum"
+			values += ", " + quoteLiteral(*record.Checksum)
+		}
+		if record.RunID != nil {
+			columns += ", run_id"
+			values += ", " + quoteLiteral(*record.RunID)
+		}
+		_, err := fmt.Fprintf(w, "INSERT INTO evo_mg (%s) VALUES (%s);\n", columns, values)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readRecordsCSV(r io.Reader) ([]MigrationRecord, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read csv: %w", err)
+	}
+	if len(rows) == 0 || (!slices.Equal(rows[0], exportColumns) && !slices.Equal(rows[0], legacyExportColumns)) {
+		return nil, fmt.Errorf("csv header must be '%s'", strings.Join(exportColumns, ","))
+	}
+
+	var records []MigrationRecord
+	for _, row := range rows[1:] {
+		createdAt, err := time.Parse(time.RFC3339Nano, row[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid created_at for migrator '%s': %w", row[0], err)
+		}
+		record := MigrationRecord{
+			Migrator:  row[0],
+			CreatedAt: createdAt,
+			Release:   parseOptional(row[3]),
+		}
+		if len(row) == len(exportColumns) {
+			record.Checksum = parseOptional(row[4])
+			record.RunID = parseOptional(row[5])
+		}
+		if len(row[1]) > 0 {
+			version, err := strconv.ParseInt(row[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version for migrator '%s': %w", row[0], err)
+			}
+			record.Version = &version
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// importRecords loads records into an empty evo migration table, creating it if necessary
+func importRecords(conn *pgx.Conn, config *Config, format string, r io.Reader) (int, error) {
+	existing, err := ensureMigratorTable(conn, config)
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) > 0 {
+		return 0, fmt.Errorf("evo migration table already contains %d migrators, import requires an empty table", len(existing))
+	}
+
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	count := 0
+	switch format {
+	case ExportFormatCSV:
+		records, err := readRecordsCSV(r)
+		if err != nil {
+			return 0, err
+		}
+		for _, record := range records {
+			if config.TrackBy == TrackByVersion {
+				if record.Version == nil {
+					return 0, fmt.Errorf("migrator '%s' has no version", record.Migrator)
+				}
+				_, err = tx.Exec(context.Background(), fmt.Sprintf("INSERT INTO %s (version, migrator, created_at, release, checksum, run_id) VALUES ($1, $2, $3, $4, $5, $6)", migratorTable(config)), *record.Version, record.Migrator, record.CreatedAt, record.Release, record.Checksum, record.RunID)
+			} else {
+				_, err = tx.Exec(context.Background(), fmt.Sprintf("INSERT INTO %s (migrator, created_at, release, checksum, run_id) VALUES ($1, $2, $3, $4, $5)", migratorTable(config)), record.Migrator, record.CreatedAt, record.Release, record.Checksum, record.RunID)
+			}
+			if err != nil {
+				return 0, fmt.Errorf("unable to import migrator '%s': %w", record.Migrator, err)
+			}
+			count++
+		}
+	case ExportFormatSQL:
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return 0, err
+		}
+		// the dump refers to evo_mg unqualified, resolve it to the configured schema
+		_, err = tx.Exec(context.Background(), fmt.Sprintf("SET LOCAL search_path TO %s", pgx.Identifier{migrationSchema(config)}.Sanitize()))
+		if err != nil {
+			return 0, err
+		}
+		for _, statement := range splitStatements(string(content)) {
+			// only the inserts produced by export are accepted, anything else is not a migration history dump
+			if !strings.HasPrefix(stripComments(statement), "INSERT INTO evo_mg ") {
+				return 0, fmt.Errorf("unexpected statement in sql import: %s", statement)
+			}
+			_, err = tx.Exec(context.Background(), statement)
+			if err != nil {
+				return 0, fmt.Errorf("unable to import statement '%s': %w", statement, err)
+			}
+			count++
+		}
+	default:
+		return 0, fmt.Errorf("unsupported format '%s'", format)
+	}
+
+	return count, tx.Commit(context.Background())
+}
+
+func validateFormat(format string) error {
+	if format != ExportFormatCSV && format != ExportFormatSQL {
+		return fmt.Errorf("--format must be one of '%s' or '%s'", ExportFormatCSV, ExportFormatSQL)
+	}
+	return nil
+}
+
+func parseFormatFlag(name string, args []string) (string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	format := flags.String("format", ExportFormatCSV, "one of csv or sql")
+	err := flags.Parse(args)
+	if err != nil {
+		return "", err
+	}
+
+	return *format, validateFormat(*format)
+}
+
+func runExport(config *Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", ExportFormatCSV, "one of csv or sql")
+	since := flags.String("since", "", "only export migrators applied at or after this RFC3339 timestamp")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = validateFormat(*format)
+	if err != nil {
+		return err
+	}
+	err = parseSince(config, *since)
+	if err != nil {
+		return err
+	}
+
+	// keep stdout clean for the dump
+	logOutput = os.Stderr
+
+	logf("connecting to database '%s' as user '%s' (read only)\n", config.Database, config.Username)
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	if err != nil {
+		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	records, err := getMigrationRecords(conn, config)
+	if err != nil {
+		return err
+	}
+
+	if *format == ExportFormatSQL {
+		return writeRecordsSQL(os.Stdout, records)
+	}
+	return writeRecordsCSV(os.Stdout, records)
+}
+
+func runImport(config *Config, args []string) error {
+	format, err := parseFormatFlag("import", args)
+	if err != nil {
+		return err
+	}
+
+	logf("connecting to database '%s' as user '%s'\n", config.Database, config.Username)
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	if err != nil {
+		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	// the migration table, should the import create it, is owned as a run would own it
+	err = setSessionRole(conn, config)
+	if err != nil {
+		return err
+	}
+
+	count, err := importRecords(conn, config, format, os.Stdin)
+	if err != nil {
+		return err
+	}
+	logf("imported %d migrators\n", count)
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestRecordsCSVRoundTrip(t *testing.T) {
+	version := int64(7)
+	checksum, runID := "3a7bd3e2360a3d29eea436fcfb7e44c735d117c42d1c1835420b6b9942dd4f1b", "job-1"
+	records := []MigrationRecord{
+		{Migrator: "0001_a,b.sql", CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)},
+		{Migrator: "0007_c.sql", Version: &version, CreatedAt: time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC), Checksum: &checksum, RunID: &runID},
+	}
+
+	var buf bytes.Buffer
+	err := writeRecordsCSV(&buf, records)
+	assert.NoError(t, err)
+
+	decoded, err := readRecordsCSV(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, records, decoded)
+
+	// exports which predate the checksum and run_id columns are still read
+	decoded, err = readRecordsCSV(strings.NewReader("migrator,version,created_at,release\n0001_a.sql,,2024-01-02T03:04:05Z,v1\n"))
+	assert.NoError(t, err)
+	assert.Len(t, decoded, 1)
+	assert.Nil(t, decoded[0].Checksum)
+	assert.Equal(t, "v1", *decoded[0].Release)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	original, err := getMigrationRecords(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, original, 5)
+
+	for _, format := range []string{ExportFormatCSV, ExportFormatSQL} {
+		var buf bytes.Buffer
+		if format == ExportFormatCSV {
+			err = writeRecordsCSV(&buf, original)
+		} else {
+			err = writeRecordsSQL(&buf, original)
+		}
+		assert.NoError(t, err)
+
+		// import refuses to merge into existing history
+		_, err = importRecords(conn, config, format, bytes.NewReader(buf.Bytes()))
+		assert.Error(t, err)
+
+		_, err = conn.Exec(context.Background(), "DELETE FROM evo_mg")
+		assert.NoError(t, err)
+
+		count, err := importRecords(conn, config, format, &buf)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, count)
+
+		imported, err := getMigrationRecords(conn, config)
+		assert.NoError(t, err)
+		assert.Len(t, imported, len(original))
+		for i := range original {
+			assert.Equal(t, original[i].Migrator, imported[i].Migrator)
+			assert.True(t, original[i].CreatedAt.Equal(imported[i].CreatedAt))
+			// the checksums survive, so that drift is still detected on the restored history
+			assert.NotNil(t, imported[i].Checksum)
+			assert.Equal(t, original[i].Checksum, imported[i].Checksum)
+			assert.Equal(t, original[i].RunID, imported[i].RunID)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// compileFilenamePattern compiles EVO_FILENAME_PATTERN, which must match the whole of a migrator's file name
+func compileFilenamePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) == 0 {
+		return nil, nil
+	}
+
+	compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("EVO_FILENAME_PATTERN is not a valid regular expression: %w", err)
+	}
+	return compiled, nil
+}
+
+// validateFilenames checks the name of every candidate migrator directly within the directory against the
+// configured pattern.  files with the extension .sql in any case are candidates, so that a misnamed file such as
+// "Migration1.SQL" is reported rather than silently ignored.
+func validateFilenames(config *Config, directory string) error {
+	if config.FilenamePattern == nil {
+		return nil
+	}
+
+	globbed, err := globMigratorFiles(config, directory, "*")
+	if err != nil {
+		return err
+	}
+
+	var violations []string
+	for _, match := range globbed {
+		name := filepath.Base(match)
+		if !strings.EqualFold(filepath.Ext(name), ".sql") || isCompanion(match) {
+			continue
+		}
+		if !config.FilenamePattern.MatchString(name) {
+			violations = append(violations, migratorName(config, match))
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d migrator file names do not match EVO_FILENAME_PATTERN (%s): %s", len(violations), config.FilenamePattern.String(), strings.Join(violations, ", "))
+	}
+
+	return nil
+}
+
+// checkCaseCollisions rejects files whose names differ only by case.  such files cannot coexist on a case
+// insensitive filesystem, as on macOS or windows, and sort differently by byte than they would by hand, so that
+// the order in which they apply would depend on where evo runs.
+func checkCaseCollisions(config *Config, matches []string) error {
+	seen := make(map[string]string, len(matches))
+	for _, match := range matches {
+		folded := strings.ToLower(migratorName(config, match))
+		if other, ok := seen[folded]; ok {
+			return fmt.Errorf("migrators '%s' and '%s' differ only by case, rename one of them", migratorName(config, other), migratorName(config, match))
+		}
+		seen[folded] = match
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilenamePattern(t *testing.T) {
+	pattern, err := compileFilenamePattern(`[0-9]{4}_[a-z0-9_]+\.sql`)
+	assert.NoError(t, err)
+
+	config := &Config{Directory: t.TempDir(), FilenamePattern: pattern}
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql":                "CREATE TABLE things (id INT);",
+		"0002_edit_type_notrans.sql":         "ALTER TYPE kind ADD VALUE 'other';",
+		"0002_edit_type_notrans.down.sql":    "SELECT 1;",
+		"0002_edit_type_notrans.cleanup.sql": "SELECT 1;",
+		"README.md":                          "not a migrator",
+	})
+	matches, err := findMigrators(config)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 2)
+
+	// misnamed files are reported, including those which would otherwise be silently ignored
+	writeMigrators(t, config.Directory, map[string]string{
+		"add index.sql":  "CREATE INDEX things_id ON things (id);",
+		"Migration1.SQL": "SELECT 1;",
+	})
+	_, err = findMigrators(config)
+	assert.ErrorContains(t, err, "2 migrator file names do not match EVO_FILENAME_PATTERN")
+	assert.ErrorContains(t, err, "Migration1.SQL, add index.sql")
+
+	// the pattern must match the whole name
+	pattern, err = compileFilenamePattern(`[0-9]{4}`)
+	assert.NoError(t, err)
+	config = &Config{Directory: t.TempDir(), FilenamePattern: pattern}
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT);",
+	})
+	_, err = findMigrators(config)
+	assert.ErrorContains(t, err, "0001_make_table.sql")
+
+	_, err = compileFilenamePattern(`[`)
+	assert.ErrorContains(t, err, "EVO_FILENAME_PATTERN")
+}
+
+func TestCaseCollision(t *testing.T) {
+	config := &Config{Directory: t.TempDir()}
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_A.sql": "CREATE TABLE a (id INT);",
+		"0001_a.sql": "CREATE TABLE b (id INT);",
+		"0002_b.sql": "SELECT 1;",
+	})
+	_, err := findMigrators(config)
+	assert.ErrorContains(t, err, "migrators '0001_A.sql' and '0001_a.sql' differ only by case")
+
+	// as do their companions
+	config = &Config{Directory: t.TempDir()}
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql":      "CREATE TABLE things (id INT);",
+		"0001_make_table.down.sql": "DROP TABLE things;",
+		"0001_Make_Table.down.sql": "DROP TABLE things;",
+	})
+	_, err = findMigrators(config)
+	assert.ErrorContains(t, err, "differ only by case")
+}
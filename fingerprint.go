@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// noneApplied is printed by version-state for a database which has applied no migrators
+const noneApplied string = "none"
+
+// schemaFingerprint summarizes applied migrators on one line: the migrator applied last followed by a sha256 over
+// the name of every applied migrator and the checksum of its source, taken from sources by migrator key.  the
+// checksums stored as migrators are applied are not used, being of the rendered sql, which differs between databases
+// whenever a template refers to the database or the environment.  the hash is taken in name order, so that databases
+// which applied the same migrators at different times, or in a different order, share a fingerprint.
+func schemaFingerprint(records []MigrationRecord, sources map[string]string) string {
+	if len(records) == 0 {
+		return noneApplied
+	}
+
+	sorted := make([]MigrationRecord, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Migrator < sorted[j].Migrator
+	})
+
+	hash := sha256.New()
+	for _, record := range sorted {
+		key := record.Migrator
+		if record.Version != nil {
+			key = formatVersion(record.Version)
+		}
+		// a migrator whose file is gone, subsumed by a baseline say, is hashed by name alone
+		fmt.Fprintf(hash, "%s\t%s\n", record.Migrator, sources[key])
+	}
+
+	// records are read in order of application
+	return fmt.Sprintf("%s %s", records[len(records)-1].Migrator, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// versionState reads the fingerprint of the applied migrators of the connected database
+func versionState(conn *pgx.Conn, config *Config) (string, error) {
+	exists, err := migratorTableExists(conn, config)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return noneApplied, nil
+	}
+
+	records, err := getMigrationRecords(conn, config)
+	if err != nil {
+		return "", err
+	}
+	sources, err := sourceChecksums(config)
+	if err != nil {
+		return "", err
+	}
+	return schemaFingerprint(records, sources), nil
+}
+
+// sourceChecksums returns the checksum of the unrendered source of every migrator in the directory, by migrator key
+func sourceChecksums(config *Config) (map[string]string, error) {
+	matches, err := findMigrators(config)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := map[string]string{}
+	for _, match := range matches {
+		key, err := migratorKey(config, migratorName(config, match))
+		if err != nil {
+			return nil, err
+		}
+		source, err := readMigrator(config, match)
+		if err != nil {
+			return nil, err
+		}
+		sources[key] = checksum(source)
+	}
+	return sources, nil
+}
+
+func runVersionState(config *Config, args []string) error {
+	// keep stdout to the fingerprint
+	logOutput = os.Stderr
+
+	logf("connecting to database '%s' as user '%s' (read only)\n", config.Database, config.Username)
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	if err != nil {
+		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	state, err := versionState(conn, config)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Println(state)
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestSchemaFingerprint(t *testing.T) {
+	assert.Equal(t, noneApplied, schemaFingerprint(nil, nil))
+
+	sources := map[string]string{"0001_a.sql": "aaa", "0002_b.sql": "bbb"}
+	records := []MigrationRecord{
+		{Migrator: "0001_a.sql"},
+		{Migrator: "0002_b.sql"},
+	}
+	fingerprint := schemaFingerprint(records, sources)
+	assert.True(t, strings.HasPrefix(fingerprint, "0002_b.sql "))
+
+	// the order of application only decides the migrator named
+	reordered := schemaFingerprint([]MigrationRecord{records[1], records[0]}, sources)
+	assert.Equal(t, strings.Fields(fingerprint)[1], strings.Fields(reordered)[1])
+
+	// the checksums stored of the rendered sql, which vary between databases, play no part
+	rendered := "rendered for this database"
+	records[0].Checksum = &rendered
+	assert.Equal(t, fingerprint, schemaFingerprint(records, sources))
+
+	// a changed source changes the hash
+	sources["0001_a.sql"] = "ccc"
+	assert.NotEqual(t, fingerprint, schemaFingerprint(records, sources))
+}
+
+func TestVersionState(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	// the migrator renders differently in each database
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT);\nCOMMENT ON TABLE things IS '{{ .Database }}';",
+	})
+
+	state := func(config *Config) string {
+		conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+		assert.NoError(t, err)
+		defer func() {
+			_ = conn.Close(context.Background())
+		}()
+		state, err := versionState(conn, config)
+		assert.NoError(t, err)
+		return state
+	}
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	before := state(config)
+	assert.True(t, strings.HasPrefix(before, "0001_make_table.sql "))
+
+	// a second database migrated identically shares the fingerprint
+	other := *config
+	other.Database = "otherdb"
+	other.Databases = []string{"otherdb"}
+	err = doMigration(&other, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, before, state(&other))
+
+	// applying another migrator changes it
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_add_name.sql": "ALTER TABLE things ADD COLUMN name TEXT;",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	after := state(config)
+	assert.NotEqual(t, before, after)
+	assert.True(t, strings.HasPrefix(after, "0002_add_name.sql "))
+
+	err = doMigration(&other, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, after, state(&other))
+}
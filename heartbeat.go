@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultHeartbeatInterval is the interval between heartbeats unless EVO_HEARTBEAT_INTERVAL says otherwise
+const defaultHeartbeatInterval = 30 * time.Second
+
+// withHeartbeat calls fn, which executes a migrator on conn, logging a heartbeat every config.HeartbeatInterval
+// until it returns.  each heartbeat reports the state of conn's backend from pg_stat_activity, read over a
+// separate connection which is opened on the first heartbeat, so that quick migrators cost nothing.
+func withHeartbeat(config *Config, conn *pgx.Conn, migName string, fn func() error) error {
+	if config.HeartbeatInterval <= 0 {
+		return fn()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	ticker := time.NewTicker(config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	pid := conn.PgConn().PID()
+	var monitorConn *pgx.Conn
+	defer func() {
+		if monitorConn != nil {
+			_ = monitorConn.Close(context.Background())
+		}
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+		}
+
+		elapsed := time.Since(start).Round(time.Second)
+		if monitorConn == nil {
+			var err error
+			monitorConn, err = pgx.Connect(context.Background(), config.GetUserConnUrl())
+			if err != nil {
+				logf("migrator '%s' still executing after %s (unable to connect to report activity: %s)\n", migName, elapsed, err.Error())
+				monitorConn = nil
+				continue
+			}
+		}
+
+		var state, waitEventType, waitEvent *string
+		err := monitorConn.QueryRow(context.Background(), "SELECT state, wait_event_type, wait_event FROM pg_stat_activity WHERE pid = $1", pid).Scan(&state, &waitEventType, &waitEvent)
+		if err != nil {
+			logf("migrator '%s' still executing after %s (unable to report activity: %s)\n", migName, elapsed, err.Error())
+			continue
+		}
+
+		activity := "state " + valueOr(state, "unknown")
+		if waitEvent != nil {
+			activity += ", waiting on " + valueOr(waitEventType, "unknown") + "/" + *waitEvent
+		}
+		logf("migrator '%s' still executing after %s (%s)\n", migName, elapsed, activity)
+	}
+}
+
+func valueOr(value *string, fallback string) string {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestHeartbeat(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	var output bytes.Buffer
+	logOutput = &output
+	defer func() {
+		logOutput = os.Stdout
+	}()
+
+	config.Directory = t.TempDir()
+	config.HeartbeatInterval = 200 * time.Millisecond
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_quick.sql": "CREATE TABLE quick (id INT);",
+		"0002_slow.sql":  "SELECT pg_sleep(1);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	assert.Contains(t, output.String(), "migrator '0002_slow.sql' still executing after")
+	assert.Contains(t, output.String(), "state active, waiting on Timeout/PgSleep")
+	assert.NotContains(t, output.String(), "migrator '0001_quick.sql' still executing")
+}
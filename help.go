@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// settingHelp documents a setting read from the environment or the config file
+type settingHelp struct {
+	name        string
+	description string
+	// defaultValue is shown when not empty
+	defaultValue string
+}
+
+// seconds formats a default duration as the whole number of seconds a setting takes
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(d.Seconds()))
+}
+
+// settingGroups is the reference of every setting evo reads, from which the help is generated.  a setting read
+// anywhere in the code must be listed here, which is enforced by a test.
+var settingGroups = []struct {
+	title    string
+	settings []settingHelp
+}{
+	{
+		title: "connection",
+		settings: []settingHelp{
+			{"EVO_DB_HOST", "database service hostname (<host>:<port>), unix socket directory (/<dir>[:<port>]) or SRV record (srv://<name>)", ""},
+			{"EVO_DB_ADMIN_USERNAME", "database service admin username", ""},
+			{"EVO_DB_ADMIN_PASSWORD", "database service admin password, from the environment only", ""},
+			{"EVO_DB_USERNAME", "database service username", ""},
+			{"EVO_DB_PASSWORD", "database service password, from the environment only", ""},
+			{"EVO_DB_DATABASE", "database name, or a comma separated list of them (up and bootstrap only)", ""},
+			{"EVO_DB_SSLCERT", "client certificate file, authenticating in place of the passwords, which become optional", ""},
+			{"EVO_DB_SSLKEY", "client certificate key file, required with EVO_DB_SSLCERT", ""},
+			{"EVO_DB_SSLROOTCERT", "certificate authority file against which the server's certificate is verified", ""},
+			{"EVO_PASSWORD_DECRYPT_CMD", "command decrypting the passwords, given each on stdin, from the environment only", ""},
+			{"EVO_DB_PARAMS", "url encoded query string of extra connection parameters", ""},
+			{"EVO_CONFIG", "json file of non-secret settings, overridden by the environment", defaultConfigFile},
+		},
+	},
+	{
+		title: "databases",
+		settings: []settingHelp{
+			{"EVO_PARALLEL", "maximum number of databases migrated at once", strconv.Itoa(defaultParallel)},
+			{"EVO_FAIL_FAST", "when set to 1, no further databases are started after one fails", ""},
+			{"EVO_ALLOW_MAINTENANCE_DB", "when set to 1, the postgres maintenance database may be migrated, which is otherwise refused", ""},
+			{"EVO_SKIP_CREATE_DATABASE", "when set to 1, the database is never created, it must be provisioned externally", ""},
+			{"EVO_WAIT_FOR_DATABASE", "when set to 1, wait for a database which cannot be created to appear", ""},
+			{"EVO_WAIT_FOR_DATABASE_TIMEOUT", "seconds to wait for the database to appear", seconds(defaultDatabaseWaitTimeout)},
+			{"EVO_REQUIRE_PRIMARY", "when set to 1, refuse to migrate a database which is in recovery", ""},
+			{"EVO_READY_TIMEOUT", "seconds to wait for a database in recovery to be promoted", "0"},
+		},
+	},
+	{
+		title: "user",
+		settings: []settingHelp{
+			{"EVO_MANAGE_USER", "when set to false, the user is never created, altered or granted privileges", ""},
+			{"EVO_SKIP_USER_VERIFY", "when set to 1, the user's credentials are trusted, the user connects only for the migration", ""},
+			{"EVO_AUTO_UPDATE_PASSWORD", "when set to 1, user password will be synced to match env value", ""},
+			{"EVO_USER_CONNECTION_LIMIT", "maximum concurrent connections of the user, -1 for unlimited", ""},
+			{"EVO_USER_VALID_UNTIL", "timestamp after which the user's password expires, or 'infinity'", ""},
+			{"EVO_USER_ROLE_MEMBERSHIP", "comma separated group roles the user is made a member of", ""},
+			{"EVO_SESSION_ROLE", "role switched to by SET ROLE as soon as the user connects, owning what migrators create", ""},
+			{"EVO_GRANT_ROLE", "group role, of which the user is made a member, granted default privileges", ""},
+			{"EVO_GRANT_ROLE_ONLY", "when set to 1, default privileges go to EVO_GRANT_ROLE in place of the user", ""},
+			{"EVO_REGRANT_ALWAYS", "when set to 1, user privileges are granted even if already in place", ""},
+			{"EVO_GRANT_TABLES", "default privileges on tables: ALL, NONE or a comma separated list, e.g. SELECT,INSERT", allPrivileges},
+			{"EVO_GRANT_SEQUENCES", "default privileges on sequences: ALL, NONE or any of USAGE,SELECT,UPDATE", allPrivileges},
+			{"EVO_GRANT_FUNCTIONS", "default privileges on functions: ALL, NONE or EXECUTE", allPrivileges},
+			{"EVO_DEFAULT_PRIVILEGE_ROLES", "roles granted default table privileges, e.g. readonly=SELECT,api=SELECT+INSERT", ""},
+			{"EVO_CREATE_MISSING_ROLES", "when set to 1, roles in EVO_DEFAULT_PRIVILEGE_ROLES and EVO_GRANT_ROLE are created if missing", ""},
+			{"EVO_FORBID_SUPERUSER", "when set to 1, refuse to migrate if the user is a superuser", ""},
+		},
+	},
+	{
+		title: "migrators",
+		settings: []settingHelp{
+			{"EVO_S3_ENDPOINT", "endpoint through which s3:// migrator sources are fetched, e.g. a minio server", ""},
+			{"EVO_ENV", "environment name, selecting a subdirectory of environment specific migrators and checked by the environments directive", ""},
+			{"EVO_FILE_ENCODING", "encoding of migrators without a byte order mark: utf-8, utf-16le, utf-16be", defaultFileEncoding},
+			{"EVO_FAIL_ON_EMPTY", "when set to 1, a run fails if the directory holds no migrators", ""},
+			{"EVO_FILENAME_PATTERN", "regular expression which the whole file name of every migrator must match", ""},
+			{"EVO_DEFAULT_TRANSACTION", "when set to false, migrators run outside a transaction unless suffixed _trans.sql", "true"},
+			{"EVO_TEMPLATE_VARS_FILES", "colon or comma separated json/yaml files merged into the template dictionary", ""},
+			{"EVO_TEMPLATE_ALLOW", "comma separated environment variables exposed to templates", "all"},
+			{"EVO_TEMPLATE_ENV_PRECEDENCE", "'high' env overrides vars files, 'low' vars files override env", EnvPrecedenceHigh},
+			{secretPrefix + "<NAME>", "secret exposed to templates by {{ secret \"<NAME>\" }}, from the environment only", ""},
+			{"EVO_REQUIRE_CONFIRM", "when set to 1, password resets and migrators which DROP or TRUNCATE require --yes", ""},
+			{"EVO_BATCH_SIZE", "statements per round trip of migrators carrying the directive '-- evo: batch'", strconv.Itoa(defaultBatchSize)},
+			{"EVO_SERIALIZATION_RETRIES", "times a transactional migrator is retried on serialization failure or deadlock", "0"},
+			{"EVO_HEARTBEAT_INTERVAL", "seconds between progress messages while a migrator executes, 0 disables", seconds(defaultHeartbeatInterval)},
+			{"EVO_SHOW_NOTICES", "when set to 1, NOTICE and WARNING messages raised by migrators are logged", ""},
+		},
+	},
+	{
+		title: "tracking",
+		settings: []settingHelp{
+			{"EVO_TRACK_BY", "'name' tracks applied migrators by filename, 'version' by numeric prefix", TrackByName},
+			{"EVO_PER_DIRECTORY", "when set to 1, up migrates each subdirectory holding migrators independently, tracked in evo_<subdirectory>, prefixed by EVO_MIGRATION_SCHEMA when set", ""},
+			{"EVO_MIGRATION_SCHEMA", "schema in which the evo_mg migration table lives", defaultMigrationSchema},
+			{"EVO_SYSTEM_TABLE_OWNER", "role made the owner of the evo_mg and lock tables", ""},
+			{"EVO_RELEASE", "release label recorded against each migrator applied during the run", ""},
+			{"EVO_RUN_ID", "identifier of the run recorded against each applied migrator", "a uuid"},
+			{"EVO_AUDIT", "when set to 1, every start, success, failure, rollback and password reset is recorded in evo_audit", ""},
+			{"EVO_RECORD_OBJECTS", "when set to 1, objects created by each migrator are recorded for the blame command", ""},
+			{"EVO_FAIL_ON_MISSING_APPLIED", "when set to 1, a run fails if an applied migrator's file has been removed", ""},
+		},
+	},
+	{
+		title: "reporting",
+		settings: []settingHelp{
+			{"EVO_METRICS_TEXTFILE", "path of a prometheus textfile (.prom) written with the outcome of each run", ""},
+			{"EVO_MANIFEST_OUT", "path of a json manifest written with the migrators applied by the run", ""},
+			{"EVO_NOTIFY_CHANNEL", "channel notified with a json summary once a run applies migrators", ""},
+			{"EVO_CHECKPOINT_FILE", "file which is truncated on each run and appended with each committed migrator", ""},
+		},
+	},
+	{
+		title: "locking",
+		settings: []settingHelp{
+			{"EVO_LOCK_MODE", "'table' locks a row of a lock table, 'advisory' uses pg_advisory_lock", LockModeTable},
+			{"EVO_ON_LOCK_HELD", "when another run holds the lock: 'block' waits, 'fail' fails at once, 'retry' retries until the timeout", LockHeldBlock},
+			{"EVO_LOCK_RETRY_TIMEOUT", "seconds for which the lock is retried when EVO_ON_LOCK_HELD is 'retry'", seconds(defaultLockRetryTimeout)},
+			{"EVO_LOCK_KEY_SPACE", "prefix hashed with the database into the key of an advisory lock, separating independent users of a cluster.  the key changed from hashtext to sha256, runners of earlier releases are not excluded", ""},
+			{"EVO_LOCK_SCHEMA", "schema of the lock table in the postgres database (table lock mode only)", ""},
+		},
+	},
+}
+
+// helpColumn is the width of the name column of the help, longer names are followed by the description on a
+// line of its own
+const helpColumn = 29
+
+// writeHelp writes the usage, the commands and every setting, by group
+func writeHelp(w io.Writer) {
+	fmt.Fprintf(w, "usage:\nevo [command] <directory|archive|url>\n\n")
+	fmt.Fprintf(w, "commands:\n")
+	for _, name := range commandNames() {
+		fmt.Fprintf(w, "    %-24s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "each migrator file is treated as a go template, the environment is the dictionary\n")
+	fmt.Fprintf(w, "migrators are executed in ascending alphabetical order\n")
+	fmt.Fprintf(w, "when EVO_ENV is set, migrators in the <directory>/<EVO_ENV> subdirectory follow the common ones\n")
+	fmt.Fprintf(w, "configuration comes from the environment:\n")
+	for _, group := range settingGroups {
+		fmt.Fprintf(w, "\n  %s:\n", group.title)
+		for _, setting := range group.settings {
+			description := setting.description
+			if len(setting.defaultValue) > 0 {
+				description += fmt.Sprintf(" (default %s)", setting.defaultValue)
+			}
+
+			name := "    " + setting.name
+			if len(name) >= helpColumn {
+				fmt.Fprintf(w, "%s\n%*s", name, helpColumn, "")
+			} else {
+				fmt.Fprintf(w, "%-*s", helpColumn, name)
+			}
+			fmt.Fprintf(w, "%s\n", description)
+		}
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+func printHelp() {
+	writeHelp(os.Stdout)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// consultedSettings returns every setting named by the code, as the string literals it reads them by
+func consultedSettings(t *testing.T) map[string]struct{} {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	assert.NoError(t, err)
+
+	literal := regexp.MustCompile(`"(EVO_[A-Z0-9_]+)"`)
+	settings := map[string]struct{}{}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		for _, match := range literal.FindAllStringSubmatch(string(source), -1) {
+			settings[match[1]] = struct{}{}
+		}
+	}
+	assert.NotEmpty(t, settings)
+
+	return settings
+}
+
+func TestHelpListsEverySetting(t *testing.T) {
+	var out bytes.Buffer
+	writeHelp(&out)
+	help := out.String()
+
+	consulted := consultedSettings(t)
+	for setting := range consulted {
+		assert.Regexp(t, regexp.MustCompile(`(?m)^\s+`+regexp.QuoteMeta(setting)), help, "setting %s is missing from the help", setting)
+	}
+
+	// nor does the help list settings which are no longer read
+	for _, group := range settingGroups {
+		for _, setting := range group.settings {
+			if strings.HasPrefix(setting.name, secretPrefix) {
+				continue
+			}
+			assert.Contains(t, consulted, setting.name, "setting %s is no longer read", setting.name)
+		}
+	}
+
+	for name := range commands {
+		assert.Contains(t, help, "    "+name+" ")
+	}
+
+	// defaults are taken from the settings' own constants, durations in seconds
+	assert.Contains(t, help, "seconds to wait for the database to appear (default 300)")
+	assert.Contains(t, help, "while a migrator executes, 0 disables (default 30)")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DirectiveIgnoreErrors lists the SQLSTATE codes which, when raised by a statement of the migrator, are logged
+// and the statement treated as successful, e.g. "-- evo: ignore-errors=42P07,42710"
+const DirectiveIgnoreErrors string = "ignore-errors"
+
+// parseIgnoreErrors returns the SQLSTATE codes tolerated by a migrator carrying the ignore-errors directive
+func parseIgnoreErrors(sql string) ([]string, error) {
+	value, ok := parseDirectives(sql)[DirectiveIgnoreErrors]
+	if !ok {
+		return nil, nil
+	}
+
+	var codes []string
+	for _, code := range strings.Split(value, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if len(code) == 0 {
+			continue
+		}
+		if !isSQLState(code) {
+			return nil, fmt.Errorf("'%s' is not a SQLSTATE code, expected five letters or digits, e.g. 42P07", code)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// isSQLState reports whether code has the form of a SQLSTATE, five digits or upper case letters
+func isSQLState(code string) bool {
+	if len(code) != 5 {
+		return false
+	}
+	for _, c := range code {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseIgnoreErrors(t *testing.T) {
+	codes, err := parseIgnoreErrors("-- evo: ignore-errors=42P07,42710\nCREATE TABLE things (id INT);")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"42P07", "42710"}, codes)
+
+	codes, err = parseIgnoreErrors("CREATE TABLE things (id INT);")
+	assert.NoError(t, err)
+	assert.Empty(t, codes)
+
+	_, err = parseIgnoreErrors("-- evo: ignore-errors=duplicate_table")
+	assert.Error(t, err)
+}
+
+func TestIgnoreErrors(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	// the duplicate table (42P07) is tolerated, within a transaction and outside of one
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+		"0002_tolerated.sql": `-- evo: ignore-errors=42P07
+CREATE TABLE first (id INT);
+CREATE TABLE second (id INT);`,
+		"0003_tolerated_notrans.sql": `-- evo: ignore-errors=42P07
+CREATE TABLE second (id INT);
+CREATE TABLE third (id INT);`,
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	var secondExists, thirdExists bool
+	err = conn.QueryRow(context.Background(), "SELECT to_regclass('second') IS NOT NULL, to_regclass('third') IS NOT NULL").Scan(&secondExists, &thirdExists)
+	assert.NoError(t, err)
+	assert.True(t, secondExists)
+	assert.True(t, thirdExists)
+
+	// any other error still fails the migrator
+	writeMigrators(t, config.Directory, map[string]string{
+		"0004_untolerated.sql": `-- evo: ignore-errors=42P07
+CREATE TABLE fourth (id INT);
+INSERT INTO missing VALUES (1);`,
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "statement 2 of 2 (INSERT INTO missing VALUES (1)) failed")
+
+	var fourthExists bool
+	err = conn.QueryRow(context.Background(), "SELECT to_regclass('fourth') IS NOT NULL").Scan(&fourthExists)
+	assert.NoError(t, err)
+	assert.False(t, fourthExists)
+}
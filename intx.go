@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// migrateInTx applies the pending migrators within tx, a transaction begun by the caller, who commits or rolls
+// it back along with whatever else it holds.  no lock is taken, concurrency is the caller's concern, and the
+// database and user are expected to exist already.  a non-transactional migrator cannot be applied within the
+// caller's transaction, so none are applied while one is pending.
+func migrateInTx(tx pgx.Tx, config *Config) error {
+	// statements on the transaction's connection execute within it
+	conn := tx.Conn()
+
+	existingMigrators, err := ensureMigratorTable(conn, config)
+	if err != nil {
+		return err
+	}
+	if config.RecordObjects {
+		err = ensureObjectsTable(conn, config)
+		if err != nil {
+			return err
+		}
+	}
+	config.AppliedBefore = maps.Clone(existingMigrators)
+
+	matches, err := findMigrators(config)
+	if err != nil {
+		return err
+	}
+
+	data, err := getTemplateData(config)
+	if err != nil {
+		return err
+	}
+
+	// the migrators are selected as a run would select them
+	selected, err := selectMigrators(config, matches, existingMigrators)
+	if err != nil {
+		return err
+	}
+	for _, migrator := range selected {
+		transactional, err := isTransactional(config, migrator.match, migrator.source)
+		if err != nil {
+			return err
+		}
+		if !transactional {
+			return fmt.Errorf("migrator '%s' is non-transactional and cannot be applied within the caller's transaction", migratorName(config, migrator.match))
+		}
+	}
+
+	for _, migrator := range selected {
+		migName := migratorName(config, migrator.match)
+		logf("executing migrator '%s' within the caller's transaction...\n", migName)
+		rendered, err := renderMigrator(config, migrator.match, migName, data)
+		if err != nil {
+			return &ErrMigratorFailed{Name: migName, Err: err}
+		}
+		err = preprocess(config, rendered, migName)
+		if err != nil {
+			return &ErrMigratorFailed{Name: migName, Err: err}
+		}
+		err = validateTransactionalSQL(migName, rendered.SQL)
+		if err != nil {
+			return &ErrMigratorFailed{Name: migName, Err: err}
+		}
+
+		subsumed := parseSubsumes(migrator.source)
+		if len(subsumed) > 0 {
+			// the baseline's own transaction becomes a savepoint within the caller's
+			err = applyBaseline(rendered, tx, config, migName, subsumed, existingMigrators)
+		} else {
+			err = executeMigrator(rendered, tx, config, migName)
+		}
+		if err != nil {
+			return &ErrMigratorFailed{Name: migName, Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestMigrateInTx(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	// the database and user are set up by a regular run
+	config.Directory = t.TempDir()
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT);",
+		"0002_seed.sql":       "INSERT INTO things VALUES (1);",
+	})
+
+	tx, err := conn.Begin(context.Background())
+	assert.NoError(t, err)
+	err = migrateInTx(tx, config)
+	assert.NoError(t, err)
+
+	// the migrators are visible within the transaction
+	var count int
+	err = tx.QueryRow(context.Background(), "SELECT count(*) FROM things").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// and rolled back along with it
+	err = tx.Rollback(context.Background())
+	assert.NoError(t, err)
+
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, pastMigrations)
+
+	var exists bool
+	err = conn.QueryRow(context.Background(), "SELECT to_regclass('things') IS NOT NULL").Scan(&exists)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// a non-transactional migrator cannot be applied within the caller's transaction
+	writeMigrators(t, config.Directory, map[string]string{
+		"0003_add_index_notrans.sql": "CREATE INDEX CONCURRENTLY ix_things ON things (id);",
+	})
+	tx, err = conn.Begin(context.Background())
+	assert.NoError(t, err)
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+	err = migrateInTx(tx, config)
+	assert.ErrorContains(t, err, "0003_add_index_notrans.sql' is non-transactional")
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// LockModeTable locks a row of the evo_advisory_locks table, compatible with postgres and cockroachdb
+	LockModeTable string = "table"
+	// LockModeAdvisory uses a session level pg_advisory_lock, requiring no table at all
+	LockModeAdvisory string = "advisory"
+)
+
+const (
+	// LockHeldBlock waits for a lock held by another run for as long as it takes
+	LockHeldBlock string = "block"
+	// LockHeldFail fails at once with ErrLockContended when the lock is held by another run
+	LockHeldFail string = "fail"
+	// LockHeldRetry tries for the lock repeatedly until EVO_LOCK_RETRY_TIMEOUT, then fails with ErrLockContended
+	LockHeldRetry string = "retry"
+)
+
+// defaultLockRetryTimeout is how long the lock is retried for when EVO_LOCK_RETRY_TIMEOUT is not set
+const defaultLockRetryTimeout = 60 * time.Second
+
+// lockRetryInterval is the pause between attempts at a lock held by another run
+var lockRetryInterval = time.Second
+
+// lockKeepaliveInterval is how often the session holding the lock touches the server, so that one which has not
+// done so for several intervals can be recognised as orphaned by unlock
+var lockKeepaliveInterval = 30 * time.Second
+
+// keepLockAlive runs ping every lockKeepaliveInterval until the returned function is called
+func keepLockAlive(ping func() error) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockKeepaliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_ = ping()
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// lockTableName returns the (optionally schema qualified) name of the lock table
+func lockTableName(config *Config) string {
+	if len(config.LockSchema) > 0 {
+		return pgx.Identifier{config.LockSchema, "evo_advisory_locks"}.Sanitize()
+	}
+	return "evo_advisory_locks"
+}
+
+// ensureLockTable locks the row of lockName in the lock table, creating both as needed, within the returned
+// transaction.  with nowait, a row locked by another run fails at once with lock_not_available.
+func ensureLockTable(conn *pgx.Conn, tableName string, lockName string, owner string, nowait bool) (pgx.Tx, error) {
+	// create the table but drop errors if they occur, as this will result in a race condition over the name
+	// index in the event of a parallel creation.  the rest of the logic below will accomplish the locking
+	// needed to prevent further racing
+	_, _ = conn.Exec(context.Background(), fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name TEXT PRIMARY KEY)", tableName))
+
+	// ownership must be settled before the row lock is taken, as altering the table waits on other lock holders
+	if len(owner) > 0 {
+		err := ensureTableOwner(conn, tableName, owner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("INSERT INTO %s (name) VALUES ($1) ON CONFLICT DO NOTHING", tableName), lockName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write advisory lock entry: %w", err)
+	}
+
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("SELECT name FROM %s WHERE name = $1 FOR UPDATE", tableName)
+	if nowait {
+		query += " NOWAIT"
+	}
+	_, err = tx.Exec(context.Background(), query, lockName)
+	if err != nil {
+		_ = tx.Rollback(context.Background())
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// lockName returns the name of the lock of a run, which is the database, qualified by the migration set when evo
+// is run per directory so that each set is locked independently
+func lockName(config *Config) string {
+	if len(config.MigrationSet) > 0 {
+		return config.Database + "/" + config.MigrationSet
+	}
+	return config.Database
+}
+
+// advisoryLockKey returns the key of the advisory lock of a run: the first 64 bits of the sha256 of the lock name,
+// prefixed by EVO_LOCK_KEY_SPACE when set.  the full bigint key space of pg_advisory_lock makes collisions between
+// unrelated locks far less likely than the 32 bits of hashtext, and a key space separates the locks of independent
+// users of a cluster altogether, even where their database names are the same.  earlier releases keyed the lock by
+// hashtext, so their runners and these do not exclude each other, see the readme.
+func advisoryLockKey(config *Config) int64 {
+	sum := sha256.Sum256([]byte(config.LockKeySpace + "\x00" + lockName(config)))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// waitForLock makes attempts at a lock held by another run according to EVO_ON_LOCK_HELD: a single one when
+// failing fast, or one a second until EVO_LOCK_RETRY_TIMEOUT when retrying.  try reports whether it obtained the
+// lock.
+func waitForLock(config *Config, try func() (bool, error)) error {
+	deadline := time.Now().Add(config.LockRetryTimeout)
+	for {
+		acquired, err := try()
+		if err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+		if config.OnLockHeld != LockHeldRetry || !time.Now().Before(deadline) {
+			return withKind(ErrLockContended, fmt.Errorf("the lock for '%s' is held by another run", lockName(config)))
+		}
+		logf("the lock for '%s' is held by another run, retrying\n", lockName(config))
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// acquireLock takes out a lock namespaced to the configured database, blocking until it is available unless
+// EVO_ON_LOCK_HELD says otherwise.  the returned function releases the lock.
+func acquireLock(conn *pgx.Conn, config *Config) (func(), error) {
+	block := len(config.OnLockHeld) == 0 || config.OnLockHeld == LockHeldBlock
+	if config.LockMode == LockModeAdvisory {
+		if block {
+			_, err := conn.Exec(context.Background(), "SELECT pg_advisory_lock($1)", advisoryLockKey(config))
+			if err != nil {
+				return nil, lockError(fmt.Errorf("unable to obtain advisory lock: %w", err))
+			}
+		} else {
+			err := waitForLock(config, func() (bool, error) {
+				var locked bool
+				err := conn.QueryRow(context.Background(), "SELECT pg_try_advisory_lock($1)", advisoryLockKey(config)).Scan(&locked)
+				if err != nil {
+					return false, fmt.Errorf("unable to obtain advisory lock: %w", err)
+				}
+				return locked, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		stopKeepalive := keepLockAlive(func() error {
+			_, err := conn.Exec(context.Background(), "SELECT 1")
+			return err
+		})
+		return func() {
+			stopKeepalive()
+			_, _ = conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryLockKey(config))
+		}, nil
+	}
+
+	// ensures the locking schema exists and takes out a simulated advisory lock
+	var tx pgx.Tx
+	var err error
+	if block {
+		tx, err = ensureLockTable(conn, lockTableName(config), lockName(config), config.SystemTableOwner, false)
+		if err != nil {
+			return nil, lockError(err)
+		}
+	} else {
+		err = waitForLock(config, func() (bool, error) {
+			tx, err = ensureLockTable(conn, lockTableName(config), lockName(config), config.SystemTableOwner, true)
+			if hasCode(err, "55P03") {
+				return false, nil
+			}
+			return err == nil, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stopKeepalive := keepLockAlive(func() error {
+		_, err := tx.Exec(context.Background(), "SELECT 1")
+		return err
+	})
+	return func() {
+		stopKeepalive()
+		_ = tx.Rollback(context.Background())
+	}, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestLockTableSchema(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	maintenanceConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = maintenanceConn.Close(context.Background())
+	}()
+
+	_, err = maintenanceConn.Exec(context.Background(), "CREATE SCHEMA evo_locks")
+	assert.NoError(t, err)
+
+	config.LockSchema = "evo_locks"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	var inLockSchema, inPublic bool
+	err = maintenanceConn.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = 'evo_locks' AND table_name = 'evo_advisory_locks'), EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = 'public' AND table_name = 'evo_advisory_locks')").Scan(&inLockSchema, &inPublic)
+	assert.NoError(t, err)
+	assert.True(t, inLockSchema)
+	assert.False(t, inPublic)
+}
+
+func TestAdvisoryLockConcurrent(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.LockMode = LockModeAdvisory
+	wg := sync.WaitGroup{}
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := doMigration(config, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	maintenanceConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = maintenanceConn.Close(context.Background())
+	}()
+
+	var lockTableExists bool
+	err = maintenanceConn.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'evo_advisory_locks')").Scan(&lockTableExists)
+	assert.NoError(t, err)
+	assert.False(t, lockTableExists)
+}
+
+func TestAdvisoryLockKey(t *testing.T) {
+	config := &Config{Database: "testdb"}
+	key := advisoryLockKey(config)
+	assert.Equal(t, key, advisoryLockKey(&Config{Database: "testdb"}))
+	assert.NotEqual(t, key, advisoryLockKey(&Config{Database: "otherdb"}))
+	assert.NotEqual(t, key, advisoryLockKey(&Config{Database: "testdb", LockKeySpace: "billing"}))
+	assert.NotEqual(t, key, advisoryLockKey(&Config{Database: "testdb", MigrationSet: "audit"}))
+}
+
+func TestLockKeySpace(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.LockMode = LockModeAdvisory
+	config.LockKeySpace = "billing"
+	holderConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = holderConn.Close(context.Background())
+	}()
+	release, err := acquireLock(holderConn, config)
+	assert.NoError(t, err)
+	defer release()
+
+	// a run in the same key space is held off
+	config.OnLockHeld = LockHeldFail
+	err = doMigration(config, nil)
+	assert.ErrorIs(t, err, ErrLockContended)
+
+	// while one in another key space is not, for the same database
+	config.LockKeySpace = "shipping"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+}
+
+func TestOnLockHeld(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	previousInterval := lockRetryInterval
+	lockRetryInterval = 100 * time.Millisecond
+	defer func() {
+		lockRetryInterval = previousInterval
+	}()
+
+	for _, lockMode := range []string{LockModeTable, LockModeAdvisory} {
+		t.Run(lockMode, func(t *testing.T) {
+			config.LockMode = lockMode
+
+			// the first runner holds the lock throughout
+			holderConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+			assert.NoError(t, err)
+			defer func() {
+				_ = holderConn.Close(context.Background())
+			}()
+			release, err := acquireLock(holderConn, config)
+			assert.NoError(t, err)
+
+			config.OnLockHeld = LockHeldFail
+			start := time.Now()
+			err = doMigration(config, nil)
+			assert.ErrorIs(t, err, ErrLockContended)
+			assert.Less(t, time.Since(start), 5*time.Second)
+
+			config.OnLockHeld = LockHeldRetry
+			config.LockRetryTimeout = 500 * time.Millisecond
+			err = doMigration(config, nil)
+			assert.ErrorIs(t, err, ErrLockContended)
+
+			// the second runner obtains the lock once the first lets go, retrying or blocking
+			for _, onLockHeld := range []string{LockHeldRetry, LockHeldBlock} {
+				if release == nil {
+					release, err = acquireLock(holderConn, config)
+					assert.NoError(t, err)
+				}
+				config.OnLockHeld = onLockHeld
+				config.LockRetryTimeout = 30 * time.Second
+				go func(release func()) {
+					time.Sleep(time.Second)
+					release()
+				}(release)
+				release = nil
+				err = doMigration(config, nil)
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
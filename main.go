@@ -1,29 +1,245 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"html/template"
+	"io"
+	"io/fs"
+	"maps"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type Config struct {
-	Directory          string
-	Hostname           string
-	Database           string
-	AdminUsername      string
-	AdminPassword      string
-	Username           string
-	Password           string
+	// Directory is the migrator directory, or the path of a migrator archive
+	Directory string
+	// FS, when set, holds the migrators in place of the directory, e.g. the contents of an archive
+	FS fs.FS
+	// Hostname is "<host>:<port>" or a socket directory, an SRV record having been resolved to one of its targets
+	// as the configuration was read
+	Hostname      string
+	Database      string
+	AdminUsername string
+	AdminPassword string
+	Username      string
+	Password      string
+	// Params are extra connection parameters added to every connection url
+	Params             url.Values
 	AutoUpdatePassword bool
+	RegrantAlways      bool
+	// TemplateVarsFiles are json or yaml files merged, in order, into the template dictionary
+	TemplateVarsFiles []string
+	// TemplateEnvPrecedence determines whether the environment overrides template vars files or vice versa
+	TemplateEnvPrecedence string
+	// TemplateAllow, when set, limits the environment variables placed in the template dictionary to those named
+	TemplateAllow []string
+	// FileEncoding is the encoding of migrator files lacking a byte order mark, defaults to utf-8
+	FileEncoding string
+	// CheckpointFile, when set, receives the name of each migrator as it is committed
+	CheckpointFile string
+	// ObjectPrivileges are the default privileges the user is granted on each kind of object, ALL when not listed
+	ObjectPrivileges []ObjectPrivileges
+	// DefaultPrivilegeRoles are additional roles granted default privileges on tables created by the user
+	DefaultPrivilegeRoles []RolePrivileges
+	CreateMissingRoles    bool
+	// LockMode is either LockModeTable or LockModeAdvisory
+	LockMode string
+	// OnLockHeld is what happens when another run holds the lock: LockHeldBlock, LockHeldFail or LockHeldRetry
+	OnLockHeld string
+	// LockRetryTimeout is how long the lock is retried for when OnLockHeld is LockHeldRetry
+	LockRetryTimeout time.Duration
+	// LockKeySpace prefixes the name of the lock before it is hashed into the key of an advisory lock
+	LockKeySpace string
+	// LockSchema is the schema holding the lock table, when empty the connection's search_path applies
+	LockSchema string
+	// Env selects a subdirectory of environment specific migrators, applied after the common ones
+	Env string
+	// TrackBy is either TrackByName or TrackByVersion
+	TrackBy string
+	// TargetVersion, when set, limits migration to migrators up to and including the version
+	TargetVersion *int64
+	// Label, when set, limits migration to migrators carrying the label
+	Label string
+	// ContinueOnStatementError skips the failing statements of migrators executed with savepoints, set by
+	// --continue-on-statement-error
+	ContinueOnStatementError bool
+	// Bootstrap ends a run once the database and user are provisioned, before evo_mg is created or any migrator
+	// is applied
+	Bootstrap bool
+	// Since, when set, limits reporting to migrators applied at or after the time
+	Since *time.Time
+	// Release labels each migrator applied during the run, e.g. with a git tag or build number
+	Release string
+	// MigrationSchema is the schema holding the evo_mg table, defaults to public
+	MigrationSchema string
+	// PerDirectory migrates each immediate subdirectory holding migrators as an independent set, with its own
+	// evo_mg table and lock
+	PerDirectory bool
+	// MigrationSet is the subdirectory of the set being migrated when migrating per directory
+	MigrationSet string
+	// UserConnectionLimit, when set, limits the number of concurrent connections of the user
+	UserConnectionLimit *int
+	// UserValidUntil, when set, is the time after which the user's password is no longer valid
+	UserValidUntil string
+	// UserRoleMembership are group roles the user is made a member of
+	UserRoleMembership []string
+	// BatchSize is the number of statements per round trip of migrators carrying the batch directive
+	BatchSize int
+	// GrantRole is a group role, of which the user is made a member, granted default privileges alongside the user
+	GrantRole string
+	// GrantRoleOnly grants default privileges to GrantRole in place of the user
+	GrantRoleOnly bool
+	// SystemTableOwner, when set, is made the owner of the evo_mg and lock tables
+	SystemTableOwner string
+	// ForbidSuperuser refuses to migrate as a user which is a superuser
+	ForbidSuperuser bool
+	// RequirePrimary refuses to migrate a database which is in recovery
+	RequirePrimary bool
+	// ReadyTimeout is how long to wait for a database in recovery to be promoted
+	ReadyTimeout time.Duration
+	// SerializationRetries is how many times a transactional migrator is retried after a serialization failure
+	// or deadlock
+	SerializationRetries int
+	// HeartbeatInterval is how often progress is logged while a migrator executes, zero disables heartbeats
+	HeartbeatInterval time.Duration
+	// ShowNotices logs NOTICE and WARNING messages raised by the server while migrating
+	ShowNotices bool
+	// Databases are all of the configured databases, when several are configured Database is the first of them
+	Databases []string
+	// Parallel is the maximum number of databases migrated at once
+	Parallel int
+	// FailFast stops starting further databases once one has failed
+	FailFast bool
+	// SkipCreateDatabase leaves the creation of the database to another system, evo never issues CREATE DATABASE
+	SkipCreateDatabase bool
+	// WaitForDatabase polls for a missing database which evo cannot create, until DatabaseWaitTimeout elapses
+	WaitForDatabase bool
+	// DatabaseWaitTimeout is how long to wait for a missing database to appear
+	DatabaseWaitTimeout time.Duration
+	// RequireConfirm guards password resets and migrators which drop or truncate objects behind a confirmation
+	RequireConfirm bool
+	// Confirmed is set by --yes, confirming destructive operations up front
+	Confirmed bool
+	// ExternalUser leaves the user entirely to an external system, evo neither creates it, syncs its password nor
+	// grants it privileges
+	ExternalUser bool
+	// MetricsTextfile is the path of a prometheus textfile written with the outcome of each run
+	MetricsTextfile string
+	// RecordObjects records the objects created by each migrator in evo_mg_objects, backing the blame command
+	RecordObjects bool
+	// SkipUserVerify trusts the user's credentials: the user connects once, for the migration itself, and a
+	// rejected password fails the run rather than being reset
+	SkipUserVerify bool
+	// AllowMaintenanceDB permits migrating the postgres maintenance database, which is otherwise refused
+	AllowMaintenanceDB bool
+	// Audit records every action, whether it succeeded or failed, in the evo_audit table
+	Audit bool
+	// FailOnEmpty fails a run when no migrator files are found, rather than only logging it
+	FailOnEmpty bool
+	// FailOnMissingApplied fails a run when an applied migrator's file has been removed from the directory
+	FailOnMissingApplied bool
+	// TransactOptIn executes migrators outside a transaction unless they opt in, set by EVO_DEFAULT_TRANSACTION
+	TransactOptIn bool
+	// RunID identifies the invocation in place of a generated uuid, recorded in the run_id column of evo_mg
+	RunID string
+	// ManifestOut is the path of a json manifest written with the migrators applied by the run
+	ManifestOut string
+	// NotifyChannel is the channel notified, with a json summary, once a run applies migrators
+	NotifyChannel string
+	// SessionRole is switched to as soon as the user connects, so that the work of every migrator is done, and the
+	// objects it creates are owned, by it
+	SessionRole string
+	// SSLCert and SSLKey are the client certificate and key presented to the server, authenticating in place of
+	// the passwords
+	SSLCert string
+	SSLKey  string
+	// SSLRootCert is the certificate authority the server's certificate is verified against
+	SSLRootCert string
+	// Clock supplies the time recorded in the created_at column of evo_mg and exposed to templates as .Now, the
+	// system clock when nil
+	Clock func() time.Time
+	// Progress, when set, is called on each transition of a run, for embedding evo in a user interface
+	Progress ProgressFunc
+	// Preprocess, when set, rewrites the rendered sql of each migrator before it executes
+	Preprocess PreprocessFunc
+	// FilenamePattern, when set, must match the whole file name of every migrator, set by EVO_FILENAME_PATTERN
+	FilenamePattern *regexp.Regexp
+	// AppliedBefore holds the keys of the migrators applied before the run, backing the applied template function
+	AppliedBefore map[string]struct{}
+}
+
+// reservedParams are the connection parameters naming the server, database and role, which are set from evo's own
+// settings and would otherwise be silently overridden by EVO_DB_PARAMS
+var reservedParams = []string{"user", "password", "dbname", "host", "port"}
+
+// connUrl assembles a connection url, extra connection parameters are merged in beneath the settings evo
+// manages itself
+func (c *Config) connUrl(username string, password string, db string) string {
+	query := url.Values{}
+	for key, values := range c.Params {
+		query[key] = append([]string{}, values...)
+	}
+
+	for key, value := range map[string]string{"sslcert": c.SSLCert, "sslkey": c.SSLKey, "sslrootcert": c.SSLRootCert} {
+		if len(value) > 0 {
+			query.Set(key, value)
+		}
+	}
+
+	host := c.Hostname
+	if dir, port, ok := socketHost(host); ok {
+		// a socket directory cannot be expressed as the host of a url, it is passed as a parameter instead
+		host = ""
+		query.Set("host", dir)
+		if len(port) > 0 {
+			query.Set("port", port)
+		}
+	}
+
+	user := url.UserPassword(username, password)
+	if len(password) == 0 {
+		user = url.User(username)
+	}
+	connUrl := url.URL{
+		Scheme:   "postgres",
+		User:     user,
+		Host:     host,
+		Path:     "/" + db,
+		RawQuery: query.Encode(),
+	}
+	return connUrl.String()
+}
+
+// socketHost splits a hostname beginning with "/", which names the directory holding the server's unix domain
+// socket, into the directory and the port (which selects the socket file), e.g. "/var/run/postgresql:5433".  the
+// port is empty when it is not given.
+func socketHost(hostname string) (string, string, bool) {
+	if !strings.HasPrefix(hostname, "/") {
+		return "", "", false
+	}
+
+	i := strings.LastIndex(hostname, ":")
+	if i < 0 {
+		return hostname, "", true
+	}
+	port := hostname[i+1:]
+	if len(port) == 0 || strings.Trim(port, "0123456789") != "" {
+		return hostname, "", true
+	}
+	return hostname[:i], port, true
 }
 
 func (c *Config) GetAdminConnUrl(dbOverride ...string) string {
@@ -31,7 +247,7 @@ func (c *Config) GetAdminConnUrl(dbOverride ...string) string {
 	if dbOverride != nil {
 		db = dbOverride[0]
 	}
-	return fmt.Sprintf("postgres://%s:%s@%s/%s", c.AdminUsername, c.AdminPassword, c.Hostname, db)
+	return c.connUrl(c.AdminUsername, c.AdminPassword, db)
 }
 
 func (c *Config) GetUserConnUrl(dbOverride ...string) string {
@@ -39,13 +255,33 @@ func (c *Config) GetUserConnUrl(dbOverride ...string) string {
 	if dbOverride != nil {
 		db = dbOverride[0]
 	}
-	return fmt.Sprintf("postgres://%s:%s@%s/%s", c.Username, c.Password, c.Hostname, db)
+	return c.connUrl(c.Username, c.Password, db)
+}
+
+// logOutput receives progress messages, commands which produce machine readable output on stdout redirect it
+// to stderr
+var logOutput io.Writer = os.Stdout
+
+// logMutex serializes messages logged from concurrently executing migrators, heartbeats and databases
+var logMutex sync.Mutex
+
+func logf(format string, args ...any) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	_, _ = fmt.Fprintf(logOutput, format, args...)
 }
 
 type Executable interface {
 	Exec(ctx context.Context, sql string, arguments ...any) (commandTag pgconn.CommandTag, err error)
 }
 
+// Transactor is satisfied by both a connection and a transaction, beginning a transaction or, within one, a
+// savepoint
+type Transactor interface {
+	Executable
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 func isHelpRequest(args []string) bool {
 	for _, arg := range args {
 		if arg == "-h" || arg == "--help" {
@@ -56,151 +292,839 @@ func isHelpRequest(args []string) bool {
 	return false
 }
 
-func getConfig(directory string) (*Config, error) {
-	info, err := os.Stat(directory)
+// Connections describes which database credentials a command requires
+type Connections int
+
+const (
+	// ConnectNone requires no database configuration at all
+	ConnectNone Connections = iota
+	// ConnectUser requires the database and the non-administrative credentials
+	ConnectUser
+	// ConnectAdmin requires the database and both the administrative and non-administrative credentials
+	ConnectAdmin
+)
+
+// getConfig builds the configuration from the environment, only the connection settings required by the
+// command are mandatory
+func getConfig(directory string, connections Connections) (*Config, error) {
+	var migratorArchive fs.FS
+	if isArchive(directory) {
+		var err error
+		migratorArchive, err = openArchive(directory)
+		if err != nil {
+			return nil, err
+		}
+	} else if !isRemote(directory) {
+		info, err := os.Stat(directory)
+		if err != nil {
+			return nil, fmt.Errorf("unable to access migrator directory '%s': %w", directory, err)
+		}
+
+		if !info.IsDir() {
+			return nil, fmt.Errorf("'%s' is not a directory", directory)
+		}
+	}
+
+	settings, err := loadConfigFile()
 	if err != nil {
-		return nil, fmt.Errorf("unable to access migrator directory '%s': %w", directory, err)
+		return nil, err
 	}
 
-	if !info.IsDir() {
-		return nil, fmt.Errorf("'%s' is not a directory", directory)
+	// a remote source is fetched once the settings, which may name an s3 endpoint, are known.  the endpoint is read
+	// regardless, so that a config file setting it is not taken for misspelled when the source is local.
+	s3Endpoint := settings.get("EVO_S3_ENDPOINT")
+	if isRemote(directory) {
+		migratorArchive, err = openRemote(directory, s3Endpoint)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	database := os.Getenv("EVO_DB_DATABASE")
-	if len(database) == 0 {
+	databases := strings.FieldsFunc(settings.get("EVO_DB_DATABASE"), func(r rune) bool {
+		return r == ','
+	})
+	if len(databases) == 0 && connections >= ConnectUser {
 		return nil, fmt.Errorf("EVO_DB_DATABASE was not defined")
 	}
+	var database string
+	if len(databases) > 0 {
+		database = databases[0]
+	}
 
-	hostname := os.Getenv("EVO_DB_HOST")
-	if len(hostname) == 0 {
+	hostname := settings.get("EVO_DB_HOST")
+	if len(hostname) == 0 && connections >= ConnectUser {
 		return nil, fmt.Errorf("EVO_DB_HOST was not defined")
 	}
+	// an SRV record is resolved once, so that every connection of the run, the lock's included, reaches one server
+	hostname, err = resolveSRVHost(hostname)
+	if err != nil {
+		return nil, err
+	}
 
-	adminUsername := os.Getenv("EVO_DB_ADMIN_USERNAME")
-	if len(adminUsername) == 0 {
+	adminUsername := settings.get("EVO_DB_ADMIN_USERNAME")
+	if len(adminUsername) == 0 && connections >= ConnectAdmin {
 		return nil, fmt.Errorf("EVO_DB_ADMIN_USERNAME was not defined")
 	}
 
-	adminPassword := os.Getenv("EVO_DB_ADMIN_PASSWORD")
-	if len(adminPassword) == 0 {
+	// a client certificate authenticates in place of the passwords, which are then optional
+	sslCert := settings.get("EVO_DB_SSLCERT")
+	sslKey := settings.get("EVO_DB_SSLKEY")
+	if (len(sslCert) > 0) != (len(sslKey) > 0) {
+		return nil, fmt.Errorf("EVO_DB_SSLCERT and EVO_DB_SSLKEY must be defined together")
+	}
+	certAuth := len(sslCert) > 0
+
+	adminPassword := settings.get("EVO_DB_ADMIN_PASSWORD")
+	if len(adminPassword) == 0 && connections >= ConnectAdmin && !certAuth {
 		return nil, fmt.Errorf("EVO_DB_ADMIN_PASSWORD was not defined")
 	}
 
-	username := os.Getenv("EVO_DB_USERNAME")
-	if len(username) == 0 {
+	username := settings.get("EVO_DB_USERNAME")
+	if len(username) == 0 && connections >= ConnectUser {
 		return nil, fmt.Errorf("EVO_DB_USERNAME was not defined")
 	}
 
-	password := os.Getenv("EVO_DB_PASSWORD")
-	if len(password) == 0 {
+	password := settings.get("EVO_DB_PASSWORD")
+	if len(password) == 0 && connections >= ConnectUser && !certAuth {
 		return nil, fmt.Errorf("EVO_DB_PASSWORD was not defined")
 	}
 
+	decryptCmd := settings.get("EVO_PASSWORD_DECRYPT_CMD")
+	if len(decryptCmd) > 0 {
+		if len(adminPassword) > 0 {
+			adminPassword, err = decryptPassword(decryptCmd, "EVO_DB_ADMIN_PASSWORD", adminPassword)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(password) > 0 {
+			password, err = decryptPassword(decryptCmd, "EVO_DB_PASSWORD", password)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	params, err := url.ParseQuery(settings.get("EVO_DB_PARAMS"))
+	if err != nil {
+		return nil, fmt.Errorf("EVO_DB_PARAMS is not a valid query string: %w", err)
+	}
+	for _, key := range reservedParams {
+		if params.Has(key) {
+			return nil, fmt.Errorf("EVO_DB_PARAMS may not set '%s', which evo sets from its own settings", key)
+		}
+	}
+
 	var autoUpdatePassword bool
-	autoUpdatePasswordStr := os.Getenv("EVO_AUTO_UPDATE_PASSWORD")
+	autoUpdatePasswordStr := settings.get("EVO_AUTO_UPDATE_PASSWORD")
 	if autoUpdatePasswordStr == "1" {
 		autoUpdatePassword = true
 	}
 
-	return &Config{
-		Directory:          directory,
-		Hostname:           hostname,
-		Database:           database,
-		Username:           username,
-		Password:           password,
-		AdminUsername:      adminUsername,
-		AdminPassword:      adminPassword,
-		AutoUpdatePassword: autoUpdatePassword,
-	}, nil
-}
-
-func printHelp() {
-	fmt.Printf("usage:\nevo <directory>\n\n")
-	fmt.Printf("each migrator file is treated as a go template, the environment is the dictionary\n")
-	fmt.Printf("migrators are executed in ascending alphabetical order\n")
-	fmt.Printf("configuration comes from the environment:\n")
-	fmt.Printf("    EVO_DB_HOST              database service hostname (<host>:<port>)\n")
-	fmt.Printf("    EVO_DB_ADMIN_USERNAME    database service admin username\n")
-	fmt.Printf("    EVO_DB_ADMIN_PASSWORD    database service admin password\n")
-	fmt.Printf("    EVO_DB_USERNAME          database service username\n")
-	fmt.Printf("    EVO_DB_PASSWORD          database service password\n")
-	fmt.Printf("    EVO_DB_DATABASE          database name\n")
-	fmt.Printf("    EVO_AUTO_UPDATE_PASSWORD when set to 1, user password will be synced to match env value\n")
-	fmt.Printf("\n")
-}
-
-func ensureUser(config *Config) error {
+	var regrantAlways bool
+	regrantAlwaysStr := settings.get("EVO_REGRANT_ALWAYS")
+	if regrantAlwaysStr == "1" {
+		regrantAlways = true
+	}
+
+	templateEnvPrecedence := settings.get("EVO_TEMPLATE_ENV_PRECEDENCE")
+	if len(templateEnvPrecedence) == 0 {
+		templateEnvPrecedence = EnvPrecedenceHigh
+	}
+	if templateEnvPrecedence != EnvPrecedenceHigh && templateEnvPrecedence != EnvPrecedenceLow {
+		return nil, fmt.Errorf("EVO_TEMPLATE_ENV_PRECEDENCE must be one of '%s' or '%s'", EnvPrecedenceHigh, EnvPrecedenceLow)
+	}
+
+	var objectPrivileges []ObjectPrivileges
+	for _, grantable := range grantableObjects {
+		privileges, err := parseObjectPrivileges(grantable.objects, grantable.privileges, settings.get(grantable.setting))
+		if err != nil {
+			return nil, fmt.Errorf("%s is invalid: %w", grantable.setting, err)
+		}
+		objectPrivileges = append(objectPrivileges, ObjectPrivileges{Objects: grantable.objects, Privileges: privileges})
+	}
+
+	defaultPrivilegeRoles, err := parseRolePrivileges(settings.get("EVO_DEFAULT_PRIVILEGE_ROLES"))
+	if err != nil {
+		return nil, fmt.Errorf("EVO_DEFAULT_PRIVILEGE_ROLES is invalid: %w", err)
+	}
+
+	var createMissingRoles bool
+	createMissingRolesStr := settings.get("EVO_CREATE_MISSING_ROLES")
+	if createMissingRolesStr == "1" {
+		createMissingRoles = true
+	}
+
+	lockMode := settings.get("EVO_LOCK_MODE")
+	if len(lockMode) == 0 {
+		lockMode = LockModeTable
+	}
+	if lockMode != LockModeTable && lockMode != LockModeAdvisory {
+		return nil, fmt.Errorf("EVO_LOCK_MODE must be one of '%s' or '%s'", LockModeTable, LockModeAdvisory)
+	}
+
+	onLockHeld := settings.get("EVO_ON_LOCK_HELD")
+	if len(onLockHeld) == 0 {
+		onLockHeld = LockHeldBlock
+	}
+	if onLockHeld != LockHeldBlock && onLockHeld != LockHeldFail && onLockHeld != LockHeldRetry {
+		return nil, fmt.Errorf("EVO_ON_LOCK_HELD must be one of '%s', '%s' or '%s'", LockHeldBlock, LockHeldFail, LockHeldRetry)
+	}
+
+	lockRetryTimeout := defaultLockRetryTimeout
+	lockRetryTimeoutStr := settings.get("EVO_LOCK_RETRY_TIMEOUT")
+	if len(lockRetryTimeoutStr) > 0 {
+		seconds, err := strconv.Atoi(lockRetryTimeoutStr)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("EVO_LOCK_RETRY_TIMEOUT must be a non-negative number of seconds")
+		}
+		lockRetryTimeout = time.Duration(seconds) * time.Second
+	}
+
+	var forbidSuperuser bool
+	forbidSuperuserStr := settings.get("EVO_FORBID_SUPERUSER")
+	if forbidSuperuserStr == "1" {
+		forbidSuperuser = true
+	}
+
+	var requirePrimary bool
+	requirePrimaryStr := settings.get("EVO_REQUIRE_PRIMARY")
+	if requirePrimaryStr == "1" {
+		requirePrimary = true
+	}
+
+	var readyTimeout time.Duration
+	readyTimeoutStr := settings.get("EVO_READY_TIMEOUT")
+	if len(readyTimeoutStr) > 0 {
+		seconds, err := strconv.Atoi(readyTimeoutStr)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("EVO_READY_TIMEOUT must be a non-negative number of seconds")
+		}
+		readyTimeout = time.Duration(seconds) * time.Second
+	}
+
+	var skipCreateDatabase bool
+	skipCreateDatabaseStr := settings.get("EVO_SKIP_CREATE_DATABASE")
+	if skipCreateDatabaseStr == "1" {
+		skipCreateDatabase = true
+	}
+
+	var waitForDatabase bool
+	waitForDatabaseStr := settings.get("EVO_WAIT_FOR_DATABASE")
+	if waitForDatabaseStr == "1" {
+		waitForDatabase = true
+	}
+
+	waitForDatabaseTimeout := defaultDatabaseWaitTimeout
+	waitForDatabaseTimeoutStr := settings.get("EVO_WAIT_FOR_DATABASE_TIMEOUT")
+	if len(waitForDatabaseTimeoutStr) > 0 {
+		seconds, err := strconv.Atoi(waitForDatabaseTimeoutStr)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("EVO_WAIT_FOR_DATABASE_TIMEOUT must be a non-negative number of seconds")
+		}
+		waitForDatabaseTimeout = time.Duration(seconds) * time.Second
+	}
+
+	var externalUser bool
+	manageUserStr := settings.get("EVO_MANAGE_USER")
+	if manageUserStr == "false" || manageUserStr == "0" {
+		externalUser = true
+	}
+
+	var skipUserVerify bool
+	skipUserVerifyStr := settings.get("EVO_SKIP_USER_VERIFY")
+	if skipUserVerifyStr == "1" {
+		skipUserVerify = true
+	}
+
+	var allowMaintenanceDB bool
+	allowMaintenanceDBStr := settings.get("EVO_ALLOW_MAINTENANCE_DB")
+	if allowMaintenanceDBStr == "1" {
+		allowMaintenanceDB = true
+	}
+
+	var perDirectory bool
+	perDirectoryStr := settings.get("EVO_PER_DIRECTORY")
+	if perDirectoryStr == "1" {
+		perDirectory = true
+	}
+
+	var audit bool
+	auditStr := settings.get("EVO_AUDIT")
+	if auditStr == "1" {
+		audit = true
+	}
+
+	var failOnEmpty bool
+	failOnEmptyStr := settings.get("EVO_FAIL_ON_EMPTY")
+	if failOnEmptyStr == "1" {
+		failOnEmpty = true
+	}
+
+	var failOnMissingApplied bool
+	failOnMissingAppliedStr := settings.get("EVO_FAIL_ON_MISSING_APPLIED")
+	if failOnMissingAppliedStr == "1" {
+		failOnMissingApplied = true
+	}
+
+	var transactOptIn bool
+	defaultTransactionStr := settings.get("EVO_DEFAULT_TRANSACTION")
+	if defaultTransactionStr == "false" || defaultTransactionStr == "0" {
+		transactOptIn = true
+	}
+
+	var grantRoleOnly bool
+	grantRoleOnlyStr := settings.get("EVO_GRANT_ROLE_ONLY")
+	if grantRoleOnlyStr == "1" {
+		grantRoleOnly = true
+	}
+
+	var recordObjects bool
+	recordObjectsStr := settings.get("EVO_RECORD_OBJECTS")
+	if recordObjectsStr == "1" {
+		recordObjects = true
+	}
+
+	var requireConfirm bool
+	requireConfirmStr := settings.get("EVO_REQUIRE_CONFIRM")
+	if requireConfirmStr == "1" {
+		requireConfirm = true
+	}
+
+	filenamePattern, err := compileFilenamePattern(settings.get("EVO_FILENAME_PATTERN"))
+	if err != nil {
+		return nil, err
+	}
+
+	var userConnectionLimit *int
+	userConnectionLimitStr := settings.get("EVO_USER_CONNECTION_LIMIT")
+	if len(userConnectionLimitStr) > 0 {
+		limit, err := strconv.Atoi(userConnectionLimitStr)
+		if err != nil || limit < -1 {
+			return nil, fmt.Errorf("EVO_USER_CONNECTION_LIMIT must be an integer of -1 (unlimited) or more")
+		}
+		userConnectionLimit = &limit
+	}
+
+	var serializationRetries int
+	serializationRetriesStr := settings.get("EVO_SERIALIZATION_RETRIES")
+	if len(serializationRetriesStr) > 0 {
+		serializationRetries, err = strconv.Atoi(serializationRetriesStr)
+		if err != nil || serializationRetries < 0 {
+			return nil, fmt.Errorf("EVO_SERIALIZATION_RETRIES must be a non-negative integer")
+		}
+	}
+
+	parallel := defaultParallel
+	parallelStr := settings.get("EVO_PARALLEL")
+	if len(parallelStr) > 0 {
+		parallel, err = strconv.Atoi(parallelStr)
+		if err != nil || parallel < 1 {
+			return nil, fmt.Errorf("EVO_PARALLEL must be a positive integer")
+		}
+	}
+
+	batchSize := defaultBatchSize
+	batchSizeStr := settings.get("EVO_BATCH_SIZE")
+	if len(batchSizeStr) > 0 {
+		batchSize, err = strconv.Atoi(batchSizeStr)
+		if err != nil || batchSize < 1 {
+			return nil, fmt.Errorf("EVO_BATCH_SIZE must be a positive integer")
+		}
+	}
+
+	var failFast bool
+	failFastStr := settings.get("EVO_FAIL_FAST")
+	if failFastStr == "1" {
+		failFast = true
+	}
+
+	heartbeatInterval := defaultHeartbeatInterval
+	heartbeatIntervalStr := settings.get("EVO_HEARTBEAT_INTERVAL")
+	if len(heartbeatIntervalStr) > 0 {
+		seconds, err := strconv.Atoi(heartbeatIntervalStr)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("EVO_HEARTBEAT_INTERVAL must be a non-negative number of seconds")
+		}
+		heartbeatInterval = time.Duration(seconds) * time.Second
+	}
+
+	var showNotices bool
+	showNoticesStr := settings.get("EVO_SHOW_NOTICES")
+	if showNoticesStr == "1" {
+		showNotices = true
+	}
+
+	trackBy := settings.get("EVO_TRACK_BY")
+	if len(trackBy) == 0 {
+		trackBy = TrackByName
+	}
+	if trackBy != TrackByName && trackBy != TrackByVersion {
+		return nil, fmt.Errorf("EVO_TRACK_BY must be one of '%s' or '%s'", TrackByName, TrackByVersion)
+	}
+
+	config := &Config{
+		Directory:             directory,
+		FS:                    migratorArchive,
+		Hostname:              hostname,
+		Database:              database,
+		Databases:             databases,
+		Username:              username,
+		Password:              password,
+		AdminUsername:         adminUsername,
+		AdminPassword:         adminPassword,
+		Params:                params,
+		SessionRole:           settings.get("EVO_SESSION_ROLE"),
+		SSLCert:               sslCert,
+		SSLKey:                sslKey,
+		SSLRootCert:           settings.get("EVO_DB_SSLROOTCERT"),
+		AutoUpdatePassword:    autoUpdatePassword,
+		RegrantAlways:         regrantAlways,
+		TemplateVarsFiles:     splitList(settings.get("EVO_TEMPLATE_VARS_FILES")),
+		TemplateEnvPrecedence: templateEnvPrecedence,
+		TemplateAllow:         splitList(settings.get("EVO_TEMPLATE_ALLOW")),
+		FileEncoding:          settings.get("EVO_FILE_ENCODING"),
+		CheckpointFile:        settings.get("EVO_CHECKPOINT_FILE"),
+		ObjectPrivileges:      objectPrivileges,
+		DefaultPrivilegeRoles: defaultPrivilegeRoles,
+		CreateMissingRoles:    createMissingRoles,
+		LockMode:              lockMode,
+		OnLockHeld:            onLockHeld,
+		LockRetryTimeout:      lockRetryTimeout,
+		LockKeySpace:          settings.get("EVO_LOCK_KEY_SPACE"),
+		LockSchema:            settings.get("EVO_LOCK_SCHEMA"),
+		Env:                   settings.get("EVO_ENV"),
+		TrackBy:               trackBy,
+		Release:               settings.get("EVO_RELEASE"),
+		MigrationSchema:       settings.get("EVO_MIGRATION_SCHEMA"),
+		PerDirectory:          perDirectory,
+		SystemTableOwner:      settings.get("EVO_SYSTEM_TABLE_OWNER"),
+		UserConnectionLimit:   userConnectionLimit,
+		UserValidUntil:        settings.get("EVO_USER_VALID_UNTIL"),
+		UserRoleMembership:    splitList(settings.get("EVO_USER_ROLE_MEMBERSHIP")),
+		BatchSize:             batchSize,
+		GrantRole:             settings.get("EVO_GRANT_ROLE"),
+		GrantRoleOnly:         grantRoleOnly,
+		ForbidSuperuser:       forbidSuperuser,
+		RequirePrimary:        requirePrimary,
+		ReadyTimeout:          readyTimeout,
+		SerializationRetries:  serializationRetries,
+		ShowNotices:           showNotices,
+		HeartbeatInterval:     heartbeatInterval,
+		Parallel:              parallel,
+		FailFast:              failFast,
+		SkipCreateDatabase:    skipCreateDatabase,
+		WaitForDatabase:       waitForDatabase,
+		DatabaseWaitTimeout:   waitForDatabaseTimeout,
+		RequireConfirm:        requireConfirm,
+		MetricsTextfile:       settings.get("EVO_METRICS_TEXTFILE"),
+		ExternalUser:          externalUser,
+		RecordObjects:         recordObjects,
+		SkipUserVerify:        skipUserVerify,
+		AllowMaintenanceDB:    allowMaintenanceDB,
+		Audit:                 audit,
+		FailOnEmpty:           failOnEmpty,
+		FailOnMissingApplied:  failOnMissingApplied,
+		TransactOptIn:         transactOptIn,
+		RunID:                 settings.get("EVO_RUN_ID"),
+		ManifestOut:           settings.get("EVO_MANIFEST_OUT"),
+		NotifyChannel:         settings.get("EVO_NOTIFY_CHANNEL"),
+		FilenamePattern:       filenamePattern,
+	}
+
+	// every setting has been looked up by now
+	err = settings.checkUnknown()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// ensureUser creates the user, or brings an existing one in line with the configuration, reporting whether its
+// password was reset
+func ensureUser(config *Config) (passwordReset bool, err error) {
 	var exists bool
 
-	fmt.Printf("connecting to database '%s'\n", config.Database)
+	logf("connecting to database '%s'\n", config.Database)
 	standardConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
 	if err != nil {
-		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+		return passwordReset, connectError(fmt.Errorf("unable to connect to database '%s': %w", config.Database, err))
 	}
 	defer func() {
 		_ = standardConn.Close(context.Background())
 	}()
 
-	fmt.Printf("checking for existing user '%s'\n", config.Username)
+	logf("checking for existing user '%s'\n", config.Username)
 	row := standardConn.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $1)", config.Username)
 	err = row.Scan(&exists)
 	if err != nil {
-		return fmt.Errorf("unable to query database for existing user by name: %w", err)
+		return passwordReset, fmt.Errorf("unable to query database for existing user by name: %w", err)
 	}
 
 	escapedUsername, err := standardConn.PgConn().EscapeString(config.Username)
 	if err != nil {
-		return err
+		return passwordReset, err
 	}
+	attributes := userAttributes(config)
 	if !exists {
-		fmt.Printf("creating user %s\n", config.Username)
+		logf("creating user %s\n", config.Username)
 		escapedPassword, err := standardConn.PgConn().EscapeString(config.Password)
 		if err != nil {
-			return err
+			return passwordReset, err
+		}
+		passwordClause := fmt.Sprintf("PASSWORD '%s'", escapedPassword)
+		if len(config.Password) == 0 {
+			// a user authenticating by client certificate has no password
+			passwordClause = "PASSWORD NULL"
+		}
+		_, err = standardConn.Exec(context.Background(), fmt.Sprintf("CREATE USER %s WITH %s %s", escapedUsername, passwordClause, attributes))
+		// roles are cluster wide, a runner for another database may have created the user since the check above
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "42710" {
+			logf("user %s was created concurrently\n", config.Username)
+			exists = true
+			err = nil
+		}
+		if err != nil {
+			return passwordReset, fmt.Errorf("unable to create standard user '%s': %w", config.Username, err)
+		}
+	}
+
+	// a user created out of band may have been given another password, which is synced as soon as the user is
+	// found to exist rather than waiting on a failed login
+	if exists && config.AutoUpdatePassword {
+		passwordReset, err = syncUserPassword(standardConn, config)
+		if err != nil {
+			return passwordReset, err
+		}
+	}
+
+	// attributes are reapplied to an existing user so that they follow changes to the configuration
+	if exists && len(attributes) > 0 {
+		logf("updating attributes of user %s\n", config.Username)
+		_, err = standardConn.Exec(context.Background(), fmt.Sprintf("ALTER USER %s WITH %s", escapedUsername, attributes))
+		if err != nil {
+			return passwordReset, fmt.Errorf("unable to update attributes of user '%s': %w", config.Username, err)
+		}
+	}
+
+	err = ensureUserMemberships(standardConn, config)
+	if err != nil {
+		return passwordReset, err
+	}
+
+	_, err = ensureGrantRole(standardConn, config)
+	if err != nil {
+		return passwordReset, err
+	}
+
+	_, err = ensureUserPrivileges(standardConn, config, escapedUsername)
+	if err != nil {
+		return passwordReset, err
+	}
+
+	err = ensureSchemaCreate(standardConn, config)
+	if err != nil {
+		return passwordReset, err
+	}
+
+	_, err = ensureRolePrivileges(standardConn, config)
+	return passwordReset, err
+}
+
+// hasUserPrivileges reports whether the default privileges and schema grants issued by ensureUserPrivileges
+// are already in place for the user.  the default privileges are checked for each of grantees, which defaults to
+// the user alone.
+func hasUserPrivileges(conn *pgx.Conn, config *Config, grantees ...string) (bool, error) {
+	if len(grantees) == 0 {
+		grantees = []string{config.Username}
+	}
+	for _, grantee := range grantees {
+		granted, err := hasDefaultPrivileges(conn, config, "", grantee)
+		if err != nil || !granted {
+			return false, err
 		}
-		_, err = standardConn.Exec(context.Background(), fmt.Sprintf("CREATE USER %s WITH PASSWORD '%s'", escapedUsername, escapedPassword))
+	}
+
+	var canCreate bool
+	row := conn.QueryRow(context.Background(), "SELECT has_schema_privilege($1, 'public', 'CREATE')", config.Username)
+	err := row.Scan(&canCreate)
+	if err != nil {
+		return false, fmt.Errorf("unable to query schema privileges for user '%s': %w", config.Username, err)
+	}
+
+	return canCreate, nil
+}
+
+// ensureUserPrivileges grants the user, the grant role or both default privileges on the public schema, along with
+// CREATE on it to the user, skipping the grants when they are already in place (unless configured to always
+// regrant).  returns true if the grants were issued.
+func ensureUserPrivileges(conn *pgx.Conn, config *Config, escapedUsername string) (bool, error) {
+	grantees := []string{escapedUsername}
+	granteeNames := []string{config.Username}
+	if len(config.GrantRole) > 0 {
+		grantees = append(grantees, pgx.Identifier{config.GrantRole}.Sanitize())
+		granteeNames = append(granteeNames, config.GrantRole)
+		if config.GrantRoleOnly {
+			grantees = grantees[1:]
+			granteeNames = granteeNames[1:]
+		}
+	}
+
+	if !config.RegrantAlways {
+		granted, err := hasUserPrivileges(conn, config, granteeNames...)
 		if err != nil {
-			return fmt.Errorf("unable to create standard user '%s': %w", config.Username, err)
+			return false, err
+		}
+		if granted {
+			logf("privileges for user %s already in place\n", config.Username)
+			return false, nil
 		}
 	}
 
-	fmt.Printf("ensuring privileges for user %s\n", config.Username)
-	statements := fmt.Sprintf(strings.Join([]string{
-		"ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT ALL PRIVILEGES ON TABLES TO %s;",
-		"ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT ALL PRIVILEGES ON SEQUENCES TO %s;",
-		"ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT ALL PRIVILEGES ON FUNCTIONS TO %s;",
-		"GRANT CREATE ON SCHEMA public TO %s;",
-	}, " "), escapedUsername, escapedUsername, escapedUsername, escapedUsername)
+	logf("ensuring privileges for user %s\n", config.Username)
+	var statements []string
+	for _, grantee := range grantees {
+		statements = append(statements, defaultPrivilegeStatements(config, "", grantee)...)
+	}
+	statements = append(statements, fmt.Sprintf("GRANT CREATE ON SCHEMA public TO %s;", escapedUsername))
+
+	_, err := conn.Exec(context.Background(), strings.Join(statements, " "))
+	if err != nil {
+		return false, fmt.Errorf("unable to extend privileges to user '%s': %w", config.Username, err)
+	}
+
+	return true, nil
+}
+
+// resetUserPassword sets the password of the user to the configured one, once confirmed when confirmation is
+// required
+func resetUserPassword(conn *pgx.Conn, config *Config) error {
+	err := confirm(config, fmt.Sprintf("reset the password of user '%s'", config.Username))
+	if err != nil {
+		return err
+	}
+
+	escapedPassword, err := conn.PgConn().EscapeString(config.Password)
+	if err != nil {
+		return err
+	}
+	escapedUsername, err := conn.PgConn().EscapeString(config.Username)
+	if err != nil {
+		return err
+	}
+	logf("updating password for user '%s'\n", config.Username)
+	_, err = conn.Exec(context.Background(), fmt.Sprintf("ALTER USER %s WITH PASSWORD '%s'", escapedUsername, escapedPassword))
+	if err != nil {
+		return fmt.Errorf("unable update password for user '%s': %w", config.Username, err)
+	}
+
+	return nil
+}
+
+// syncUserPassword verifies that an existing user logs in with the configured password, resetting it when not.
+// reports whether the password was reset.
+func syncUserPassword(conn *pgx.Conn, config *Config) (bool, error) {
+	if config.SkipUserVerify {
+		// the password cannot be checked without logging in, so it is trusted, provided the user may log in at all
+		var canLogin bool
+		err := conn.QueryRow(context.Background(), "SELECT rolcanlogin FROM pg_roles WHERE rolname = $1", config.Username).Scan(&canLogin)
+		if err != nil {
+			return false, fmt.Errorf("unable to query whether user '%s' may log in: %w", config.Username, err)
+		}
+		if !canLogin {
+			return false, fmt.Errorf("user '%s' may not log in, grant it with ALTER ROLE %s LOGIN", config.Username, pgx.Identifier{config.Username}.Sanitize())
+		}
+		return false, nil
+	}
+
+	userConn, err := verifyUserPassword(config, nil)
+	if err != nil {
+		return false, connectError(fmt.Errorf("problem with user login: %w", err))
+	}
+	if userConn != nil {
+		_ = userConn.Close(context.Background())
+		return false, nil
+	}
+
+	logf("password of existing user '%s' differs from the configured one\n", config.Username)
+	err = resetUserPassword(conn, config)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyUserPassword connects to the database as the user, returning no connection when the password is rejected
+func verifyUserPassword(config *Config, onNotice pgconn.NoticeHandler) (*pgx.Conn, error) {
+	logf("connecting to database '%s' as user '%s'\n", config.Database, config.Username)
+	connConfig, err := pgx.ParseConfig(config.GetUserConnUrl())
+	if err != nil {
+		return nil, err
+	}
+	connConfig.OnNotice = onNotice
+
+	standardConn, err := pgx.ConnectConfig(context.Background(), connConfig)
+	if err == nil {
+		err = setSessionRole(standardConn, config)
+		if err != nil {
+			_ = standardConn.Close(context.Background())
+			return nil, err
+		}
+		return standardConn, nil
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil, err
+	}
+
+	// rejected credentials are reported as such when they are trusted, rather than prompting a password reset
+	if pgErr.Code != "28P01" || config.SkipUserVerify {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// reconnectIfBroken returns conn if it is still usable, or a new user connection in its place if it has been
+// dropped.  it is only called between migrators, a connection lost while a migrator executes fails that migrator.
+func reconnectIfBroken(conn *pgx.Conn, config *Config, onNotice pgconn.NoticeHandler) (*pgx.Conn, error) {
+	err := conn.Ping(context.Background())
+	if err == nil {
+		return conn, nil
+	}
+
+	logf("user connection lost (%s), reconnecting\n", err.Error())
+	_ = conn.Close(context.Background())
+	newConn, err := verifyUserPassword(config, onNotice)
+	if err != nil {
+		return nil, connectError(fmt.Errorf("unable to reconnect as user '%s': %w", config.Username, err))
+	}
+	if newConn == nil {
+		return nil, withKind(ErrAuthFailed, fmt.Errorf("unable to login as user '%s'", config.Username))
+	}
+
+	return newConn, nil
+}
+
+// globMigrators returns the paths of all migrators directly within the directory, in order of application
+func globMigrators(config *Config, directory string) ([]string, error) {
+	logf("globbing %s for migrators\n", filepath.Join(directory, "*.sql"))
+	err := validateFilenames(config, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	globbed, err := globMigratorFiles(config, directory, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	err = checkCaseCollisions(config, globbed)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]string, 0, len(globbed))
+	for _, match := range globbed {
+		if !isCompanion(match) {
+			matches = append(matches, match)
+		}
+	}
+	sort.Strings(matches)
+
+	return orderMigrators(config, matches)
+}
+
+// orderMigrators sorts migrators, given in filename order, by their explicit order, set by "-- evo-order: <n>", and
+// then by filename.  a migrator without an explicit order takes its position in filename order, counting from 1,
+// so that in a directory of ten, "-- evo-order: 3" places a migrator alongside the third file.  no two migrators
+// may declare the same order.
+func orderMigrators(config *Config, matches []string) ([]string, error) {
+	orders := make(map[string]int, len(matches))
+	declared := map[int]string{}
+	for i, match := range matches {
+		orders[match] = i + 1
+
+		source, err := readMigrator(config, match)
+		if err != nil {
+			return nil, err
+		}
+		order, ok, err := parseOrder(source)
+		if err != nil {
+			return nil, fmt.Errorf("migrator '%s': %w", migratorName(config, match), err)
+		}
+		if !ok {
+			continue
+		}
+		if other, ok := declared[order]; ok {
+			return nil, fmt.Errorf("migrators '%s' and '%s' both declare order %d", migratorName(config, other), migratorName(config, match), order)
+		}
+		declared[order] = match
+		orders[match] = order
+	}
+	if len(declared) == 0 {
+		return matches, nil
+	}
+
+	// the sort is stable, so that migrators of the same order remain in filename order
+	sort.SliceStable(matches, func(i, j int) bool {
+		return orders[matches[i]] < orders[matches[j]]
+	})
+	return matches, nil
+}
 
-	_, err = standardConn.Exec(context.Background(), statements)
+// findMigrators returns the paths of all migrators in order of application.  when an environment is configured,
+// the migrators common to all environments are followed by those in the environment's subdirectory.
+func findMigrators(config *Config) ([]string, error) {
+	matches, err := globMigrators(config, config.Directory)
 	if err != nil {
-		return fmt.Errorf("unable to extend privileges to user '%s': %w", config.Username, err)
+		return nil, err
 	}
 
-	return nil
-}
-
-func verifyUserPassword(config *Config) (*pgx.Conn, error) {
-	fmt.Printf("connecting to database '%s' as user '%s'\n", config.Database, config.Username)
-	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
-	if err == nil {
-		return standardConn, nil
+	if len(config.Env) > 0 {
+		envMatches, err := globMigrators(config, filepath.Join(config.Directory, config.Env))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, envMatches...)
 	}
 
-	var pgErr *pgconn.PgError
-	if !errors.As(err, &pgErr) {
-		return nil, err
-	}
+	return matches, nil
+}
 
-	if pgErr.Code != "28P01" {
-		return nil, err
+// migratorName returns the name under which the migrator at path is recorded, which is its path relative to the
+// migrator directory
+func migratorName(config *Config, path string) string {
+	name, err := filepath.Rel(config.Directory, path)
+	if err != nil {
+		_, name = filepath.Split(path)
 	}
+	return filepath.ToSlash(name)
+}
 
-	return nil, nil
+// isTransactional reports whether the migrator at path, whose source is given, is executed within a transaction.
+// the suffix _notrans.sql opts a migrator out of the default, while the suffix _trans.sql or the directive
+// "-- evo: transaction" opts it in, the default being a transaction unless EVO_DEFAULT_TRANSACTION is false.
+func isTransactional(config *Config, path string, source string) (bool, error) {
+	_, optIn := parseDirectives(source)[DirectiveTransaction]
+	optIn = optIn || strings.HasSuffix(path, "_trans.sql")
+	optOut := strings.HasSuffix(path, "_notrans.sql")
+
+	switch {
+	case optIn && optOut:
+		return false, fmt.Errorf("migrator '%s' both opts in to and out of a transaction", filepath.Base(path))
+	case optIn:
+		return true, nil
+	case optOut:
+		return false, nil
+	}
+	return !config.TransactOptIn, nil
 }
 
-func getPastMigrations(conn *pgx.Conn) (map[string]struct{}, error) {
-	rows, err := conn.Query(context.Background(), "SELECT migrator FROM evo_mg")
+func getPastMigrations(conn *pgx.Conn, config *Config) (map[string]struct{}, error) {
+	rows, err := conn.Query(context.Background(), fmt.Sprintf("SELECT migrator FROM %s", migratorTable(config)))
 	if err != nil {
 		return nil, fmt.Errorf("unable to inquire for existing migrators: %w", err)
 	}
@@ -219,227 +1143,750 @@ func getPastMigrations(conn *pgx.Conn) (map[string]struct{}, error) {
 	return migrators, nil
 }
 
-func ensureMigratorTable(conn *pgx.Conn) (map[string]struct{}, error) {
-	fmt.Printf("checking for evo migration table\n")
+// migratorTableExists reports whether evo_mg exists in the configured migration schema.  the catalog is consulted
+// directly, so the answer depends neither on the connection's search_path nor on the user's privileges on the table.
+func migratorTableExists(conn *pgx.Conn, config *Config) (bool, error) {
 	var exists bool
-	row := conn.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = 'public' AND table_name = 'evo_mg')")
+	row := conn.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace WHERE n.nspname = $1 AND c.relname = 'evo_mg' AND c.relkind IN ('r', 'p'))", migrationSchema(config))
 	err := row.Scan(&exists)
 	if err != nil {
-		return nil, fmt.Errorf("unable to interogate database for evo migrator table: %w", err)
+		return false, fmt.Errorf("unable to interogate database for evo migrator table: %w", err)
+	}
+
+	return exists, nil
+}
+
+// ensureMigratorTable creates the evo migration table if needed, returning the keys of the applied migrators
+func ensureMigratorTable(conn *pgx.Conn, config *Config) (map[string]struct{}, error) {
+	logf("checking for evo migration table\n")
+	exists, err := migratorTableExists(conn, config)
+	if err != nil {
+		return nil, err
 	}
 
 	if !exists {
-		fmt.Printf("creating evo migration table\n")
-		_, err := conn.Exec(context.Background(), "CREATE TABLE evo_mg (migrator TEXT PRIMARY KEY, created_at TIMESTAMPTZ DEFAULT NOW())")
+		// an evo_mg table elsewhere holds history which would be silently forked by creating a new table, unless it
+		// belongs to another migration set, whose history is separate by design
+		otherSchemas, err := findMigratorTableSchemas(conn)
+		if err != nil {
+			return nil, err
+		}
+		if len(otherSchemas) > 0 && len(config.MigrationSet) == 0 {
+			return nil, fmt.Errorf("evo migration table exists in schema '%s' rather than the configured schema '%s', set EVO_MIGRATION_SCHEMA=%s or move the table with ALTER TABLE %s SET SCHEMA %s",
+				otherSchemas[0], migrationSchema(config), otherSchemas[0], pgx.Identifier{otherSchemas[0], "evo_mg"}.Sanitize(), pgx.Identifier{migrationSchema(config)}.Sanitize())
+		}
+
+		logf("creating evo migration table in schema '%s'\n", migrationSchema(config))
+		err = ensureSchema(conn, migrationSchema(config))
+		if err != nil {
+			return nil, err
+		}
+
+		createStatement := "CREATE TABLE %s (migrator TEXT PRIMARY KEY, created_at TIMESTAMPTZ DEFAULT NOW())"
+		if config.TrackBy == TrackByVersion {
+			createStatement = "CREATE TABLE %s (version BIGINT PRIMARY KEY, migrator TEXT NOT NULL, created_at TIMESTAMPTZ DEFAULT NOW())"
+		}
+		_, err = conn.Exec(context.Background(), fmt.Sprintf(createStatement, migratorTable(config)))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		byVersion, err := hasMigratorColumn(conn, config, "version")
+		if err != nil {
+			return nil, err
+		}
+		if byVersion != (config.TrackBy == TrackByVersion) {
+			existingTrackBy := TrackByName
+			if byVersion {
+				existingTrackBy = TrackByVersion
+			}
+			return nil, fmt.Errorf("evo migration table tracks migrators by %s, EVO_TRACK_BY must match the existing table", existingTrackBy)
+		}
+	}
+
+	err = upgradeMigratorTable(conn, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.SystemTableOwner) > 0 {
+		err = ensureTableOwner(conn, migratorTable(config), config.SystemTableOwner)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return getPastMigrations(conn)
+	return getAppliedKeys(conn, config)
 }
 
-func executeMigrator(sql string, conn Executable, migrator string) error {
-	_, err := conn.Exec(context.Background(), sql)
+func executeMigrator(rendered *RenderedMigrator, conn Executable, config *Config, migrator string) error {
+	err := asRole(conn, config, rendered.Role, func() error {
+		return withTriggersDisabled(conn, config, rendered.DisableTriggers, func() error {
+			if rendered.Savepoints || len(rendered.IgnoreErrors) > 0 {
+				err := execStatements(conn, config, rendered, migrator)
+				if err != nil {
+					return err
+				}
+				return copyData(conn, config, migrator, rendered.Copies)
+			}
+			if rendered.BatchSize > 0 {
+				err := execBatches(conn, config, rendered, migrator)
+				if err != nil {
+					return err
+				}
+				return copyData(conn, config, migrator, rendered.Copies)
+			}
+
+			_, err := conn.Exec(context.Background(), rendered.SQL)
+			if err != nil {
+				return schemaPermissionHint(config, err)
+			}
+			return copyData(conn, config, migrator, rendered.Copies)
+		})
+	})
 	if err != nil {
 		return err
 	}
 
 	// after the main code has been executed, execute the migrator adjustment
-	_, err = conn.Exec(context.Background(), "INSERT INTO evo_mg (migrator) VALUES ($1)", migrator)
+	err = recordMigrator(conn, config, migrator, rendered.Checksum)
+	if err != nil {
+		return err
+	}
+
+	if config.RecordObjects {
+		return recordObjects(conn, config, migrator, rendered.SQL)
+	}
+	return nil
+}
+
+// executeTransactionalMigrator executes a migrator and records it within a single transaction
+func executeTransactionalMigrator(rendered *RenderedMigrator, conn *pgx.Conn, config *Config, migName string) error {
+	tx, err := conn.Begin(context.Background())
 	if err != nil {
 		return err
 	}
+	err = executeMigrator(rendered, tx, config, migName)
+	if err != nil {
+		_ = tx.Rollback(context.Background())
+		return fmt.Errorf("error executing migrator '%s' in transaction: %w", migName, err)
+	}
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction for migrator '%s': %w", migName, err)
+	}
 
 	return nil
 }
 
-func ensureLockTable(conn *pgx.Conn, lockName string) (pgx.Tx, error) {
-	// create the table but drop errors if they occur, as this will result in a race condition over the name
-	// index in the event of a parallel creation.  the rest of the logic below will accomplish the locking
-	// needed to prevent further racing
-	_, _ = conn.Exec(context.Background(), "CREATE TABLE IF NOT EXISTS evo_advisory_locks (name TEXT PRIMARY KEY)")
+// writeCheckpoint appends the name of a committed migrator to the checkpoint file, syncing it to disk so that the
+// record survives an interrupted run
+func writeCheckpoint(file *os.File, migName string) error {
+	_, err := fmt.Fprintf(file, "%s\n", migName)
+	if err != nil {
+		return fmt.Errorf("unable to write checkpoint for migrator '%s': %w", migName, err)
+	}
+
+	return file.Sync()
+}
 
-	_, err := conn.Exec(context.Background(), "INSERT INTO evo_advisory_locks (name) VALUES ($1) ON CONFLICT DO NOTHING", lockName)
+// ensureDatabase creates the target database if it does not exist, the caller must hold the lock for the
+// database so that concurrent runners cannot both attempt the creation
+func ensureDatabase(adminConn *pgx.Conn, config *Config) error {
+	logf("checking if database '%s' exists\n", config.Database)
+	exists, err := databaseExists(adminConn, config.Database)
 	if err != nil {
-		return nil, fmt.Errorf("unable to write advisory lock entry: %w", err)
+		return err
+	}
+	if exists {
+		return nil
 	}
 
-	tx, err := conn.Begin(context.Background())
+	if config.SkipCreateDatabase {
+		if !config.WaitForDatabase {
+			return fmt.Errorf("database '%s' does not exist and EVO_SKIP_CREATE_DATABASE is set, it must be provisioned beforehand, or set EVO_WAIT_FOR_DATABASE=1 to wait for it", config.Database)
+		}
+		return waitForDatabase(adminConn, config)
+	}
+
+	escapedDatabase, err := adminConn.PgConn().EscapeString(config.Database)
+	if err != nil {
+		return err
+	}
+	logf("creating database '%s'\n", config.Database)
+	_, err = adminConn.Exec(context.Background(), fmt.Sprintf("CREATE DATABASE %s WITH OWNER = DEFAULT", escapedDatabase))
+	if err == nil {
+		return nil
+	}
+	if !isCreateDatabaseDisallowed(err) {
+		return fmt.Errorf("unable to create database '%s': %w", config.Database, err)
+	}
+	if !config.WaitForDatabase {
+		return fmt.Errorf("database '%s' does not exist and admin user '%s' is not permitted to create it, it must be provisioned beforehand (set EVO_SKIP_CREATE_DATABASE=1 and EVO_WAIT_FOR_DATABASE=1 to wait for it): %w", config.Database, config.AdminUsername, err)
+	}
+
+	logf("not permitted to create database '%s': %s\n", config.Database, err.Error())
+	return waitForDatabase(adminConn, config)
+}
+
+// selectedMigrator is a pending migrator selected to be applied by a run, along with its source
+type selectedMigrator struct {
+	match  string
+	source string
+}
+
+// selectMigrators selects, in order of application, the migrators among matches which a run is to apply: those
+// not in existingMigrators, nor subsumed by a baseline selected before them, up to the target version and limited
+// to the label and environment of the run
+func selectMigrators(config *Config, matches []string, existingMigrators map[string]struct{}) ([]selectedMigrator, error) {
+	migNames := make([]string, 0, len(matches))
+	for _, match := range matches {
+		migNames = append(migNames, migratorName(config, match))
+	}
+	err := validateVersions(config, migNames)
 	if err != nil {
 		return nil, err
 	}
-	_, err = tx.Exec(context.Background(), "SELECT name FROM evo_advisory_locks WHERE name = $1 FOR UPDATE", lockName)
+
+	var pending []string
+	for _, migName := range migNames {
+		key, err := migratorKey(config, migName)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := existingMigrators[key]; !ok {
+			pending = append(pending, migName)
+		}
+	}
+	err = validateOrdering(pending)
 	if err != nil {
 		return nil, err
 	}
 
-	return tx, nil
+	var selected []selectedMigrator
+	// migrators subsumed by a pending baseline are recorded, rather than executed, when it is applied
+	subsumedBy := map[string]string{}
+	for _, match := range matches {
+		migName := migratorName(config, match)
+		key, err := migratorKey(config, migName)
+		if err != nil {
+			return nil, err
+		}
+		_, ok := existingMigrators[key]
+		if ok {
+			logf("migrator '%s' already applied...\n", migName)
+			continue
+		}
+		if baseline, ok := subsumedBy[key]; ok {
+			logf("migrator '%s' is subsumed by baseline '%s'...\n", migName, baseline)
+			continue
+		}
+		if config.TargetVersion != nil {
+			version, err := parseVersion(migName)
+			if err != nil {
+				return nil, err
+			}
+			if version > *config.TargetVersion {
+				logf("migrator '%s' is beyond target version %d, stopping\n", migName, *config.TargetVersion)
+				break
+			}
+		}
+		source, err := readMigrator(config, match)
+		if err != nil {
+			return nil, err
+		}
+		if len(config.Label) > 0 && !slices.Contains(parseLabels(source), config.Label) {
+			logf("migrator '%s' is not labelled '%s', skipping\n", migName, config.Label)
+			continue
+		}
+		// left unrecorded, so that it still applies should it later run in an environment it is meant for
+		if !allowedInEnvironment(source, config.Env) {
+			logf("migrator '%s' does not apply to environment '%s', skipping\n", migName, config.Env)
+			continue
+		}
+		for _, subsumedName := range parseSubsumes(source) {
+			subsumedKey, err := migratorKey(config, subsumedName)
+			if err != nil {
+				return nil, err
+			}
+			subsumedBy[subsumedKey] = migName
+		}
+		selected = append(selected, selectedMigrator{match: match, source: source})
+	}
+
+	return selected, nil
 }
 
-func doMigration(config *Config, preValidationHook func(config *Config)) error {
-	fmt.Printf("initiating concurrency mitigation\n")
-	concurrencyConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+// doMigration provisions and migrates the configured database.  the lock, keyed on the database name and held on
+// a connection to the postgres maintenance database, is taken before anything else so that it covers the whole
+// critical section: creating the database, creating the user and granting its privileges, syncing its password
+// and applying migrators.  any number of runners starting against a cold cluster therefore serialize, and only
+// the first to obtain the lock performs the creation.  the user is a cluster wide role which runners for other
+// databases may create concurrently, ensureUser tolerates losing that race.
+func doMigration(config *Config, preValidationHook func(config *Config)) (err error) {
+	start := time.Now()
+	applied := 0
+	passwordReset := false
+	var manifest []ManifestEntry
+	defer func() {
+		err = recordRunMetrics(config, start, applied, err)
+		err = recordManifest(config, manifest, err)
+		err = notifyCompletion(config, manifest, err)
+		reportProgress(config, ProgressEvent{Stage: ProgressRunComplete, Duration: time.Since(start), Applied: applied, PasswordReset: passwordReset, Err: err})
+	}()
+
+	err = guardMaintenanceDatabase(config)
+	if err != nil {
+		return err
+	}
+
+	// the migrators are found ahead of any connection, so that a wrong directory is reported without side effects
+	var matches []string
+	if !config.Bootstrap {
+		matches, err = findMigrators(config)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			if config.FailOnEmpty {
+				return fmt.Errorf("no migrators found in '%s', check the directory given to evo", config.Directory)
+			}
+			logf("no migrators found in '%s'\n", config.Directory)
+		}
+	}
+
+	logf("initiating concurrency mitigation\n")
+	concurrencyConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl(maintenanceDatabase))
 	if err != nil {
-		return fmt.Errorf("unable to connect to database: %w", err)
+		return connectError(fmt.Errorf("unable to connect to database: %w", err))
 	}
 	defer func() {
 		_ = concurrencyConn.Close(context.Background())
 	}()
 
-	// ensures the locking schema exists and takes out a simulated advisory lock
-	tx, err := ensureLockTable(concurrencyConn, config.Database)
+	err = checkReady(concurrencyConn, config)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = tx.Rollback(context.Background())
-	}()
 
-	fmt.Printf("connecting to postgres database\n")
-	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	release, err := acquireLock(concurrencyConn, config)
+	if err != nil {
+		return err
+	}
+	defer release()
+	reportProgress(config, ProgressEvent{Stage: ProgressLockAcquired})
+
+	logf("connecting to maintenance database '%s'\n", maintenanceDatabase)
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl(maintenanceDatabase))
 	if err != nil {
-		return fmt.Errorf("unable to connect to database: %w", err)
+		return connectError(fmt.Errorf("unable to connect to database: %w", err))
 	}
 	defer func() {
 		_ = adminConn.Close(context.Background())
 	}()
 
-	var exists bool
+	err = preflight(adminConn, config)
+	if err != nil {
+		return err
+	}
 
-	fmt.Printf("checking if database '%s' exists\n", config.Database)
-	row := adminConn.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1)", config.Database)
-	err = row.Scan(&exists)
+	err = ensureDatabase(adminConn, config)
 	if err != nil {
-		return fmt.Errorf("unable to query database for existing database by name: %w", err)
+		return err
 	}
 
-	if !exists {
-		escapedDatabase, err := adminConn.PgConn().EscapeString(config.Database)
-		if err != nil {
+	if config.ExternalUser {
+		logf("user '%s' is managed externally, leaving it untouched\n", config.Username)
+	} else {
+		err = withRoleRetries(func() error {
+			reset, err := ensureUser(config)
+			passwordReset = passwordReset || reset
 			return err
-		}
-		fmt.Printf("creating database '%s'\n", config.Database)
-		_, err = adminConn.Exec(context.Background(), fmt.Sprintf("CREATE DATABASE %s WITH OWNER = DEFAULT", escapedDatabase))
+		})
 		if err != nil {
-			return fmt.Errorf("unable to create database '%s': %w", config.Database, err)
+			return err
 		}
 	}
 
-	err = ensureUser(config)
-	if err != nil {
-		return err
-	}
-
-	fmt.Printf("obtaining user database connection\n")
-	userConn, err := verifyUserPassword(config)
+	logf("obtaining user database connection\n")
+	notices := &noticeLogger{}
+	userConn, err := verifyUserPassword(config, notices.handler(config))
 	if err != nil {
-		return fmt.Errorf("problem with user login: %w", err)
+		return connectError(fmt.Errorf("problem with user login: %w", err))
 	}
 
-	if userConn == nil && config.AutoUpdatePassword {
+	if userConn == nil && config.AutoUpdatePassword && !config.ExternalUser {
 		if preValidationHook != nil {
 			preValidationHook(config)
 		}
 
 		// password is bad, reset it
-		escapedPassword, err := adminConn.PgConn().EscapeString(config.Password)
-		if err != nil {
-			return err
-		}
-		escapedUsername, err := adminConn.PgConn().EscapeString(config.Username)
+		err = resetUserPassword(adminConn, config)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("updating password for user '%s'\n", config.Username)
-		_, err = adminConn.Exec(context.Background(), fmt.Sprintf("ALTER USER %s WITH PASSWORD '%s'", escapedUsername, escapedPassword))
-		if err != nil {
-			return fmt.Errorf("unable update password for user '%s': %w", config.Username, err)
-		}
+		passwordReset = true
 
-		userConn, err = verifyUserPassword(config)
+		userConn, err = verifyUserPassword(config, notices.handler(config))
 		if err != nil {
-			return fmt.Errorf("problem with user login: %w", err)
+			return connectError(fmt.Errorf("problem with user login: %w", err))
 		}
 	}
 
 	if userConn == nil {
-		return fmt.Errorf("unable to login as user '%s'", config.Username)
+		return withKind(ErrAuthFailed, fmt.Errorf("unable to login as user '%s'", config.Username))
 	}
 	defer func() {
 		_ = userConn.Close(context.Background())
 	}()
+	reportProgress(config, ProgressEvent{Stage: ProgressConnected})
+
+	if config.Bootstrap {
+		logf("database '%s' and user '%s' are provisioned, no migrators applied\n", config.Database, config.Username)
+		return nil
+	}
 
-	existingMigrators, err := ensureMigratorTable(userConn)
+	if config.ForbidSuperuser {
+		err = forbidSuperuser(userConn, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	existingMigrators, err := ensureMigratorTable(userConn, config)
 	if err != nil {
-		return err
+		return schemaPermissionHint(config, err)
+	}
+	if config.RecordObjects {
+		err = ensureObjectsTable(userConn, config)
+		if err != nil {
+			return err
+		}
+	}
+	// the migrator being applied, whose failure is audited however the run ends
+	auditing := ""
+	if config.Audit {
+		err = ensureAuditTable(userConn, config)
+		if err != nil {
+			return err
+		}
+		// the password was reset before the database could be reached, so it is audited once it can be
+		if passwordReset {
+			writeAudit(userConn, config, AuditPasswordReset, "", config.AdminUsername, nil)
+		}
+		defer func() {
+			if err != nil && len(auditing) > 0 {
+				writeAudit(userConn, config, AuditFailure, auditing, config.Username, err)
+			}
+		}()
 	}
+	// templates see the state before this run, however far it gets
+	config.AppliedBefore = maps.Clone(existingMigrators)
 
-	globPattern := filepath.Join(config.Directory, "*.sql")
-	fmt.Printf("globbing %s for migrators\n", globPattern)
-	matches, err := filepath.Glob(globPattern)
+	if config.FailOnMissingApplied {
+		missing, err := missingApplied(config, existingMigrators, matches)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("%d applied migrators no longer have a file in the directory: %s", len(missing), strings.Join(missing, ", "))
+		}
+	}
+
+	data, err := getTemplateData(config)
 	if err != nil {
 		return err
 	}
-	sort.Slice(matches, func(i, j int) bool {
-		return i < j
-	})
 
-	env := map[string]string{}
-	for _, envStr := range os.Environ() {
-		strParts := strings.SplitN(envStr, "=", 2)
-		env[strParts[0]] = strParts[1]
-	}
-	for _, match := range matches {
-		_, migName := filepath.Split(match)
-		_, ok := existingMigrators[migName]
-		if ok {
-			fmt.Printf("migrator '%s' already applied...\n", migName)
-			continue
+	var checkpointFile *os.File
+	if len(config.CheckpointFile) > 0 {
+		checkpointFile, err = os.OpenFile(config.CheckpointFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return fmt.Errorf("unable to open checkpoint file '%s': %w", config.CheckpointFile, err)
 		}
-		fmt.Printf("executing migrator '%s'...\n", migName)
-		doTransact := true
-		if strings.HasSuffix(match, "_notrans.sql") {
-			doTransact = false
+		defer func() {
+			_ = checkpointFile.Close()
+		}()
+	}
+
+	// the migrators to be applied are selected up front, so that progress can be reported against their total
+	selected, err := selectMigrators(config, matches, existingMigrators)
+	if err != nil {
+		return err
+	}
+
+	for i, migrator := range selected {
+		match, source := migrator.match, migrator.source
+		migName := migratorName(config, match)
+		reportProgress(config, ProgressEvent{Stage: ProgressMigratorStarted, Migrator: migName, Index: i + 1, Total: len(selected)})
+		logf("executing migrator '%s'...\n", migName)
+		auditing = migName
+		writeAudit(userConn, config, AuditStart, migName, config.Username, nil)
+		migratorStart := time.Now()
+		notices.migName = migName
+		doTransact, err := isTransactional(config, match, source)
+		if err != nil {
+			return &ErrMigratorFailed{Name: migName, Err: err}
 		}
 
-		t, err := template.ParseFiles(match)
+		rendered, err := renderMigrator(config, match, migName, data)
+		if err != nil {
+			return &ErrMigratorFailed{Name: migName, Err: err}
+		}
+		err = preprocess(config, rendered, migName)
 		if err != nil {
-			return fmt.Errorf("unable to parse migrator as template '%s': %w", match, err)
+			return &ErrMigratorFailed{Name: migName, Err: err}
+		}
+		if rendered.Savepoints && !doTransact {
+			return &ErrMigratorFailed{Name: migName, Err: fmt.Errorf("migrator '%s' uses savepoints, which require a transaction", migName)}
+		}
+
+		if config.RequireConfirm {
+			destructive := destructiveStatements(rendered.SQL)
+			if len(destructive) > 0 {
+				err = confirm(config, fmt.Sprintf("apply migrator '%s', which contains %s", migName, strings.Join(destructive, ", ")))
+				if err != nil {
+					return err
+				}
+			}
 		}
 
-		var buf bytes.Buffer
-		err = t.Execute(&buf, env)
+		// the previous migrators are committed and recorded, so a connection dropped since can simply be replaced
+		conn, err := reconnectIfBroken(userConn, config, notices.handler(config))
 		if err != nil {
-			return fmt.Errorf("error executing template '%s': %w", match, err)
+			return err
 		}
+		userConn = conn
 
-		sql := buf.String()
+		subsumed := parseSubsumes(source)
+		if rendered.Backfill != nil {
+			done, err := runBackfill(userConn, config, rendered, migName)
+			if err != nil {
+				return &ErrMigratorFailed{Name: migName, Err: err}
+			}
+			// the migrators which follow may rely on the backfill, so they wait for it to finish
+			if !done {
+				writeAudit(userConn, config, AuditPaused, migName, config.Username, nil)
+				auditing = ""
+				reportProgress(config, ProgressEvent{Stage: ProgressMigratorPaused, Migrator: migName, Index: i + 1, Total: len(selected), Duration: time.Since(migratorStart)})
+				logf("leaving the remaining migrators pending until backfill '%s' finishes\n", migName)
+				return nil
+			}
+		} else if len(subsumed) > 0 {
+			err = validateTransactionalSQL(migName, rendered.SQL)
+			if err != nil {
+				return &ErrMigratorFailed{Name: migName, Err: err}
+			}
 
-		if doTransact {
-			tx, err := userConn.Begin(context.Background())
+			err = withHeartbeat(config, userConn, migName, func() error {
+				return applyBaseline(rendered, userConn, config, migName, subsumed, existingMigrators)
+			})
 			if err != nil {
-				return err
+				return &ErrMigratorFailed{Name: migName, Err: err}
 			}
-			err = executeMigrator(sql, tx, migName)
+		} else if doTransact {
+			err = validateTransactionalSQL(migName, rendered.SQL)
 			if err != nil {
-				_ = tx.Rollback(context.Background())
-				return fmt.Errorf("error executing migrator '%s' in transaction: %w", migName, err)
+				return &ErrMigratorFailed{Name: migName, Err: err}
 			}
-			err = tx.Commit(context.Background())
+
+			err = withHeartbeat(config, userConn, migName, func() error {
+				return withRetries(config, migName, func() error {
+					return executeTransactionalMigrator(rendered, userConn, config, migName)
+				})
+			})
 			if err != nil {
-				return fmt.Errorf("unable to commit transaction for migrator '%s': %w", migName, err)
+				return &ErrMigratorFailed{Name: migName, Err: err}
 			}
 		} else {
-			err = executeMigrator(sql, userConn, migName)
+			err = withHeartbeat(config, userConn, migName, func() error {
+				return executeMigrator(rendered, userConn, config, migName)
+			})
 			if err != nil {
-				return fmt.Errorf("error executing migrator '%s': %w", migName, err)
+				runCleanup(userConn, config, match, migName, data)
+				return &ErrMigratorFailed{Name: migName, Err: fmt.Errorf("error executing migrator '%s': %w", migName, err)}
 			}
 		}
 
+		writeAudit(userConn, config, AuditSuccess, migName, config.Username, nil)
+		auditing = ""
+		applied++
+		manifest = append(manifest, ManifestEntry{
+			Database:        config.Database,
+			Migrator:        migName,
+			Checksum:        rendered.Checksum,
+			AppliedAt:       time.Now().UTC(),
+			DurationSeconds: time.Since(migratorStart).Seconds(),
+		})
+		reportProgress(config, ProgressEvent{Stage: ProgressMigratorFinished, Migrator: migName, Index: i + 1, Total: len(selected), Duration: time.Since(migratorStart)})
+
+		if checkpointFile != nil {
+			err = writeCheckpoint(checkpointFile, migName)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	return nil
+	// verifications assume every migrator is applied, which a filtered run does not do
+	if config.TargetVersion != nil || len(config.Label) > 0 {
+		logf("skipping verifications, the run was limited to a subset of the migrators\n")
+		return nil
+	}
+	return verify(userConn, config, data)
+}
+
+func runUp(config *Config, args []string) error {
+	flags := flag.NewFlagSet("up", flag.ContinueOnError)
+	targetVersion := flags.Int64("target-version", -1, "apply migrators up to and including this version (requires EVO_TRACK_BY=version)")
+	label := flags.String("label", "", "apply only the pending migrators carrying this label")
+	yes := flags.Bool("yes", false, "confirm destructive operations when EVO_REQUIRE_CONFIRM is set")
+	continueOnStatementError := flags.Bool("continue-on-statement-error", false, "skip failing statements of migrators using savepoints, for development")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	config.Label = *label
+	config.Confirmed = *yes
+	config.ContinueOnStatementError = *continueOnStatementError
+
+	if *targetVersion >= 0 {
+		if config.TrackBy != TrackByVersion {
+			return fmt.Errorf("--target-version requires EVO_TRACK_BY=%s", TrackByVersion)
+		}
+		config.TargetVersion = targetVersion
+	}
+
+	if len(config.Databases) > 1 {
+		return migrateDatabases(config)
+	}
+	return migrate(config)
+}
+
+// runBootstrap performs the privileged part of a run alone: creating the database and the user, granting its
+// privileges and syncing its password.  migrators are applied later by an unprivileged run, with
+// EVO_SKIP_CREATE_DATABASE and EVO_MANAGE_USER=false.
+func runBootstrap(config *Config, args []string) error {
+	flags := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "confirm password resets when EVO_REQUIRE_CONFIRM is set")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	config.Confirmed = *yes
+	config.Bootstrap = true
+
+	if len(config.Databases) > 1 {
+		return migrateDatabases(config)
+	}
+	return doMigration(config, nil)
+}
+
+type command struct {
+	description string
+	connections Connections
+	// multiDatabase commands accept several databases in EVO_DB_DATABASE
+	multiDatabase bool
+	run           func(config *Config, args []string) error
+}
+
+var commands = map[string]*command{
+	"up": {
+		description:   "apply all pending migrators, the default when no command is given (--target-version, --label, --yes, --continue-on-statement-error)",
+		connections:   ConnectAdmin,
+		multiDatabase: true,
+		run:           runUp,
+	},
+	"bootstrap": {
+		description:   "create the database and user, grant privileges and sync the password, without applying migrators (--yes)",
+		connections:   ConnectAdmin,
+		multiDatabase: true,
+		run:           runBootstrap,
+	},
+	"diff": {
+		description: "apply the pending migrators to a temporary copy of the database and report the schema changes they make",
+		connections: ConnectAdmin,
+		run:         runDiff,
+	},
+	"down": {
+		description: "roll back the most recently applied migrators using their down files (--steps, --to, --force-irreversible, --set)",
+		connections: ConnectAdmin,
+		run:         runDown,
+	},
+	"status": {
+		description: "report applied, pending and missing migrators over a read-only connection (--since)",
+		connections: ConnectUser,
+		run:         runStatus,
+	},
+	"version-state": {
+		description: "print a one line fingerprint of the applied migrators, to compare databases, over a read-only connection",
+		connections: ConnectUser,
+		run:         runVersionState,
+	},
+	"assert-applied": {
+		description: "fail, listing the pending migrators, unless every migrator has been applied (read only)",
+		connections: ConnectUser,
+		run:         runAssertApplied,
+	},
+	"unlock": {
+		description: "report the holder of the lock, clearing it when orphaned but never when held by a live session (--stale-after)",
+		connections: ConnectAdmin,
+		run:         runUnlock,
+	},
+	"wait": {
+		description: "wait until another runner has applied a migrator (--for, --timeout), applying nothing (read only)",
+		connections: ConnectUser,
+		run:         runWait,
+	},
+	"blame": {
+		description: "report the migrator which created an object or column, recorded while EVO_RECORD_OBJECTS is set",
+		connections: ConnectUser,
+		run:         runBlame,
+	},
+	"checksum-backfill": {
+		description: "store checksums, computed from the current files, for applied migrators recorded without one (--yes)",
+		connections: ConnectUser,
+		run:         runChecksumBackfill,
+	},
+	"check": {
+		description: "parse and render every migrator without connecting to a database",
+		connections: ConnectNone,
+		run:         runCheck,
+	},
+	"doctor": {
+		description: "check the migrators, the connections and the privileges of the users without migrating",
+		connections: ConnectAdmin,
+		run:         runDoctor,
+	},
+	"export": {
+		description: "write the applied migration history to stdout (--format csv|sql, --since)",
+		connections: ConnectUser,
+		run:         runExport,
+	},
+	"import": {
+		description: "load migration history produced by export from stdin into an empty evo_mg (--format csv|sql)",
+		connections: ConnectUser,
+		run:         runImport,
+	},
+	"squash": {
+		description: "write the schema of a reference database as a baseline migrator subsuming its applied migrators (--up-to, --output)",
+		connections: ConnectUser,
+		run:         runSquash,
+	},
+	"plan": {
+		description: "list the pending migrators which would be applied, without applying them (--json, --include-sql)",
+		connections: ConnectUser,
+		run:         runPlan,
+	},
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func main() {
@@ -452,14 +1899,33 @@ func main() {
 		os.Exit(1)
 	}
 
-	config, err := getConfig(os.Args[1])
+	// the command may be omitted, in which case migrations are applied
+	name := "up"
+	args := os.Args[1:]
+	if _, ok := commands[args[0]]; ok {
+		name = args[0]
+		args = args[1:]
+	}
+	cmd := commands[name]
+
+	if len(args) < 1 {
+		printHelp()
+		os.Exit(1)
+	}
+
+	config, err := getConfig(args[0], cmd.connections)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		printHelp()
 		os.Exit(1)
 	}
 
-	err = doMigration(config, nil)
+	if len(config.Databases) > 1 && !cmd.multiDatabase {
+		fmt.Fprintf(os.Stderr, "command '%s' accepts a single database in EVO_DB_DATABASE\n", name)
+		os.Exit(1)
+	}
+
+	err = cmd.run(config, args[1:])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(1)
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Version is the evo release, set at build time by the makefile
+var Version = "dev"
+
+// ManifestEntry is a migrator applied by this invocation
+type ManifestEntry struct {
+	Database        string    `json:"database"`
+	Migrator        string    `json:"migrator"`
+	Checksum        string    `json:"checksum"`
+	AppliedAt       time.Time `json:"applied_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+// Manifest lists the migrators applied by an invocation, written to EVO_MANIFEST_OUT
+type Manifest struct {
+	EvoVersion string          `json:"evo_version"`
+	RunID      string          `json:"run_id"`
+	Migrators  []ManifestEntry `json:"migrators"`
+}
+
+var (
+	// manifestMutex guards manifestEntries, databases may be migrated concurrently
+	manifestMutex sync.Mutex
+	// manifestEntries holds the migrators applied to every database migrated by this invocation, all of which are
+	// written to the manifest
+	manifestEntries = []ManifestEntry{}
+)
+
+// formatManifest renders the manifest of the given entries
+func formatManifest(config *Config, entries []ManifestEntry) ([]byte, error) {
+	content, err := json.MarshalIndent(Manifest{
+		EvoVersion: Version,
+		RunID:      getRunID(config),
+		Migrators:  entries,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(content, '\n'), nil
+}
+
+// recordManifest adds the migrators applied to the configured database to the manifest and rewrites it, when one
+// is configured.  migrators applied before a failure are committed, so the manifest is written whether or not the
+// run succeeded.  the run's own error is returned, or the error writing the manifest when the run succeeded.
+func recordManifest(config *Config, entries []ManifestEntry, runErr error) error {
+	if len(config.ManifestOut) == 0 {
+		return runErr
+	}
+
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+
+	manifestEntries = append(manifestEntries, entries...)
+
+	content, err := formatManifest(config, manifestEntries)
+	if err == nil {
+		err = writeFileAtomic(config.ManifestOut, content)
+	}
+	if err != nil {
+		err = fmt.Errorf("unable to write manifest '%s': %w", config.ManifestOut, err)
+		if runErr != nil {
+			logf("%s\n", err.Error())
+			return runErr
+		}
+		return err
+	}
+
+	return runErr
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func readManifest(t *testing.T, path string) Manifest {
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var manifest Manifest
+	assert.NoError(t, json.Unmarshal(content, &manifest))
+	return manifest
+}
+
+func TestManifest(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	config.RunID = "job-1"
+	config.ManifestOut = filepath.Join(t.TempDir(), "manifest.json")
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql":  "CREATE TABLE first (id INT);",
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+	manifestEntries = []ManifestEntry{}
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	records, err := getMigrationRecords(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	manifest := readManifest(t, config.ManifestOut)
+	assert.Equal(t, Version, manifest.EvoVersion)
+	assert.Equal(t, "job-1", manifest.RunID)
+	assert.Len(t, manifest.Migrators, len(records))
+	for i, record := range records {
+		assert.Equal(t, "testdb", manifest.Migrators[i].Database)
+		assert.Equal(t, record.Migrator, manifest.Migrators[i].Migrator)
+		assert.Equal(t, *record.Checksum, manifest.Migrators[i].Checksum)
+		assert.False(t, manifest.Migrators[i].AppliedAt.IsZero())
+	}
+
+	// a later run lists only the migrators it applied itself
+	writeMigrators(t, config.Directory, map[string]string{
+		"0003_third.sql": "CREATE TABLE third (id INT);",
+	})
+	manifestEntries = []ManifestEntry{}
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	manifest = readManifest(t, config.ManifestOut)
+	assert.Len(t, manifest.Migrators, 1)
+	assert.Equal(t, "0003_third.sql", manifest.Migrators[0].Migrator)
+
+	// nothing to apply produces an empty manifest
+	manifestEntries = []ManifestEntry{}
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	manifest = readManifest(t, config.ManifestOut)
+	assert.Empty(t, manifest.Migrators)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runMetrics is the outcome of migrating a database
+type runMetrics struct {
+	finished time.Time
+	duration time.Duration
+	applied  int
+	success  bool
+}
+
+var (
+	// metricsMutex guards metricsByDatabase, databases may be migrated concurrently
+	metricsMutex sync.Mutex
+	// metricsByDatabase holds the outcome of each database migrated by this invocation, all of which are written
+	// to the textfile
+	metricsByDatabase = map[string]runMetrics{}
+)
+
+// escapeLabelValue escapes a prometheus label value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatMetrics renders metrics in the prometheus text exposition format
+func formatMetrics(metrics map[string]runMetrics) string {
+	databases := make([]string, 0, len(metrics))
+	for database := range metrics {
+		databases = append(databases, database)
+	}
+	sort.Strings(databases)
+
+	gauges := []struct {
+		name  string
+		help  string
+		value func(m runMetrics) string
+	}{
+		{"evo_last_run_timestamp_seconds", "Unix time at which the last run finished.", func(m runMetrics) string {
+			return fmt.Sprintf("%d", m.finished.Unix())
+		}},
+		{"evo_last_run_success", "Whether the last run succeeded (1) or failed (0).", func(m runMetrics) string {
+			if m.success {
+				return "1"
+			}
+			return "0"
+		}},
+		{"evo_last_run_duration_seconds", "Duration of the last run.", func(m runMetrics) string {
+			return fmt.Sprintf("%g", m.duration.Seconds())
+		}},
+		{"evo_last_run_applied_migrators", "Number of migrators applied by the last run.", func(m runMetrics) string {
+			return fmt.Sprintf("%d", m.applied)
+		}},
+	}
+
+	var b strings.Builder
+	for _, gauge := range gauges {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+		for _, database := range databases {
+			fmt.Fprintf(&b, "%s{database=\"%s\"} %s\n", gauge.name, escapeLabelValue(database), gauge.value(metrics[database]))
+		}
+	}
+
+	return b.String()
+}
+
+// writeFileAtomic writes content to a temporary file alongside path and renames it into place, so that a reader
+// such as the node_exporter textfile collector never sees a partial file
+func writeFileAtomic(path string, content []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+
+	_, err = file.Write(content)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	err = file.Chmod(0o644)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// recordRunMetrics records the outcome of migrating the configured database and rewrites the metrics textfile,
+// when one is configured.  the run's own error is returned, or the error writing the textfile when the run
+// succeeded.
+func recordRunMetrics(config *Config, start time.Time, applied int, runErr error) error {
+	if len(config.MetricsTextfile) == 0 {
+		return runErr
+	}
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	finished := time.Now()
+	metricsByDatabase[config.Database] = runMetrics{
+		finished: finished,
+		duration: finished.Sub(start),
+		applied:  applied,
+		success:  runErr == nil,
+	}
+
+	err := writeFileAtomic(config.MetricsTextfile, []byte(formatMetrics(metricsByDatabase)))
+	if err != nil {
+		err = fmt.Errorf("unable to write metrics textfile '%s': %w", config.MetricsTextfile, err)
+		if runErr != nil {
+			logf("%s\n", err.Error())
+			return runErr
+		}
+		return err
+	}
+
+	return runErr
+}
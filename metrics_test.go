@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestFormatMetrics(t *testing.T) {
+	finished := time.Unix(1700000000, 0)
+	metrics := formatMetrics(map[string]runMetrics{
+		"b":     {finished: finished, duration: 1500 * time.Millisecond, applied: 2, success: true},
+		`a"\db`: {finished: finished, duration: time.Second, applied: 0, success: false},
+	})
+
+	assert.Equal(t, `# HELP evo_last_run_timestamp_seconds Unix time at which the last run finished.
+# TYPE evo_last_run_timestamp_seconds gauge
+evo_last_run_timestamp_seconds{database="a\"\\db"} 1700000000
+evo_last_run_timestamp_seconds{database="b"} 1700000000
+# HELP evo_last_run_success Whether the last run succeeded (1) or failed (0).
+# TYPE evo_last_run_success gauge
+evo_last_run_success{database="a\"\\db"} 0
+evo_last_run_success{database="b"} 1
+# HELP evo_last_run_duration_seconds Duration of the last run.
+# TYPE evo_last_run_duration_seconds gauge
+evo_last_run_duration_seconds{database="a\"\\db"} 1
+evo_last_run_duration_seconds{database="b"} 1.5
+# HELP evo_last_run_applied_migrators Number of migrators applied by the last run.
+# TYPE evo_last_run_applied_migrators gauge
+evo_last_run_applied_migrators{database="a\"\\db"} 0
+evo_last_run_applied_migrators{database="b"} 2
+`, metrics)
+}
+
+func TestMetricsTextfile(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	metricsDirectory := t.TempDir()
+	config.MetricsTextfile = filepath.Join(metricsDirectory, "evo.prom")
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(config.MetricsTextfile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "evo_last_run_success{database=\"testdb\"} 1\n")
+	assert.Contains(t, string(content), "evo_last_run_applied_migrators{database=\"testdb\"} 5\n")
+	assert.Regexp(t, regexp.MustCompile(`(?m)^evo_last_run_timestamp_seconds\{database="testdb"\} \d+$`), string(content))
+	assert.Regexp(t, regexp.MustCompile(`(?m)^evo_last_run_duration_seconds\{database="testdb"\} [0-9.e+-]+$`), string(content))
+
+	// a failed run is reported as such
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_broken.sql": "CREATE TABLE broken (",
+	})
+	err = doMigration(config, nil)
+	assert.Error(t, err)
+
+	content, err = os.ReadFile(config.MetricsTextfile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "evo_last_run_success{database=\"testdb\"} 0\n")
+	assert.Contains(t, string(content), "evo_last_run_applied_migrators{database=\"testdb\"} 0\n")
+
+	// only the textfile remains, the temporary files it was written through are gone
+	entries, err := os.ReadDir(metricsDirectory)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
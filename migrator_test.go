@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 
@@ -78,7 +80,7 @@ func TestCreateDatabase(t *testing.T) {
 		_ = standardConn.Close(context.Background())
 	}()
 
-	pastMigrations, err := getPastMigrations(standardConn)
+	pastMigrations, err := getPastMigrations(standardConn, config)
 	assert.NoError(t, err)
 
 	assert.Contains(t, pastMigrations, "0001_make_table.sql")
@@ -91,6 +93,41 @@ func TestCreateDatabase(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestBootstrap(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Bootstrap = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// the database and user exist, but neither evo_mg nor any migrator's objects do
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	exists, err := migratorTableExists(conn, config)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	var tables int
+	err = conn.QueryRow(context.Background(), "SELECT count(*) FROM pg_tables WHERE schemaname = 'public'").Scan(&tables)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, tables)
+
+	// the unprivileged run applies the migrators
+	config.Bootstrap = false
+	config.SkipCreateDatabase = true
+	config.ExternalUser = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Contains(t, pastMigrations, "0001_make_table.sql")
+}
+
 func TestMutlipleConcurrent(t *testing.T) {
 	pgContainer, config, err := setupDb()
 	assert.NoError(t, err)
@@ -107,3 +144,357 @@ func TestMutlipleConcurrent(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestConcurrentColdStart(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	// neither the database nor the user exist yet, every runner races to create them
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runnerConfig := *config
+			err := doMigration(&runnerConfig, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+
+	var databases int
+	err = adminConn.QueryRow(context.Background(), "SELECT COUNT(*) FROM pg_catalog.pg_database WHERE datname = $1", config.Database).Scan(&databases)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, databases)
+
+	var roles int
+	err = adminConn.QueryRow(context.Background(), "SELECT COUNT(*) FROM pg_roles WHERE rolname = $1", config.Username).Scan(&roles)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, roles)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 5)
+}
+
+func TestPrivilegesNotRegranted(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+
+	granted, err := hasUserPrivileges(adminConn, config)
+	assert.NoError(t, err)
+	assert.True(t, granted)
+
+	// privileges are already in place, so a second pass must not re-issue the grants
+	regranted, err := ensureUserPrivileges(adminConn, config, config.Username)
+	assert.NoError(t, err)
+	assert.False(t, regranted)
+
+	config.RegrantAlways = true
+	regranted, err = ensureUserPrivileges(adminConn, config, config.Username)
+	assert.NoError(t, err)
+	assert.True(t, regranted)
+}
+
+func TestCheckpointFile(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.CheckpointFile = filepath.Join(t.TempDir(), "checkpoint")
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(config.CheckpointFile)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"0001_make_table.sql",
+		"0002_drop_and_make.sql",
+		"0003_make_dtype.sql",
+		"0004_edit_type_notrans.sql",
+		"0005_add_index.sql",
+	}, "\n")+"\n", string(content))
+
+	// a fresh run truncates the checkpoint file, nothing further is applied
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	content, err = os.ReadFile(config.CheckpointFile)
+	assert.NoError(t, err)
+	assert.Empty(t, content)
+}
+
+// writeMigrators writes each named migrator into dir, creating dir as needed
+func writeMigrators(t *testing.T, dir string, migrators map[string]string) {
+	t.Helper()
+	err := os.MkdirAll(dir, 0o755)
+	assert.NoError(t, err)
+	for name, content := range migrators {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		assert.NoError(t, err)
+	}
+}
+
+func TestTransactionalMigratorWithCommit(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_explicit_commit.sql": "CREATE TABLE committed (id INT);\nCOMMIT;\n",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "0001_explicit_commit.sql")
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, pastMigrations)
+}
+
+func TestIsTransactional(t *testing.T) {
+	for _, transactOptIn := range []bool{false, true} {
+		config := &Config{TransactOptIn: transactOptIn}
+
+		transactional, err := isTransactional(config, "0001_default.sql", "SELECT 1;")
+		assert.NoError(t, err)
+		assert.Equal(t, !transactOptIn, transactional)
+
+		transactional, err = isTransactional(config, "0002_index_notrans.sql", "SELECT 1;")
+		assert.NoError(t, err)
+		assert.False(t, transactional)
+
+		transactional, err = isTransactional(config, "0003_backfill_trans.sql", "SELECT 1;")
+		assert.NoError(t, err)
+		assert.True(t, transactional)
+
+		transactional, err = isTransactional(config, "0004_backfill.sql", "-- evo: transaction\nSELECT 1;")
+		assert.NoError(t, err)
+		assert.True(t, transactional)
+
+		_, err = isTransactional(config, "0005_confused_notrans.sql", "-- evo: transaction\nSELECT 1;")
+		assert.ErrorContains(t, err, "both opts in to and out of a transaction")
+	}
+}
+
+func TestTransactOptIn(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.TransactOptIn = true
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql":   "CREATE TABLE things (id INT);",
+		"0002_add_index.sql":    "CREATE INDEX CONCURRENTLY ix_things ON things (id);",
+		"0003_insert_trans.sql": "INSERT INTO things VALUES (1);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// an opted in migrator is rejected for managing its own transaction, as under the usual default
+	writeMigrators(t, config.Directory, map[string]string{
+		"0004_explicit_commit.sql": "-- evo: transaction\nCREATE TABLE committed (id INT);\nCOMMIT;\n",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "transaction control statement")
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 3)
+}
+
+func TestEnvironmentMigrators(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	config.Env = "staging"
+	config.CheckpointFile = filepath.Join(t.TempDir(), "checkpoint")
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_common.sql": "CREATE TABLE common (id INT);",
+		"0003_common.sql": "ALTER TABLE common ADD COLUMN name TEXT;",
+	})
+	writeMigrators(t, filepath.Join(config.Directory, "staging"), map[string]string{
+		"0002_seed.sql": "INSERT INTO common (id, name) VALUES (1, 'staging');",
+	})
+	writeMigrators(t, filepath.Join(config.Directory, "prod"), map[string]string{
+		"0002_seed.sql": "INSERT INTO common (id, name) VALUES (1, 'prod');",
+	})
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// common migrators come first, even when an environment specific migrator sorts between them
+	content, err := os.ReadFile(config.CheckpointFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "0001_common.sql\n0003_common.sql\nstaging/0002_seed.sql\n", string(content))
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	var name string
+	err = standardConn.QueryRow(context.Background(), "SELECT name FROM common WHERE id = 1").Scan(&name)
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", name)
+}
+
+func TestConnUrlParams(t *testing.T) {
+	params, err := url.ParseQuery("target_session_attrs=read-write&options=-c%20statement_timeout%3D0&sslnegotiation=direct")
+	assert.NoError(t, err)
+
+	config := &Config{
+		Hostname:      "db.example.com:5432",
+		Database:      Database,
+		AdminUsername: AdminUsername,
+		AdminPassword: "p@ss word",
+		Username:      Username,
+		Password:      Password,
+		Params:        params,
+	}
+
+	for _, connUrl := range []string{config.GetAdminConnUrl(), config.GetUserConnUrl("postgres")} {
+		parsed, err := url.Parse(connUrl)
+		assert.NoError(t, err)
+		assert.Equal(t, "read-write", parsed.Query().Get("target_session_attrs"))
+		assert.Equal(t, "-c statement_timeout=0", parsed.Query().Get("options"))
+		assert.Equal(t, "direct", parsed.Query().Get("sslnegotiation"))
+	}
+
+	connConfig, err := pgx.ParseConfig(config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	assert.Equal(t, "p@ss word", connConfig.Password)
+	assert.Equal(t, "-c statement_timeout=0", connConfig.RuntimeParams["options"])
+}
+
+func TestReservedParams(t *testing.T) {
+	t.Setenv("EVO_DB_PARAMS", "target_session_attrs=read-write")
+	config, err := getConfig(t.TempDir(), ConnectNone)
+	assert.NoError(t, err)
+	assert.Equal(t, "read-write", config.Params.Get("target_session_attrs"))
+
+	// parameters which would connect to another database or as another role are rejected
+	for _, key := range reservedParams {
+		t.Setenv("EVO_DB_PARAMS", "sslmode=require&"+key+"=other")
+		_, err = getConfig(t.TempDir(), ConnectNone)
+		assert.ErrorContains(t, err, "EVO_DB_PARAMS may not set '"+key+"'")
+	}
+}
+
+func TestConnUrlSocket(t *testing.T) {
+	config := &Config{
+		Hostname:      "/var/run/postgresql:5433",
+		Database:      Database,
+		AdminUsername: AdminUsername,
+		AdminPassword: AdminPassword,
+		Username:      Username,
+		Password:      Password,
+	}
+
+	connConfig, err := pgx.ParseConfig(config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/run/postgresql", connConfig.Host)
+	assert.Equal(t, uint16(5433), connConfig.Port)
+	assert.Equal(t, Database, connConfig.Database)
+	assert.Equal(t, AdminUsername, connConfig.User)
+	assert.Equal(t, AdminPassword, connConfig.Password)
+
+	config.Hostname = "/var/run/postgresql"
+	connConfig, err = pgx.ParseConfig(config.GetUserConnUrl("postgres"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/run/postgresql", connConfig.Host)
+	assert.Equal(t, uint16(5432), connConfig.Port)
+	assert.Equal(t, "postgres", connConfig.Database)
+	assert.Equal(t, Username, connConfig.User)
+}
+
+func TestConnUrlClientCert(t *testing.T) {
+	t.Setenv("EVO_CONFIG", "")
+	t.Setenv("EVO_DB_HOST", "db.example.com:5432")
+	t.Setenv("EVO_DB_DATABASE", "app")
+	t.Setenv("EVO_DB_ADMIN_USERNAME", "admin")
+	t.Setenv("EVO_DB_ADMIN_PASSWORD", "")
+	t.Setenv("EVO_DB_USERNAME", "app_user")
+	t.Setenv("EVO_DB_PASSWORD", "")
+	t.Setenv("EVO_DB_SSLCERT", "/etc/evo/client.crt")
+	t.Setenv("EVO_DB_SSLKEY", "")
+	t.Setenv("EVO_DB_SSLROOTCERT", "/etc/evo/ca.crt")
+
+	// the key must accompany the certificate
+	_, err := getConfig(t.TempDir(), ConnectAdmin)
+	assert.ErrorContains(t, err, "EVO_DB_SSLCERT and EVO_DB_SSLKEY must be defined together")
+
+	// with a client certificate the passwords are optional
+	t.Setenv("EVO_DB_SSLKEY", "/etc/evo/client.key")
+	config, err := getConfig(t.TempDir(), ConnectAdmin)
+	assert.NoError(t, err)
+
+	for _, connUrl := range []string{config.GetAdminConnUrl(), config.GetUserConnUrl()} {
+		parsed, err := url.Parse(connUrl)
+		assert.NoError(t, err)
+		assert.Equal(t, "/etc/evo/client.crt", parsed.Query().Get("sslcert"))
+		assert.Equal(t, "/etc/evo/client.key", parsed.Query().Get("sslkey"))
+		assert.Equal(t, "/etc/evo/ca.crt", parsed.Query().Get("sslrootcert"))
+		_, hasPassword := parsed.User.Password()
+		assert.False(t, hasPassword)
+	}
+
+	// without one, they are required
+	t.Setenv("EVO_DB_SSLCERT", "")
+	t.Setenv("EVO_DB_SSLKEY", "")
+	_, err = getConfig(t.TempDir(), ConnectAdmin)
+	assert.ErrorContains(t, err, "EVO_DB_ADMIN_PASSWORD was not defined")
+}
+
+func TestFailOnEmpty(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a migrator"), 0o644)
+	assert.NoError(t, err)
+
+	// the check precedes any connection, so no database is needed
+	config := &Config{
+		Directory:   dir,
+		FailOnEmpty: true,
+	}
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "no migrators found")
+}
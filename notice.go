@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// noticeLogger logs the NOTICE and WARNING messages raised by the server, which pgx otherwise discards, tagged
+// with the migrator executing at the time
+type noticeLogger struct {
+	// migName is the migrator currently executing, empty outside of a migrator
+	migName string
+}
+
+// handler returns the notice handler to install on a connection, or nil when notices are not to be shown
+func (n *noticeLogger) handler(config *Config) pgconn.NoticeHandler {
+	if !config.ShowNotices {
+		return nil
+	}
+	return n.log
+}
+
+func (n *noticeLogger) log(_ *pgconn.PgConn, notice *pgconn.Notice) {
+	switch notice.Severity {
+	case "NOTICE", "WARNING":
+	default:
+		return
+	}
+
+	if len(n.migName) == 0 {
+		logf("%s: %s\n", notice.Severity, notice.Message)
+		return
+	}
+	logf("[%s] %s: %s\n", n.migName, notice.Severity, notice.Message)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestShowNotices(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	var output bytes.Buffer
+	logOutput = &output
+	defer func() {
+		logOutput = os.Stdout
+	}()
+
+	config.Directory = t.TempDir()
+	config.ShowNotices = true
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE noisy (id INT);",
+		"0002_notice.sql":     "CREATE TABLE IF NOT EXISTS noisy (id INT);\nDO $$ BEGIN RAISE WARNING 'deprecated thing'; END $$;",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	assert.Contains(t, output.String(), `[0002_notice.sql] NOTICE: relation "noisy" already exists, skipping`)
+	assert.Contains(t, output.String(), "[0002_notice.sql] WARNING: deprecated thing")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// notifyPayloadLimit is the longest payload postgres accepts for a NOTIFY, in bytes
+const notifyPayloadLimit = 7999
+
+// NotifyPayload is the json payload of the NOTIFY issued on EVO_NOTIFY_CHANNEL once a run applies migrators
+type NotifyPayload struct {
+	Database string `json:"database"`
+	RunID    string `json:"run_id"`
+	// Applied is the number of migrators applied by the run, Migrators their names in order of application
+	Applied   int      `json:"applied"`
+	Migrators []string `json:"migrators"`
+	// Truncated is set when the names of the later migrators were dropped to fit the payload within the limit
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// formatNotifyPayload renders the payload of the notification for the given entries, dropping the names of
+// migrators from the end until it fits
+func formatNotifyPayload(config *Config, entries []ManifestEntry) (string, error) {
+	payload := NotifyPayload{
+		Database:  config.Database,
+		RunID:     getRunID(config),
+		Applied:   len(entries),
+		Migrators: make([]string, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		payload.Migrators = append(payload.Migrators, entry.Migrator)
+	}
+
+	for {
+		content, err := json.Marshal(payload)
+		if err != nil {
+			return "", err
+		}
+		if len(content) <= notifyPayloadLimit || len(payload.Migrators) == 0 {
+			return string(content), nil
+		}
+		payload.Migrators = payload.Migrators[:len(payload.Migrators)-1]
+		payload.Truncated = true
+	}
+}
+
+// notifyCompletion issues a NOTIFY on the configured channel, on a connection of its own once the run has
+// committed, when the run succeeded in applying migrators.  the error of the run, if any, is returned in
+// preference to a failure to notify.
+func notifyCompletion(config *Config, entries []ManifestEntry, runErr error) error {
+	if len(config.NotifyChannel) == 0 || runErr != nil || len(entries) == 0 {
+		return runErr
+	}
+
+	payload, err := formatNotifyPayload(config, entries)
+	if err != nil {
+		return fmt.Errorf("unable to format the notification payload: %w", err)
+	}
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	if err != nil {
+		return connectError(fmt.Errorf("unable to connect to notify channel '%s': %w", config.NotifyChannel, err))
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	logf("notifying channel '%s' of %d applied migrators\n", config.NotifyChannel, len(entries))
+	_, err = conn.Exec(context.Background(), "SELECT pg_notify($1, $2)", config.NotifyChannel, payload)
+	if err != nil {
+		return fmt.Errorf("unable to notify channel '%s': %w", config.NotifyChannel, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestFormatNotifyPayload(t *testing.T) {
+	config := &Config{Database: "testdb", RunID: "job-1"}
+	payload, err := formatNotifyPayload(config, []ManifestEntry{{Migrator: "0001_first.sql"}, {Migrator: "0002_second.sql"}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"database": "testdb", "run_id": "job-1", "applied": 2, "migrators": ["0001_first.sql", "0002_second.sql"]}`, payload)
+
+	// the names of many migrators are cut short to fit a notification
+	entries := []ManifestEntry{}
+	for i := range 500 {
+		entries = append(entries, ManifestEntry{Migrator: fmt.Sprintf("%04d_%s.sql", i, strings.Repeat("x", 20))})
+	}
+	payload, err = formatNotifyPayload(config, entries)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(payload), notifyPayloadLimit)
+
+	var decoded NotifyPayload
+	assert.NoError(t, json.Unmarshal([]byte(payload), &decoded))
+	assert.Equal(t, 500, decoded.Applied)
+	assert.True(t, decoded.Truncated)
+	assert.NotEmpty(t, decoded.Migrators)
+	assert.Equal(t, "0000_"+strings.Repeat("x", 20)+".sql", decoded.Migrators[0])
+}
+
+func TestNotifyChannel(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	config.RunID = "job-1"
+	config.NotifyChannel = "evo_migrated"
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	listenerConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = listenerConn.Close(context.Background())
+	}()
+	_, err = listenerConn.Exec(context.Background(), "LISTEN evo_migrated")
+	assert.NoError(t, err)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+		"0003_third.sql":  "CREATE TABLE third (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	notification, err := listenerConn.WaitForNotification(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "evo_migrated", notification.Channel)
+	assert.JSONEq(t, `{"database": "testdb", "run_id": "job-1", "applied": 2, "migrators": ["0002_second.sql", "0003_third.sql"]}`, notification.Payload)
+
+	// a run applying nothing notifies no one
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = listenerConn.WaitForNotification(ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CreatedObject is an object created by a migrator, as recorded in evo_mg_objects
+type CreatedObject struct {
+	Migrator string
+	Type     string
+	Name     string
+}
+
+// createdObjectTypes are the kinds of object whose creation is recorded, keyed by the keyword following CREATE
+var createdObjectTypes = map[string]string{
+	"TABLE":     "table",
+	"INDEX":     "index",
+	"VIEW":      "view",
+	"FUNCTION":  "function",
+	"PROCEDURE": "procedure",
+	"SEQUENCE":  "sequence",
+	"TYPE":      "type",
+	"DOMAIN":    "domain",
+	"SCHEMA":    "schema",
+	"TRIGGER":   "trigger",
+	"EXTENSION": "extension",
+	"POLICY":    "policy",
+}
+
+// objectsTable returns the schema qualified name of the evo_mg_objects table
+func objectsTable(config *Config) string {
+	return pgx.Identifier{migrationSchema(config), "evo_mg_objects"}.Sanitize()
+}
+
+// normalizeIdentifier folds an unquoted, possibly schema qualified, identifier to lower case as postgres does,
+// and strips the quotes from quoted parts
+func normalizeIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if strings.HasPrefix(part, `"`) && strings.HasSuffix(part, `"`) && len(part) > 1 {
+			parts[i] = strings.ReplaceAll(part[1:len(part)-1], `""`, `"`)
+			continue
+		}
+		parts[i] = strings.ToLower(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// objectName extracts the name from the token following the keywords of a statement, e.g. "touch()" or
+// "things(id"
+func objectName(token string) string {
+	name, _, _ := strings.Cut(token, "(")
+	return normalizeIdentifier(strings.TrimRight(name, ";"))
+}
+
+// skipKeywords advances past any of the given keywords, returning the index of the first token which is not one
+func skipKeywords(tokens []string, i int, keywords ...string) int {
+	for i < len(tokens) {
+		matched := false
+		for _, keyword := range keywords {
+			if strings.EqualFold(tokens[i], keyword) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			break
+		}
+		i++
+	}
+	return i
+}
+
+// createdObjects makes a best effort at listing the objects created by sql, from its CREATE statements and the
+// columns added by its ALTER TABLE statements.  objects created dynamically, e.g. by a DO block, are not seen.
+func createdObjects(sql string) []CreatedObject {
+	var objects []CreatedObject
+	for _, statement := range splitStatements(sql) {
+		tokens := strings.Fields(stripComments(statement))
+		if len(tokens) < 3 {
+			continue
+		}
+
+		switch strings.ToUpper(tokens[0]) {
+		case "CREATE":
+			i := skipKeywords(tokens, 1, "OR", "REPLACE", "UNIQUE", "TEMP", "TEMPORARY", "UNLOGGED", "MATERIALIZED", "CONSTRAINT", "TRUSTED", "PROCEDURAL")
+			if i >= len(tokens) {
+				continue
+			}
+			objectType, ok := createdObjectTypes[strings.ToUpper(tokens[i])]
+			if !ok {
+				continue
+			}
+			i = skipKeywords(tokens, i+1, "CONCURRENTLY", "IF", "NOT", "EXISTS")
+			// an index may be left to be named by the server
+			if i >= len(tokens) || strings.EqualFold(tokens[i], "ON") {
+				continue
+			}
+			objects = append(objects, CreatedObject{Type: objectType, Name: objectName(tokens[i])})
+		case "ALTER":
+			if !strings.EqualFold(tokens[1], "TABLE") {
+				continue
+			}
+			i := skipKeywords(tokens, 2, "IF", "EXISTS", "ONLY")
+			if i >= len(tokens) {
+				continue
+			}
+			table := objectName(tokens[i])
+			for i++; i < len(tokens); i++ {
+				if !strings.EqualFold(tokens[i], "ADD") {
+					continue
+				}
+				j := skipKeywords(tokens, i+1, "COLUMN", "IF", "NOT", "EXISTS")
+				// constraints are added by ADD CONSTRAINT, PRIMARY KEY and so on, rather than being columns
+				if j >= len(tokens) || isConstraintKeyword(tokens[j]) {
+					continue
+				}
+				objects = append(objects, CreatedObject{Type: "column", Name: table + "." + objectName(tokens[j])})
+			}
+		}
+	}
+
+	return objects
+}
+
+// isConstraintKeyword reports whether the token following ADD introduces a constraint rather than a column
+func isConstraintKeyword(token string) bool {
+	switch strings.ToUpper(token) {
+	case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "EXCLUDE":
+		return true
+	}
+	return false
+}
+
+// ensureObjectsTable creates the evo_mg_objects table, which records the objects created by each migrator
+func ensureObjectsTable(conn *pgx.Conn, config *Config) error {
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (migrator TEXT NOT NULL, object_type TEXT NOT NULL, object_name TEXT NOT NULL, created_at TIMESTAMPTZ DEFAULT NOW())", objectsTable(config)))
+	if err != nil {
+		return fmt.Errorf("unable to create evo objects table: %w", err)
+	}
+
+	if len(config.SystemTableOwner) > 0 {
+		return ensureTableOwner(conn, objectsTable(config), config.SystemTableOwner)
+	}
+	return nil
+}
+
+// recordObjects records the objects created by a migrator, alongside its record in evo_mg
+func recordObjects(conn Executable, config *Config, migName string, sql string) error {
+	for _, object := range createdObjects(sql) {
+		_, err := conn.Exec(context.Background(), fmt.Sprintf("INSERT INTO %s (migrator, object_type, object_name) VALUES ($1, $2, $3)", objectsTable(config)), migName, object.Type, object.Name)
+		if err != nil {
+			return fmt.Errorf("unable to record objects created by migrator '%s': %w", migName, err)
+		}
+	}
+	return nil
+}
+
+// unrecordObjects removes the objects recorded for a migrator which has been rolled back
+func unrecordObjects(conn Executable, config *Config, migName string) error {
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("DELETE FROM %s WHERE migrator = $1", objectsTable(config)), migName)
+	return err
+}
+
+// blame returns the recorded creations of the named object.  a name without a schema also matches the object
+// in any schema, by comparing the end of the recorded names, in which _ and % are not wildcards.
+func blame(conn *pgx.Conn, config *Config, object string) ([]CreatedObject, error) {
+	name := normalizeIdentifier(object)
+	rows, err := conn.Query(context.Background(), fmt.Sprintf("SELECT migrator, object_type, object_name FROM %s WHERE object_name = $1 OR right(object_name, length($1) + 1) = '.' || $1 ORDER BY created_at, migrator", objectsTable(config)), name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read evo objects table: %w", err)
+	}
+	defer rows.Close()
+
+	var objects []CreatedObject
+	for rows.Next() {
+		var object CreatedObject
+		if err := rows.Scan(&object.Migrator, &object.Type, &object.Name); err != nil {
+			return nil, fmt.Errorf("failed to read object record: %w", err)
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, rows.Err()
+}
+
+func runBlame(config *Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: evo blame <directory> <object>")
+	}
+
+	logf("connecting to database '%s' as user '%s' (read only)\n", config.Database, config.Username)
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	if err != nil {
+		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	objects, err := blame(conn, config, args[0])
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("no migrator is recorded as creating '%s', objects are only recorded while EVO_RECORD_OBJECTS is set", args[0])
+	}
+
+	for _, object := range objects {
+		fmt.Fprintf(os.Stdout, "%s %s introduced by %s\n", object.Type, object.Name, object.Migrator)
+	}
+	return nil
+}
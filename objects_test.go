@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestCreatedObjects(t *testing.T) {
+	objects := createdObjects(`-- users of the system
+CREATE TABLE IF NOT EXISTS Users (id INT PRIMARY KEY);
+CREATE UNIQUE INDEX CONCURRENTLY ix_users_id ON users (id);
+CREATE INDEX ON users (id);
+CREATE OR REPLACE FUNCTION billing."Touch"() RETURNS void AS $$ BEGIN CREATE TABLE nested (id INT); END $$ LANGUAGE plpgsql;
+CREATE MATERIALIZED VIEW totals AS SELECT count(*) FROM users;
+ALTER TABLE ONLY users ADD COLUMN email TEXT, ADD name TEXT, ADD CONSTRAINT users_email UNIQUE (email);
+ALTER TABLE users ADD PRIMARY KEY (id);
+INSERT INTO users (id) VALUES (1);`)
+	assert.Equal(t, []CreatedObject{
+		{Type: "table", Name: "users"},
+		{Type: "index", Name: "ix_users_id"},
+		{Type: "function", Name: "billing.Touch"},
+		{Type: "view", Name: "totals"},
+		{Type: "column", Name: "users.email"},
+		{Type: "column", Name: "users.name"},
+	}, objects)
+}
+
+func TestBlame(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.RecordObjects = true
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_users.sql":  "CREATE TABLE users (id INT);",
+		"0002_orders.sql": "CREATE TABLE orders (id INT); ALTER TABLE users ADD COLUMN email TEXT;",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	objects, err := blame(conn, config, "users")
+	assert.NoError(t, err)
+	assert.Equal(t, []CreatedObject{{Migrator: "0001_users.sql", Type: "table", Name: "users"}}, objects)
+
+	objects, err = blame(conn, config, "users.email")
+	assert.NoError(t, err)
+	assert.Equal(t, []CreatedObject{{Migrator: "0002_orders.sql", Type: "column", Name: "users.email"}}, objects)
+
+	objects, err = blame(conn, config, "missing")
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+
+	// the characters LIKE takes for wildcards match only themselves
+	for _, pattern := range []string{"%", "_mail", "users._mail"} {
+		objects, err = blame(conn, config, pattern)
+		assert.NoError(t, err)
+		assert.Empty(t, objects, pattern)
+	}
+
+	// rolling a migrator back forgets its objects
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_orders.down.sql": "DROP TABLE orders; ALTER TABLE users DROP COLUMN email;",
+	})
+	err = rollback(conn, config, 1, false)
+	assert.NoError(t, err)
+
+	objects, err = blame(conn, config, "orders")
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+}
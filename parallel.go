@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// defaultParallel is the number of databases migrated at once unless EVO_PARALLEL says otherwise
+const defaultParallel = 1
+
+type DatabaseResult struct {
+	Database string
+	// PasswordReset is set when a run for the database reset the password of the user, as reported by the
+	// PasswordReset of its ProgressEvent
+	PasswordReset bool
+	Err           error
+}
+
+// forEachDatabase calls fn with a copy of config for each of the configured databases, running at most
+// config.Parallel of them at once.  every database is attempted regardless of failures, unless config.FailFast
+// is set, in which case no further databases are started after the first failure.  results are returned in the
+// order the databases were configured, databases which were never started have no result.  the progress reported for
+// each database is passed on to config.Progress, if set.
+func forEachDatabase(config *Config, fn func(config *Config) error) []DatabaseResult {
+	parallel := max(config.Parallel, defaultParallel)
+
+	results := make([]*DatabaseResult, len(config.Databases))
+	slots := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+	failed := false
+	for i, database := range config.Databases {
+		slots <- struct{}{}
+
+		mutex.Lock()
+		stop := failed && config.FailFast
+		mutex.Unlock()
+		if stop {
+			<-slots
+			break
+		}
+
+		dbConfig := *config
+		dbConfig.Database = database
+		dbConfig.Databases = []string{database}
+		// the outcome of each run is gathered from its progress, leaving config alone
+		passwordReset := false
+		dbConfig.Progress = func(event ProgressEvent) {
+			if event.Stage == ProgressRunComplete && event.PasswordReset {
+				passwordReset = true
+			}
+			if config.Progress != nil {
+				config.Progress(event)
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				<-slots
+			}()
+
+			err := fn(&dbConfig)
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[i] = &DatabaseResult{
+				Database:      database,
+				PasswordReset: passwordReset,
+				Err:           err,
+			}
+			if err != nil {
+				failed = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	completed := make([]DatabaseResult, 0, len(results))
+	for _, result := range results {
+		if result != nil {
+			completed = append(completed, *result)
+		}
+	}
+	return completed
+}
+
+// migrateDatabases migrates each of the configured databases, reporting the outcome for each.  an error is
+// returned if any database failed or was not attempted.
+func migrateDatabases(config *Config) error {
+	results := forEachDatabase(config, migrate)
+
+	failures := len(config.Databases) - len(results)
+	for _, result := range results {
+		if result.Err != nil {
+			failures++
+			logf("database '%s' failed: %s\n", result.Database, result.Err.Error())
+			continue
+		}
+		if result.PasswordReset {
+			logf("database '%s' migrated, the password of user '%s' was reset\n", result.Database, config.Username)
+			continue
+		}
+		logf("database '%s' migrated\n", result.Database)
+	}
+	if len(results) < len(config.Databases) {
+		logf("%d databases not attempted after a failure\n", len(config.Databases)-len(results))
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d databases failed to migrate", failures, len(config.Databases))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestMigrateDatabasesParallel(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Databases = []string{"tenant_a", "tenant_b", "tenant_c", "tenant_d", "tenant_e"}
+	config.Parallel = 3
+	err = migrateDatabases(config)
+	assert.NoError(t, err)
+
+	for _, database := range config.Databases {
+		conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl(database))
+		assert.NoError(t, err)
+
+		pastMigrations, err := getPastMigrations(conn, config)
+		assert.NoError(t, err)
+		assert.Len(t, pastMigrations, 5, database)
+		assert.Contains(t, pastMigrations, "0005_add_index.sql", database)
+
+		_ = conn.Close(context.Background())
+	}
+}
+
+func TestForEachDatabase(t *testing.T) {
+	config := &Config{
+		Databases: []string{"a", "b", "c", "d", "e", "f"},
+		Parallel:  2,
+	}
+
+	var running, peak atomic.Int32
+	results := forEachDatabase(config, func(config *Config) error {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			previous := peak.Load()
+			if current <= previous || peak.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		reportProgress(config, ProgressEvent{Stage: ProgressRunComplete, PasswordReset: config.Database == "c"})
+		if config.Database == "b" {
+			return fmt.Errorf("unable to migrate")
+		}
+		return nil
+	})
+	assert.LessOrEqual(t, peak.Load(), int32(2))
+
+	// every database is attempted despite the failure, and results keep the configured order
+	assert.Len(t, results, 6)
+	for i, result := range results {
+		assert.Equal(t, config.Databases[i], result.Database)
+		if result.Database == "b" {
+			assert.Error(t, result.Err)
+			continue
+		}
+		assert.NoError(t, result.Err)
+		// the reset reported by the run is returned with its result
+		assert.Equal(t, result.Database == "c", result.PasswordReset, result.Database)
+	}
+
+	config.Parallel = 1
+	config.FailFast = true
+	results = forEachDatabase(config, func(config *Config) error {
+		if config.Database == "b" {
+			return fmt.Errorf("unable to migrate")
+		}
+		return nil
+	})
+	assert.Len(t, results, 2)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type PlannedMigrator struct {
+	// Set is the migration set holding the migrator, when EVO_PER_DIRECTORY is set
+	Set           string `json:"set,omitempty"`
+	Name          string `json:"name"`
+	Transactional bool   `json:"transactional"`
+	Bytes         int    `json:"bytes"`
+	SQL           string `json:"sql,omitempty"`
+}
+
+// getPlan renders each pending migrator, in order of application, without applying anything
+func getPlan(conn *pgx.Conn, config *Config, includeSQL bool) ([]PlannedMigrator, error) {
+	status, err := getMigrationStatus(conn, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// render as the run would, against the migrators applied so far
+	config.AppliedBefore = map[string]struct{}{}
+	for _, migName := range status.Applied {
+		key, err := migratorKey(config, migName)
+		if err != nil {
+			return nil, err
+		}
+		config.AppliedBefore[key] = struct{}{}
+	}
+	for _, key := range status.Missing {
+		config.AppliedBefore[key] = struct{}{}
+	}
+
+	data, err := getTemplateData(config)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := []PlannedMigrator{}
+	for _, migName := range status.Pending {
+		path := filepath.Join(config.Directory, migName)
+		rendered, err := renderMigrator(config, path, migName, data)
+		if err != nil {
+			return nil, err
+		}
+
+		transactional, err := isTransactional(config, path, rendered.SQL)
+		if err != nil {
+			return nil, err
+		}
+
+		planned := PlannedMigrator{
+			Name:          migName,
+			Transactional: transactional,
+			Bytes:         len(rendered.SQL),
+		}
+		if includeSQL {
+			// secrets are masked, the plan may well end up in ci logs
+			planned.SQL = rendered.Redacted
+		}
+		plan = append(plan, planned)
+	}
+
+	return plan, nil
+}
+
+func writePlan(w io.Writer, plan []PlannedMigrator, asJson bool) error {
+	if asJson {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+
+	for _, planned := range plan {
+		mode := "transactional"
+		if !planned.Transactional {
+			mode = "non-transactional"
+		}
+		name := planned.Name
+		if len(planned.Set) > 0 {
+			name = planned.Set + "/" + name
+		}
+		_, err := fmt.Fprintf(w, "%s (%s, %d bytes)\n", name, mode, planned.Bytes)
+		if err != nil {
+			return err
+		}
+		if len(planned.SQL) > 0 {
+			_, err = fmt.Fprintf(w, "%s\n\n", planned.SQL)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// connectForPlan connects to the database read only as the user.  where the user's password is rejected and
+// EVO_AUTO_UPDATE_PASSWORD would have a run reset it, the reset is reported instead of performed, and the plan is
+// made as the admin user.  reports whether a run would reset the password.
+func connectForPlan(config *Config) (*pgx.Conn, bool, error) {
+	logf("connecting to database '%s' as user '%s' (read only)\n", config.Database, config.Username)
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	if err == nil {
+		return conn, false, nil
+	}
+	if !hasCode(err, "28P01") || !config.AutoUpdatePassword || config.ExternalUser || config.SkipUserVerify {
+		return nil, false, fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+
+	logf("would reset password for user '%s'\n", config.Username)
+	logf("connecting to database '%s' as admin user '%s' (read only)\n", config.Database, config.AdminUsername)
+	conn, err = connectReadOnly(config.GetAdminConnUrl())
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	return conn, true, nil
+}
+
+func runPlan(config *Config, args []string) error {
+	flags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	asJson := flags.Bool("json", false, "output the plan as a json array")
+	includeSQL := flags.Bool("include-sql", false, "include the rendered sql of each migrator")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *asJson {
+		// keep stdout clean for the machine readable plan
+		logOutput = os.Stderr
+	}
+
+	conn, _, err := connectForPlan(config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	plan := []PlannedMigrator{}
+	err = forEachSet(config, func(set string, setConfig *Config) error {
+		setPlan, err := getPlan(conn, setConfig, *includeSQL)
+		if err != nil {
+			return err
+		}
+		for _, planned := range setPlan {
+			planned.Set = set
+			plan = append(plan, planned)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return writePlan(os.Stdout, plan, *asJson)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestPlanListsPending(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0003_third_notrans.sql": "CREATE INDEX CONCURRENTLY ix_second ON second (id);",
+		"0002_second.sql":        "CREATE TABLE second (id INT);",
+	})
+
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	plan, err := getPlan(conn, config, false)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = writePlan(&buf, plan, true)
+	assert.NoError(t, err)
+
+	var decoded []PlannedMigrator
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, []PlannedMigrator{
+		{Name: "0002_second.sql", Transactional: true, Bytes: len("CREATE TABLE second (id INT);")},
+		{Name: "0003_third_notrans.sql", Transactional: false, Bytes: len("CREATE INDEX CONCURRENTLY ix_second ON second (id);")},
+	}, decoded)
+}
+
+func TestPlanRedactsSecrets(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	t.Setenv("EVO_SECRET_ENCRYPTION_KEY", "s3cr3t")
+	source := "CREATE TABLE keys (key TEXT); INSERT INTO keys (key) VALUES ('{{ secret \"ENCRYPTION_KEY\" }}');"
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_seed_key.sql": source,
+	})
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	// the secret reaches the database, but not the tracking metadata
+	var key string
+	err = standardConn.QueryRow(context.Background(), "SELECT key FROM keys").Scan(&key)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", key)
+
+	var recorded string
+	err = standardConn.QueryRow(context.Background(), "SELECT checksum FROM evo_mg WHERE migrator = '0001_seed_key.sql'").Scan(&recorded)
+	assert.NoError(t, err)
+	assert.Equal(t, checksum(source), recorded)
+
+	// a pending migrator using the secret is printed redacted
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_rotate_key.sql": "UPDATE keys SET key = '{{ secret \"ENCRYPTION_KEY\" }}';",
+	})
+	plan, err := getPlan(standardConn, config, true)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = writePlan(&buf, plan, false)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "UPDATE keys SET key = '[redacted]';")
+	assert.NotContains(t, buf.String(), "s3cr3t")
+}
+
+func TestPlanPasswordReset(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.AutoUpdatePassword = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// the user's password was changed out of band
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	_, err = adminConn.Exec(context.Background(), "ALTER ROLE username PASSWORD 'stale'")
+	assert.NoError(t, err)
+
+	var output bytes.Buffer
+	logOutput = &output
+	defer func() {
+		logOutput = os.Stdout
+	}()
+	conn, wouldReset, err := connectForPlan(config)
+	assert.NoError(t, err)
+	assert.True(t, wouldReset)
+	assert.Contains(t, output.String(), "would reset password for user 'username'")
+	plan, err := getPlan(conn, config, false)
+	assert.NoError(t, err)
+	assert.Empty(t, plan)
+	_ = conn.Close(context.Background())
+
+	// the plan left the password alone
+	_, err = pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.Error(t, err)
+
+	// which a run resets, reporting the reset once complete
+	var complete ProgressEvent
+	config.Progress = func(event ProgressEvent) {
+		if event.Stage == ProgressRunComplete {
+			complete = event
+		}
+	}
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	assert.True(t, complete.PasswordReset)
+	assert.Contains(t, output.String(), "updating password for user 'username'")
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	assert.False(t, complete.PasswordReset)
+}
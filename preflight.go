@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// adminHasAttribute reports whether the connected admin user is a superuser or has the given role attribute,
+// which is one of the boolean columns of pg_roles, e.g. rolcreatedb
+func adminHasAttribute(conn Queryable, column string) (bool, error) {
+	var has bool
+	err := conn.QueryRow(context.Background(), fmt.Sprintf("SELECT rolsuper OR %s FROM pg_roles WHERE rolname = current_user", column)).Scan(&has)
+	if err != nil {
+		return false, fmt.Errorf("unable to query attributes of the admin user: %w", err)
+	}
+	return has, nil
+}
+
+// maintenanceDatabase is the database through which evo administers the server, holding its lock table and never
+// meant to be migrated
+const maintenanceDatabase string = "postgres"
+
+// guardMaintenanceDatabase refuses a run against the maintenance database, which would otherwise be given the
+// evo tables and the migrators, unless EVO_ALLOW_MAINTENANCE_DB is set
+func guardMaintenanceDatabase(config *Config) error {
+	if config.Database != maintenanceDatabase || config.AllowMaintenanceDB {
+		return nil
+	}
+	return fmt.Errorf("refusing to migrate the maintenance database '%s', check EVO_DB_DATABASE or set EVO_ALLOW_MAINTENANCE_DB=1 if this is intended", maintenanceDatabase)
+}
+
+// preflight ensures that the admin user holds the attributes needed to create the database and the user, when
+// they do not yet exist, so that a missing attribute fails early with an actionable error rather than deep
+// inside CREATE DATABASE or CREATE USER
+func preflight(conn Queryable, config *Config) error {
+	var databaseExists, userExists bool
+	err := conn.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1), EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $2)", config.Database, config.Username).Scan(&databaseExists, &userExists)
+	if err != nil {
+		return fmt.Errorf("unable to query for existing database and user: %w", err)
+	}
+
+	// a database which evo will not create, or may wait for, is dealt with by ensureDatabase
+	if !databaseExists && !config.SkipCreateDatabase && !config.WaitForDatabase {
+		has, err := adminHasAttribute(conn, "rolcreatedb")
+		if err != nil {
+			return err
+		}
+		if !has {
+			return fmt.Errorf("admin user '%s' needs CREATEDB to create database '%s', grant it with ALTER ROLE %s CREATEDB or create the database beforehand", config.AdminUsername, config.Database, config.AdminUsername)
+		}
+	}
+
+	if !userExists && !config.ExternalUser {
+		has, err := adminHasAttribute(conn, "rolcreaterole")
+		if err != nil {
+			return err
+		}
+		if !has {
+			return fmt.Errorf("admin user '%s' needs CREATEROLE to create user '%s', grant it with ALTER ROLE %s CREATEROLE or create the user beforehand", config.AdminUsername, config.Username, config.AdminUsername)
+		}
+	}
+
+	return nil
+}
+
+// forbidSuperuser refuses to proceed when the connected user is a superuser, catching an application user which
+// was mistakenly granted superuser
+func forbidSuperuser(conn Queryable, config *Config) error {
+	var superuser bool
+	err := conn.QueryRow(context.Background(), "SELECT rolsuper FROM pg_roles WHERE rolname = current_user").Scan(&superuser)
+	if err != nil {
+		return fmt.Errorf("unable to query attributes of user '%s': %w", config.Username, err)
+	}
+	if superuser {
+		return fmt.Errorf("user '%s' is a superuser, which EVO_FORBID_SUPERUSER forbids", config.Username)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestPreflightWithoutCreateDb(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	_, err = adminConn.Exec(context.Background(), "CREATE ROLE limited WITH LOGIN CREATEROLE NOCREATEDB PASSWORD 'limited'")
+	assert.NoError(t, err)
+
+	// the limited admin cannot create the lock table in the postgres database
+	config.AdminUsername = "limited"
+	config.AdminPassword = "limited"
+	config.LockMode = LockModeAdvisory
+	err = doMigration(config, nil)
+	assert.EqualError(t, err, "admin user 'limited' needs CREATEDB to create database 'testdb', grant it with ALTER ROLE limited CREATEDB or create the database beforehand")
+
+	// once the database exists, CREATEDB is no longer needed
+	_, err = adminConn.Exec(context.Background(), "CREATE DATABASE testdb OWNER limited")
+	assert.NoError(t, err)
+	err = preflight(adminConn, config)
+	assert.NoError(t, err)
+}
+
+func TestForbidSuperuser(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	config.ForbidSuperuser = true
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	_, err = adminConn.Exec(context.Background(), "ALTER USER "+Username+" SUPERUSER")
+	assert.NoError(t, err)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.EqualError(t, err, "user 'username' is a superuser, which EVO_FORBID_SUPERUSER forbids")
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.NotContains(t, pastMigrations, "0002_second.sql")
+}
+
+func TestGuardMaintenanceDatabase(t *testing.T) {
+	// the refusal precedes any connection
+	config := &Config{Database: "postgres", Directory: t.TempDir()}
+	err := doMigration(config, nil)
+	assert.ErrorContains(t, err, "refusing to migrate the maintenance database 'postgres'")
+
+	config.AllowMaintenanceDB = true
+	assert.NoError(t, guardMaintenanceDatabase(config))
+	assert.NoError(t, guardMaintenanceDatabase(&Config{Database: "app"}))
+}
+
+func TestAllowMaintenanceDatabase(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Database = "postgres"
+	config.AllowMaintenanceDB = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+}
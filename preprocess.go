@@ -0,0 +1,26 @@
+package main
+
+import "fmt"
+
+// PreprocessFunc rewrites the rendered sql of the migrator named name before it executes, e.g. to strip a preamble
+// generated by an ORM.  an error aborts the migrator.
+type PreprocessFunc func(name string, sql string) (string, error)
+
+// preprocess passes the rendered sql of a migrator through Config.Preprocess, when set.  the checksum remains that
+// of the rendered migrator, so that registering a preprocessor does not alter the recorded history.
+func preprocess(config *Config, rendered *RenderedMigrator, migName string) error {
+	if config.Preprocess == nil {
+		return nil
+	}
+
+	sql, err := config.Preprocess(migName, rendered.SQL)
+	if err != nil {
+		return fmt.Errorf("unable to preprocess migrator '%s': %w", migName, err)
+	}
+	// a migrator without secrets is displayed as it executes
+	if rendered.Redacted == rendered.SQL {
+		rendered.Redacted = sql
+	}
+	rendered.SQL = sql
+	return nil
+}
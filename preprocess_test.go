@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestPreprocess(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	// the preamble of a generated migrator points at a schema which does not exist
+	config.Preprocess = func(name string, sql string) (string, error) {
+		if strings.HasPrefix(name, "0002") {
+			return "", errors.New("generated migrator is not supported")
+		}
+		return strings.ReplaceAll(sql, "SET search_path TO generated;", ""), nil
+	}
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "SET search_path TO generated;\nCREATE TABLE things (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	var inPublic bool
+	err = conn.QueryRow(context.Background(), "SELECT to_regclass('public.things') IS NOT NULL").Scan(&inPublic)
+	assert.NoError(t, err)
+	assert.True(t, inPublic)
+
+	// the checksum is that of the migrator as rendered, before preprocessing
+	var recorded string
+	err = conn.QueryRow(context.Background(), "SELECT checksum FROM evo_mg WHERE migrator = '0001_make_table.sql'").Scan(&recorded)
+	assert.NoError(t, err)
+	assert.Equal(t, checksum("SET search_path TO generated;\nCREATE TABLE things (id INT);"), recorded)
+
+	// an error from the preprocessor aborts the migrator
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_add_name.sql": "ALTER TABLE things ADD COLUMN name TEXT;",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "generated migrator is not supported")
+	var failed *ErrMigratorFailed
+	assert.ErrorAs(t, err, &failed)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var tablePrivileges = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"}
+
+// grantableObjects are the kinds of object created in the public schema on which the user, or the grant role, is
+// given default privileges, each configured by its own setting
+var grantableObjects = []struct {
+	setting string
+	objects string
+	// objType is the defaclobjtype of the objects in pg_default_acl
+	objType    string
+	privileges []string
+}{
+	{setting: "EVO_GRANT_TABLES", objects: "TABLES", objType: "r", privileges: tablePrivileges},
+	{setting: "EVO_GRANT_SEQUENCES", objects: "SEQUENCES", objType: "S", privileges: []string{"USAGE", "SELECT", "UPDATE"}},
+	{setting: "EVO_GRANT_FUNCTIONS", objects: "FUNCTIONS", objType: "f", privileges: []string{"EXECUTE"}},
+}
+
+// ObjectPrivileges are the default privileges granted on a kind of object
+type ObjectPrivileges struct {
+	// Objects names the kind of object as ALTER DEFAULT PRIVILEGES does, e.g. TABLES
+	Objects string
+	// Privileges are those granted, ALL for every privilege, or none at all when empty
+	Privileges []string
+}
+
+// allPrivileges grants every privilege on a kind of object, the default for each
+const allPrivileges string = "ALL"
+
+// parseObjectPrivileges parses the privileges granted on objects, which are a comma separated list, e.g.
+// "SELECT,INSERT", ALL (the default) or NONE
+func parseObjectPrivileges(objects string, allowed []string, value string) ([]string, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	switch value {
+	case "", allPrivileges:
+		return []string{allPrivileges}, nil
+	case "NONE":
+		return []string{}, nil
+	}
+
+	var privileges []string
+	for _, privilege := range strings.Split(value, ",") {
+		privilege = strings.TrimSpace(privilege)
+		if !slices.Contains(allowed, privilege) {
+			return nil, fmt.Errorf("unsupported privilege '%s' on %s, expected ALL, NONE or any of %s", privilege, strings.ToLower(objects), strings.Join(allowed, ", "))
+		}
+		privileges = append(privileges, privilege)
+	}
+	return privileges, nil
+}
+
+// objectPrivileges returns the configured privileges for each kind of grantable object, which default to ALL
+func objectPrivileges(config *Config) []ObjectPrivileges {
+	configured := map[string][]string{}
+	for _, objectPrivileges := range config.ObjectPrivileges {
+		configured[objectPrivileges.Objects] = objectPrivileges.Privileges
+	}
+
+	all := make([]ObjectPrivileges, 0, len(grantableObjects))
+	for _, grantable := range grantableObjects {
+		privileges, ok := configured[grantable.objects]
+		if !ok {
+			privileges = []string{allPrivileges}
+		}
+		all = append(all, ObjectPrivileges{Objects: grantable.objects, Privileges: privileges})
+	}
+	return all
+}
+
+// defaultPrivilegeStatements returns the statements which make the default privileges of grantee, on the objects
+// forRole creates in the public schema (those the connected user creates when forRole is empty), exactly those
+// configured, revoking any others
+func defaultPrivilegeStatements(config *Config, forRole string, grantee string) []string {
+	forClause := ""
+	if len(forRole) > 0 {
+		forClause = fmt.Sprintf("FOR ROLE %s ", forRole)
+	}
+
+	var statements []string
+	for _, objectPrivileges := range objectPrivileges(config) {
+		statements = append(statements, fmt.Sprintf("ALTER DEFAULT PRIVILEGES %sIN SCHEMA public REVOKE ALL PRIVILEGES ON %s FROM %s;", forClause, objectPrivileges.Objects, grantee))
+		if len(objectPrivileges.Privileges) > 0 {
+			statements = append(statements, fmt.Sprintf("ALTER DEFAULT PRIVILEGES %sIN SCHEMA public GRANT %s ON %s TO %s;", forClause, strings.Join(objectPrivileges.Privileges, ", "), objectPrivileges.Objects, grantee))
+		}
+	}
+	return statements
+}
+
+// grantedDefaultPrivileges returns the default privileges of grantee on the objects owner creates in the public
+// schema (those the connected user creates when owner is empty), by the defaclobjtype of the objects
+func grantedDefaultPrivileges(conn *pgx.Conn, owner string, grantee string) (map[string][]string, error) {
+	rows, err := conn.Query(context.Background(), strings.Join([]string{
+		"SELECT d.defaclobjtype::TEXT, a.privilege_type FROM pg_default_acl d",
+		"JOIN pg_namespace n ON n.oid = d.defaclnamespace",
+		"CROSS JOIN LATERAL aclexplode(d.defaclacl) a",
+		"WHERE n.nspname = 'public'",
+		"AND d.defaclrole = (SELECT oid FROM pg_roles WHERE rolname = COALESCE(NULLIF($1, ''), current_user))",
+		"AND a.grantee = (SELECT oid FROM pg_roles WHERE rolname = $2)",
+	}, " "), owner, grantee)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query default privileges for role '%s': %w", grantee, err)
+	}
+	defer rows.Close()
+
+	granted := map[string][]string{}
+	for rows.Next() {
+		var objType, privilege string
+		err = rows.Scan(&objType, &privilege)
+		if err != nil {
+			return nil, fmt.Errorf("unable to query default privileges for role '%s': %w", grantee, err)
+		}
+		granted[objType] = append(granted[objType], privilege)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("unable to query default privileges for role '%s': %w", grantee, rows.Err())
+	}
+
+	return granted, nil
+}
+
+// privilegesMatch reports whether the privileges granted on a kind of object are exactly those expected.  ALL is
+// satisfied by every known privilege on the objects, which newer servers may extend.
+func privilegesMatch(known []string, expected []string, granted []string) bool {
+	if slices.Equal(expected, []string{allPrivileges}) {
+		for _, privilege := range known {
+			if !slices.Contains(granted, privilege) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(granted) != len(expected) {
+		return false
+	}
+	for _, privilege := range expected {
+		if !slices.Contains(granted, privilege) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasDefaultPrivileges reports whether grantee holds exactly the configured default privileges on each kind of
+// object owner creates in the public schema (the connected user, when owner is empty)
+func hasDefaultPrivileges(conn *pgx.Conn, config *Config, owner string, grantee string) (bool, error) {
+	granted, err := grantedDefaultPrivileges(conn, owner, grantee)
+	if err != nil {
+		return false, err
+	}
+	for i, objectPrivileges := range objectPrivileges(config) {
+		grantable := grantableObjects[i]
+		if !privilegesMatch(grantable.privileges, objectPrivileges.Privileges, granted[grantable.objType]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// hasSchemaUsage reports whether role may use the public schema
+func hasSchemaUsage(conn *pgx.Conn, role string) (bool, error) {
+	var usage bool
+	err := conn.QueryRow(context.Background(), "SELECT has_schema_privilege($1, 'public', 'USAGE')", role).Scan(&usage)
+	if err != nil {
+		return false, fmt.Errorf("unable to query schema privileges for role '%s': %w", role, err)
+	}
+	return usage, nil
+}
+
+// RolePrivileges are the default table privileges granted to an additional role
+type RolePrivileges struct {
+	Role       string
+	Privileges []string
+}
+
+// parseRolePrivileges parses a comma separated list of roles, each optionally followed by '=' and a '+'
+// separated list of table privileges, e.g. "readonly=SELECT,api=SELECT+INSERT+UPDATE+DELETE".  roles without
+// an explicit privilege list receive SELECT.
+func parseRolePrivileges(value string) ([]RolePrivileges, error) {
+	var rolePrivileges []RolePrivileges
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		role, privilegeList, found := strings.Cut(entry, "=")
+		role = strings.TrimSpace(role)
+		if len(role) == 0 {
+			return nil, fmt.Errorf("role name missing from privilege entry '%s'", entry)
+		}
+
+		privileges := []string{"SELECT"}
+		if found {
+			privileges = nil
+			for _, privilege := range strings.Split(privilegeList, "+") {
+				privilege = strings.ToUpper(strings.TrimSpace(privilege))
+				if !slices.Contains(tablePrivileges, privilege) {
+					return nil, fmt.Errorf("unsupported privilege '%s' for role '%s'", privilege, role)
+				}
+				privileges = append(privileges, privilege)
+			}
+		}
+
+		rolePrivileges = append(rolePrivileges, RolePrivileges{
+			Role:       role,
+			Privileges: privileges,
+		})
+	}
+
+	return rolePrivileges, nil
+}
+
+// ensureRoleExists creates role when it does not exist, provided missing roles may be created
+func ensureRoleExists(conn *pgx.Conn, config *Config, role string) error {
+	var exists bool
+	row := conn.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $1)", role)
+	err := row.Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("unable to query database for existing role by name: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	if !config.CreateMissingRoles {
+		return fmt.Errorf("role '%s' does not exist, create it or set EVO_CREATE_MISSING_ROLES=1", role)
+	}
+
+	logf("creating role %s\n", role)
+	_, err = conn.Exec(context.Background(), fmt.Sprintf("CREATE ROLE %s", pgx.Identifier{role}.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("unable to create role '%s': %w", role, err)
+	}
+
+	return nil
+}
+
+// ensureRolePrivileges sets default privileges on tables created by the migration user in the public schema,
+// for each of the configured additional roles, skipping the roles whose privileges are already in place (unless
+// configured to always regrant).  returns true if the grants were issued for any of the roles.
+func ensureRolePrivileges(conn *pgx.Conn, config *Config) (bool, error) {
+	issued := false
+	for _, rolePrivileges := range config.DefaultPrivilegeRoles {
+		err := ensureRoleExists(conn, config, rolePrivileges.Role)
+		if err != nil {
+			return issued, err
+		}
+
+		if !config.RegrantAlways {
+			granted, err := hasRolePrivileges(conn, config, rolePrivileges)
+			if err != nil {
+				return issued, err
+			}
+			if granted {
+				logf("privileges for role %s already in place\n", rolePrivileges.Role)
+				continue
+			}
+		}
+
+		role := pgx.Identifier{rolePrivileges.Role}.Sanitize()
+		logf("ensuring default privileges %s for role %s\n", strings.Join(rolePrivileges.Privileges, ", "), rolePrivileges.Role)
+		_, err = conn.Exec(context.Background(), fmt.Sprintf("ALTER DEFAULT PRIVILEGES FOR ROLE %s IN SCHEMA public GRANT %s ON TABLES TO %s",
+			pgx.Identifier{config.Username}.Sanitize(), strings.Join(rolePrivileges.Privileges, ", "), role))
+		if err != nil {
+			return issued, fmt.Errorf("unable to extend default privileges to role '%s': %w", rolePrivileges.Role, err)
+		}
+
+		// usage on the schema is required for the role to reach any of the tables within it
+		_, err = conn.Exec(context.Background(), fmt.Sprintf("GRANT USAGE ON SCHEMA public TO %s", role))
+		if err != nil {
+			return issued, fmt.Errorf("unable to grant schema usage to role '%s': %w", rolePrivileges.Role, err)
+		}
+		issued = true
+	}
+
+	return issued, nil
+}
+
+// hasRolePrivileges reports whether the default table privileges and schema usage issued by ensureRolePrivileges
+// are already in place for an additional role.  the privileges are only ever added to, so any others the role
+// holds are ignored.
+func hasRolePrivileges(conn *pgx.Conn, config *Config, rolePrivileges RolePrivileges) (bool, error) {
+	granted, err := grantedDefaultPrivileges(conn, config.Username, rolePrivileges.Role)
+	if err != nil {
+		return false, err
+	}
+	for _, privilege := range rolePrivileges.Privileges {
+		if !slices.Contains(granted["r"], privilege) {
+			return false, nil
+		}
+	}
+	return hasSchemaUsage(conn, rolePrivileges.Role)
+}
+
+// ensureGrantRole prepares the group role through which access is granted, EVO_GRANT_ROLE: it is created if
+// missing (when allowed), the user is made a member of it, and it is given the configured privileges (all, by
+// default) on the objects the user creates in the public schema, so that the other members of the role share
+// access to them.  the default privileges on objects created by the admin user are issued by ensureUserPrivileges.
+// the default privileges are skipped when already in place (unless configured to always regrant).  returns true if
+// they were issued.
+func ensureGrantRole(conn *pgx.Conn, config *Config) (bool, error) {
+	if len(config.GrantRole) == 0 {
+		return false, nil
+	}
+
+	err := ensureRoleExists(conn, config, config.GrantRole)
+	if err != nil {
+		return false, err
+	}
+
+	var member bool
+	err = conn.QueryRow(context.Background(), "SELECT pg_has_role($1, $2, 'MEMBER')", config.Username, config.GrantRole).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("unable to query membership of role '%s': %w", config.GrantRole, err)
+	}
+	role := pgx.Identifier{config.GrantRole}.Sanitize()
+	if !member {
+		logf("granting membership of role '%s' to user '%s'\n", config.GrantRole, config.Username)
+		_, err = conn.Exec(context.Background(), fmt.Sprintf("GRANT %s TO %s", role, pgx.Identifier{config.Username}.Sanitize()))
+		if err != nil {
+			return false, fmt.Errorf("unable to grant membership of role '%s' to user '%s': %w", config.GrantRole, config.Username, err)
+		}
+	}
+
+	if !config.RegrantAlways {
+		granted, err := hasGrantRolePrivileges(conn, config)
+		if err != nil {
+			return false, err
+		}
+		if granted {
+			logf("privileges for role %s already in place\n", config.GrantRole)
+			return false, nil
+		}
+	}
+
+	logf("ensuring default privileges for role %s\n", config.GrantRole)
+	statements := defaultPrivilegeStatements(config, pgx.Identifier{config.Username}.Sanitize(), role)
+	statements = append(statements, fmt.Sprintf("GRANT USAGE ON SCHEMA public TO %s;", role))
+	_, err = conn.Exec(context.Background(), strings.Join(statements, " "))
+	if err != nil {
+		return false, fmt.Errorf("unable to extend default privileges to role '%s': %w", config.GrantRole, err)
+	}
+
+	return true, nil
+}
+
+// hasGrantRolePrivileges reports whether the default privileges and schema usage issued by ensureGrantRole are
+// already in place for the grant role
+func hasGrantRolePrivileges(conn *pgx.Conn, config *Config) (bool, error) {
+	granted, err := hasDefaultPrivileges(conn, config, config.Username, config.GrantRole)
+	if err != nil || !granted {
+		return false, err
+	}
+	return hasSchemaUsage(conn, config.GrantRole)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseRolePrivileges(t *testing.T) {
+	rolePrivileges, err := parseRolePrivileges("readonly, api=select+insert+update+delete")
+	assert.NoError(t, err)
+	assert.Equal(t, []RolePrivileges{
+		{Role: "readonly", Privileges: []string{"SELECT"}},
+		{Role: "api", Privileges: []string{"SELECT", "INSERT", "UPDATE", "DELETE"}},
+	}, rolePrivileges)
+
+	_, err = parseRolePrivileges("api=SELECT+EVERYTHING")
+	assert.Error(t, err)
+}
+
+func TestParseObjectPrivileges(t *testing.T) {
+	privileges, err := parseObjectPrivileges("TABLES", tablePrivileges, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ALL"}, privileges)
+
+	privileges, err = parseObjectPrivileges("TABLES", tablePrivileges, "select, insert")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SELECT", "INSERT"}, privileges)
+
+	privileges, err = parseObjectPrivileges("FUNCTIONS", []string{"EXECUTE"}, "none")
+	assert.NoError(t, err)
+	assert.Empty(t, privileges)
+
+	_, err = parseObjectPrivileges("FUNCTIONS", []string{"EXECUTE"}, "SELECT")
+	assert.Error(t, err)
+}
+
+func TestObjectPrivileges(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.ObjectPrivileges = []ObjectPrivileges{
+		{Objects: "TABLES", Privileges: []string{"SELECT", "INSERT"}},
+		{Objects: "FUNCTIONS", Privileges: []string{}},
+	}
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+
+	_, err = adminConn.Exec(context.Background(), "CREATE TABLE later_table (id INT)")
+	assert.NoError(t, err)
+	var canSelect, canDelete bool
+	err = adminConn.QueryRow(context.Background(), "SELECT has_table_privilege($1, 'public.later_table', 'SELECT'), has_table_privilege($1, 'public.later_table', 'DELETE')", config.Username).Scan(&canSelect, &canDelete)
+	assert.NoError(t, err)
+	assert.True(t, canSelect)
+	assert.False(t, canDelete)
+
+	// functions are left out of the user's default privileges, sequences keep all of theirs
+	var functionGrants, sequenceGrants int
+	err = adminConn.QueryRow(context.Background(), `SELECT
+			count(*) FILTER (WHERE d.defaclobjtype = 'f'),
+			count(*) FILTER (WHERE d.defaclobjtype = 'S')
+		FROM pg_default_acl d CROSS JOIN LATERAL aclexplode(d.defaclacl) a
+		WHERE d.defaclrole = (SELECT oid FROM pg_roles WHERE rolname = current_user)
+		AND a.grantee = (SELECT oid FROM pg_roles WHERE rolname = $1)`, config.Username).Scan(&functionGrants, &sequenceGrants)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, functionGrants)
+	assert.Equal(t, 3, sequenceGrants)
+
+	granted, err := hasUserPrivileges(adminConn, config)
+	assert.NoError(t, err)
+	assert.True(t, granted)
+
+	// granting everything again is seen as a change
+	config.ObjectPrivileges = nil
+	granted, err = hasUserPrivileges(adminConn, config)
+	assert.NoError(t, err)
+	assert.False(t, granted)
+}
+
+func TestDefaultPrivilegeRoles(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.DefaultPrivilegeRoles = []RolePrivileges{{Role: "readonly", Privileges: []string{"SELECT"}}}
+	err = doMigration(config, nil)
+	assert.Error(t, err, "missing role must not be created implicitly")
+
+	config.CreateMissingRoles = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	userConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = userConn.Close(context.Background())
+	}()
+
+	_, err = userConn.Exec(context.Background(), "CREATE TABLE later_table (id INT)")
+	assert.NoError(t, err)
+
+	var canSelect, canInsert bool
+	err = userConn.QueryRow(context.Background(), "SELECT has_table_privilege('readonly', 'public.later_table', 'SELECT'), has_table_privilege('readonly', 'public.later_table', 'INSERT')").Scan(&canSelect, &canInsert)
+	assert.NoError(t, err)
+	assert.True(t, canSelect)
+	assert.False(t, canInsert)
+}
+
+func TestDefaultPrivilegeRolesNotRegranted(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.DefaultPrivilegeRoles = []RolePrivileges{{Role: "readonly", Privileges: []string{"SELECT"}}}
+	config.CreateMissingRoles = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+
+	granted, err := hasRolePrivileges(adminConn, config, config.DefaultPrivilegeRoles[0])
+	assert.NoError(t, err)
+	assert.True(t, granted)
+
+	// privileges are already in place, so a second pass must not re-issue the grants
+	regranted, err := ensureRolePrivileges(adminConn, config)
+	assert.NoError(t, err)
+	assert.False(t, regranted)
+
+	config.RegrantAlways = true
+	regranted, err = ensureRolePrivileges(adminConn, config)
+	assert.NoError(t, err)
+	assert.True(t, regranted)
+
+	// a privilege newly configured for the role is missing until granted
+	config.RegrantAlways = false
+	config.DefaultPrivilegeRoles[0].Privileges = []string{"SELECT", "INSERT"}
+	granted, err = hasRolePrivileges(adminConn, config, config.DefaultPrivilegeRoles[0])
+	assert.NoError(t, err)
+	assert.False(t, granted)
+
+	regranted, err = ensureRolePrivileges(adminConn, config)
+	assert.NoError(t, err)
+	assert.True(t, regranted)
+}
+
+func TestGrantRole(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.GrantRole = "app_access"
+	config.GrantRoleOnly = true
+	config.CreateMissingRoles = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+
+	var member bool
+	err = adminConn.QueryRow(context.Background(), "SELECT pg_has_role($1, 'app_access', 'MEMBER')", config.Username).Scan(&member)
+	assert.NoError(t, err)
+	assert.True(t, member)
+
+	// the default privileges go to the group alone
+	var userGrants, roleGrants int
+	err = adminConn.QueryRow(context.Background(), `SELECT
+			count(*) FILTER (WHERE a.grantee = (SELECT oid FROM pg_roles WHERE rolname = $1)),
+			count(*) FILTER (WHERE a.grantee = (SELECT oid FROM pg_roles WHERE rolname = 'app_access'))
+		FROM pg_default_acl d CROSS JOIN LATERAL aclexplode(d.defaclacl) a
+		WHERE d.defaclrole = (SELECT oid FROM pg_roles WHERE rolname = current_user)`, config.Username).Scan(&userGrants, &roleGrants)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, userGrants)
+	assert.Positive(t, roleGrants)
+
+	// a table created later by the admin user is reachable by the user through the group
+	_, err = adminConn.Exec(context.Background(), "CREATE TABLE later_table (id INT)")
+	assert.NoError(t, err)
+
+	userConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = userConn.Close(context.Background())
+	}()
+	_, err = userConn.Exec(context.Background(), "INSERT INTO later_table VALUES (1)")
+	assert.NoError(t, err)
+
+	var direct, viaGroup bool
+	err = adminConn.QueryRow(context.Background(), "SELECT has_table_privilege('app_access', 'public.later_table', 'SELECT'), EXISTS (SELECT 1 FROM aclexplode((SELECT relacl FROM pg_class WHERE oid = 'public.later_table'::regclass)) a WHERE a.grantee = (SELECT oid FROM pg_roles WHERE rolname = $1))", config.Username).Scan(&viaGroup, &direct)
+	assert.NoError(t, err)
+	assert.True(t, viaGroup)
+	assert.False(t, direct)
+
+	// a second run finds the privileges in place
+	granted, err := hasUserPrivileges(adminConn, config, config.GrantRole)
+	assert.NoError(t, err)
+	assert.True(t, granted)
+}
+
+func TestGrantRolePrivilegesNotRegranted(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.GrantRole = "app_access"
+	config.CreateMissingRoles = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+
+	granted, err := hasGrantRolePrivileges(adminConn, config)
+	assert.NoError(t, err)
+	assert.True(t, granted)
+
+	// privileges are already in place, so a second pass must not re-issue the grants
+	regranted, err := ensureGrantRole(adminConn, config)
+	assert.NoError(t, err)
+	assert.False(t, regranted)
+
+	config.RegrantAlways = true
+	regranted, err = ensureGrantRole(adminConn, config)
+	assert.NoError(t, err)
+	assert.True(t, regranted)
+
+	// a narrower configuration no longer matches what is in place
+	config.RegrantAlways = false
+	config.ObjectPrivileges = []ObjectPrivileges{{Objects: "TABLES", Privileges: []string{"SELECT"}}}
+	granted, err = hasGrantRolePrivileges(adminConn, config)
+	assert.NoError(t, err)
+	assert.False(t, granted)
+}
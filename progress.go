@@ -0,0 +1,54 @@
+package main
+
+import "time"
+
+// ProgressStage names a transition of a run reported to Config.Progress
+type ProgressStage string
+
+const (
+	// ProgressConnected is reported once the user's connection to the database is established
+	ProgressConnected ProgressStage = "connected"
+	// ProgressLockAcquired is reported once the lock serializing runners against the database is held
+	ProgressLockAcquired ProgressStage = "lock_acquired"
+	// ProgressMigratorStarted is reported before each migrator to be applied is executed
+	ProgressMigratorStarted ProgressStage = "migrator_started"
+	// ProgressMigratorFinished is reported once each migrator is applied and recorded
+	ProgressMigratorFinished ProgressStage = "migrator_finished"
+	// ProgressMigratorPaused is reported in place of ProgressMigratorFinished by a backfill which stopped at its
+	// maximum number of chunks, the run ending with it
+	ProgressMigratorPaused ProgressStage = "migrator_paused"
+	// ProgressRunComplete is reported last, whether or not the run succeeded
+	ProgressRunComplete ProgressStage = "run_complete"
+)
+
+// ProgressEvent describes a transition of a run against a database
+type ProgressEvent struct {
+	Stage    ProgressStage
+	Database string
+	// Migrator, Index and Total are set for migrator events.  Index counts from 1 among the Total migrators to be
+	// applied by the run, which excludes those already applied or skipped.
+	Migrator string
+	Index    int
+	Total    int
+	// Duration is the time taken by a finished migrator, or by the whole run
+	Duration time.Duration
+	// Applied is the number of migrators applied by a complete run, Err the reason it failed
+	Applied int
+	Err     error
+	// PasswordReset is set on a complete run which reset the password of the user, so that callers may alert on
+	// resets they did not expect
+	PasswordReset bool
+}
+
+// ProgressFunc receives the progress of runs, e.g. to drive a progress bar.  when several databases are migrated
+// at once it is called concurrently, the events of each carrying its Database.
+type ProgressFunc func(event ProgressEvent)
+
+// reportProgress passes the event, for the configured database, to the configured callback, if any
+func reportProgress(config *Config, event ProgressEvent) {
+	if config.Progress == nil {
+		return
+	}
+	event.Database = config.Database
+	config.Progress(event)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestProgress(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	var mutex sync.Mutex
+	var events []ProgressEvent
+	config.Progress = func(event ProgressEvent) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// migrators already applied are not counted by the next run
+	events = nil
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_add_name.sql":  "ALTER TABLE things ADD COLUMN name TEXT;",
+		"0003_add_email.sql": "ALTER TABLE things ADD COLUMN email TEXT;",
+		"0004_add_age.sql":   "ALTER TABLE things ADD COLUMN age INT;",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	type step struct {
+		stage    ProgressStage
+		migrator string
+		index    int
+		total    int
+	}
+	var steps []step
+	for _, event := range events {
+		assert.Equal(t, config.Database, event.Database)
+		steps = append(steps, step{event.Stage, event.Migrator, event.Index, event.Total})
+	}
+	assert.Equal(t, []step{
+		{ProgressLockAcquired, "", 0, 0},
+		{ProgressConnected, "", 0, 0},
+		{ProgressMigratorStarted, "0002_add_name.sql", 1, 3},
+		{ProgressMigratorFinished, "0002_add_name.sql", 1, 3},
+		{ProgressMigratorStarted, "0003_add_email.sql", 2, 3},
+		{ProgressMigratorFinished, "0003_add_email.sql", 2, 3},
+		{ProgressMigratorStarted, "0004_add_age.sql", 3, 3},
+		{ProgressMigratorFinished, "0004_add_age.sql", 3, 3},
+		{ProgressRunComplete, "", 0, 0},
+	}, steps)
+
+	complete := events[len(events)-1]
+	assert.Equal(t, 3, complete.Applied)
+	assert.NoError(t, complete.Err)
+
+	// a failed run completes with its error
+	events = nil
+	writeMigrators(t, config.Directory, map[string]string{
+		"0005_broken.sql": "ALTER TABLE missing ADD COLUMN age INT;",
+	})
+	err = doMigration(config, nil)
+	assert.Error(t, err)
+	complete = events[len(events)-1]
+	assert.Equal(t, ProgressRunComplete, complete.Stage)
+	assert.Equal(t, 0, complete.Applied)
+	assert.ErrorIs(t, complete.Err, err)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// readyPollInterval is the delay between checks while waiting for a database in recovery
+var readyPollInterval = time.Second
+
+type Queryable interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// checkReady probes the server with a trivial query, and when a primary is required, ensures it is not in
+// recovery (i.e. a replica or a primary still replaying wal).  a server in recovery is polled until the
+// configured timeout elapses.
+func checkReady(conn Queryable, config *Config) error {
+	var one int
+	err := conn.QueryRow(context.Background(), "SELECT 1").Scan(&one)
+	if err != nil {
+		return fmt.Errorf("database readiness probe failed: %w", err)
+	}
+
+	if !config.RequirePrimary {
+		return nil
+	}
+
+	deadline := time.Now().Add(config.ReadyTimeout)
+	for {
+		var inRecovery bool
+		err = conn.QueryRow(context.Background(), "SELECT pg_is_in_recovery()").Scan(&inRecovery)
+		if err != nil {
+			return fmt.Errorf("unable to determine whether the database is in recovery: %w", err)
+		}
+
+		if !inRecovery {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("database at '%s' is in recovery (a replica or a recovering primary), migrations require a primary", config.Hostname)
+		}
+
+		logf("database is in recovery, waiting\n")
+		time.Sleep(readyPollInterval)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type boolRow struct {
+	value bool
+}
+
+func (r boolRow) Scan(dest ...any) error {
+	switch d := dest[0].(type) {
+	case *bool:
+		*d = r.value
+	case *int:
+		*d = 1
+	}
+	return nil
+}
+
+// recoveringConn simulates a server which reports being in recovery for a number of checks
+type recoveringConn struct {
+	recoveryChecks int
+}
+
+func (c *recoveringConn) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	if sql == "SELECT pg_is_in_recovery()" {
+		c.recoveryChecks--
+		return boolRow{value: c.recoveryChecks >= 0}
+	}
+	return boolRow{}
+}
+
+func TestCheckReadyInRecovery(t *testing.T) {
+	readyPollInterval = time.Millisecond
+
+	// a database in recovery is acceptable when a primary is not required
+	err := checkReady(&recoveringConn{recoveryChecks: 10}, &Config{})
+	assert.NoError(t, err)
+
+	err = checkReady(&recoveringConn{recoveryChecks: 10}, &Config{Hostname: "replica:5432", RequirePrimary: true})
+	assert.ErrorContains(t, err, "in recovery")
+
+	// promotion within the timeout is waited for
+	err = checkReady(&recoveringConn{recoveryChecks: 2}, &Config{RequirePrimary: true, ReadyTimeout: time.Minute})
+	assert.NoError(t, err)
+}
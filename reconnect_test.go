@@ -0,0 +1,74 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestReconnectBetweenMigrators(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+
+	// the second migrator includes a named pipe, so that its rendering blocks once the first migrator has been
+	// applied, until the pipe is written
+	config.Directory = t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(config.Directory, "snippets"), 0o755))
+	pipe := filepath.Join(config.Directory, "snippets", "second.txt")
+	assert.NoError(t, syscall.Mkfifo(pipe, 0o644))
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first_notrans.sql":  "CREATE TABLE first (id INT);",
+		"0002_second_notrans.sql": `{{ include "snippets/second.txt" }}`,
+	})
+
+	dropped := make(chan error, 1)
+	go func() {
+		// opening the pipe blocks until the second migrator is being rendered
+		file, err := os.OpenFile(pipe, os.O_WRONLY, 0)
+		if err != nil {
+			dropped <- err
+			return
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+
+		_, err = adminConn.Exec(context.Background(), "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE usename = $1", config.Username)
+		if err != nil {
+			dropped <- err
+			return
+		}
+		_, err = file.WriteString("CREATE TABLE second (id INT);")
+		dropped <- err
+	}()
+
+	err = doMigration(config, nil)
+	if assert.NoError(t, err) {
+		assert.NoError(t, <-dropped)
+	}
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Contains(t, pastMigrations, "0001_first_notrans.sql")
+	assert.Contains(t, pastMigrations, "0002_second_notrans.sql")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// remoteIndexName is the index fetched when a remote source names a prefix, ending with '/', rather than the index
+const remoteIndexName = "SHA256SUMS"
+
+// remoteTimeout bounds each request made to fetch a remote source
+var remoteTimeout = 60 * time.Second
+
+// isRemote reports whether source names migrators to be fetched over http(s) or from s3, rather than a local
+// directory or archive
+func isRemote(source string) bool {
+	lower := strings.ToLower(source)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "s3://")
+}
+
+// remoteIndexURL resolves the url of the index of a remote source.  an s3 url is addressed through endpoint when
+// it is set (path style, e.g. a minio server), or the virtual hosted endpoint of the bucket otherwise.
+func remoteIndexURL(source string, endpoint string) (*url.URL, error) {
+	indexURL, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid migrator source '%s': %w", source, err)
+	}
+
+	if strings.EqualFold(indexURL.Scheme, "s3") {
+		bucket, key := indexURL.Host, strings.TrimPrefix(indexURL.Path, "/")
+		if len(bucket) == 0 {
+			return nil, fmt.Errorf("migrator source '%s' names no bucket", source)
+		}
+		if len(endpoint) > 0 {
+			indexURL, err = url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid s3 endpoint '%s': %w", endpoint, err)
+			}
+		} else {
+			indexURL = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, s3Region()), Path: "/" + key}
+		}
+	}
+
+	if strings.HasSuffix(indexURL.Path, "/") || len(indexURL.Path) == 0 {
+		indexURL.Path = strings.TrimSuffix(indexURL.Path, "/") + "/" + remoteIndexName
+	}
+	return indexURL, nil
+}
+
+// parseRemoteIndex parses an index in the format written by sha256sum, a checksum and a file name per line, e.g.
+// "<sha256>  0001_make_table.sql".  names may hold subdirectories, but may not leave the source.
+func parseRemoteIndex(index []byte) (map[string]string, error) {
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(index))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("index line '%s' is not a checksum followed by a file name", line)
+		}
+		sum := strings.ToLower(fields[0])
+		// sha256sum marks files checksummed in binary mode with a leading '*'
+		name := strings.TrimPrefix(fields[1], "*")
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("checksum of '%s' in the index is not a sha256", name)
+		}
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("file name '%s' in the index is not a relative path within the source", name)
+		}
+		checksums[name] = sum
+	}
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+
+	return checksums, nil
+}
+
+// openRemote fetches the index of a remote source and every file it lists, relative to it, into memory,
+// verifying each against its checksum in the index
+func openRemote(source string, s3Endpoint string) (fs.FS, error) {
+	indexURL, err := remoteIndexURL(source, s3Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	signed := strings.EqualFold(strings.SplitN(source, ":", 2)[0], "s3")
+
+	logf("fetching migrator index %s\n", indexURL.Redacted())
+	index, err := fetchRemote(indexURL, signed)
+	if err != nil {
+		return nil, err
+	}
+	checksums, err := parseRemoteIndex(index)
+	if err != nil {
+		return nil, fmt.Errorf("invalid migrator index %s: %w", indexURL.Redacted(), err)
+	}
+	if len(checksums) == 0 {
+		return nil, fmt.Errorf("migrator index %s lists no files", indexURL.Redacted())
+	}
+
+	files := map[string][]byte{}
+	for name, sum := range checksums {
+		fileURL := *indexURL
+		fileURL.Path = path.Join(path.Dir(indexURL.Path), name)
+		fileURL.RawPath = ""
+		data, err := fetchRemote(&fileURL, signed)
+		if err != nil {
+			return nil, err
+		}
+
+		actual := sha256.Sum256(data)
+		if hex.EncodeToString(actual[:]) != sum {
+			return nil, fmt.Errorf("checksum of '%s' does not match the migrator index, expected %s but fetched %s", name, sum, hex.EncodeToString(actual[:]))
+		}
+		files[name] = data
+	}
+
+	return memoryFS(files)
+}
+
+// fetchRemote returns the body of a GET of u, signed with the AWS credentials of the environment, if any, when
+// signed is set
+func fetchRemote(u *url.URL, signed bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if signed {
+		signS3Request(request, time.Now().UTC())
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch %s: %w", u.Redacted(), err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch %s: %s", u.Redacted(), response.Status)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch %s: %w", u.Redacted(), err)
+	}
+	return body, nil
+}
+
+// s3Region returns the region of the AWS environment, defaulting to us-east-1
+func s3Region() string {
+	for _, variable := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(variable); len(region) > 0 {
+			return region
+		}
+	}
+	return "us-east-1"
+}
+
+// signS3Request signs a GET request with AWS signature version 4, using the credentials of AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and, for temporary credentials, AWS_SESSION_TOKEN.  without credentials the request is
+// left unsigned, which suits publicly readable buckets.
+func signS3Request(request *http.Request, now time.Time) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if len(accessKey) == 0 || len(secretKey) == 0 {
+		return
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	region := s3Region()
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:UNSIGNED-PAYLOAD\nx-amz-date:%s\n", request.URL.Host, amzDate)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); len(token) > 0 {
+		request.Header.Set("x-amz-security-token", token)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += fmt.Sprintf("x-amz-security-token:%s\n", token)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hashedRequest[:])}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
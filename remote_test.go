@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// serveMigrators serves files beneath /bundle/, along with their SHA256SUMS index, recording the authorization
+// header of each request
+func serveMigrators(t *testing.T, files map[string]string, tampered string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var index strings.Builder
+	for name, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		fmt.Fprintf(&index, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		name := strings.TrimPrefix(r.URL.Path, "/bundle/")
+		if name == remoteIndexName {
+			_, _ = w.Write([]byte(index.String()))
+			return
+		}
+		content, ok := files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if name == tampered {
+			content += "\nDROP TABLE accounts;"
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+	return server, &authorizations
+}
+
+func TestParseRemoteIndex(t *testing.T) {
+	sum := strings.Repeat("ab", sha256.Size)
+	checksums, err := parseRemoteIndex([]byte(sum + "  0001_make_table.sql\n# comment\n" + sum + " *staging/0002_seed.sql\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"0001_make_table.sql": sum, "staging/0002_seed.sql": sum}, checksums)
+
+	_, err = parseRemoteIndex([]byte(sum + "  ../0001_make_table.sql\n"))
+	assert.Error(t, err)
+	_, err = parseRemoteIndex([]byte("abc  0001_make_table.sql\n"))
+	assert.Error(t, err)
+}
+
+func TestOpenRemote(t *testing.T) {
+	files := map[string]string{
+		"0001_make_table.sql":   "CREATE TABLE accounts (id INT);",
+		"0002_add_column.sql":   "ALTER TABLE accounts ADD COLUMN name TEXT;",
+		"staging/0003_seed.sql": "INSERT INTO accounts VALUES (1, 'demo');",
+	}
+	server, _ := serveMigrators(t, files, "")
+
+	// a prefix names the index within it
+	fsys, err := openRemote(server.URL+"/bundle/", "")
+	assert.NoError(t, err)
+	content, err := fs.ReadFile(fsys, "staging/0003_seed.sql")
+	assert.NoError(t, err)
+	assert.Equal(t, files["staging/0003_seed.sql"], string(content))
+
+	config := &Config{Directory: server.URL + "/bundle/SHA256SUMS", FS: fsys, Env: "staging"}
+	matches, err := findMigrators(config)
+	assert.NoError(t, err)
+	var names []string
+	for _, match := range matches {
+		names = append(names, migratorName(config, match))
+	}
+	assert.Equal(t, []string{"0001_make_table.sql", "0002_add_column.sql", "staging/0003_seed.sql"}, names)
+
+	// a file which does not match its checksum fails the whole fetch
+	tamperedServer, _ := serveMigrators(t, files, "0002_add_column.sql")
+	_, err = openRemote(tamperedServer.URL+"/bundle/SHA256SUMS", "")
+	assert.ErrorContains(t, err, "checksum of '0002_add_column.sql' does not match")
+}
+
+func TestOpenRemoteS3(t *testing.T) {
+	server, authorizations := serveMigrators(t, map[string]string{"0001_make_table.sql": "CREATE TABLE accounts (id INT);"}, "")
+
+	// the bucket is addressed path style through the endpoint, with signed requests
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	fsys, err := openRemote("s3://bundle/", server.URL)
+	assert.NoError(t, err)
+	_, err = fs.Stat(fsys, "0001_make_table.sql")
+	assert.NoError(t, err)
+	assert.Len(t, *authorizations, 2)
+	for _, authorization := range *authorizations {
+		assert.Contains(t, authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/")
+		assert.Contains(t, authorization, "/eu-west-1/s3/aws4_request")
+	}
+
+	indexURL, err := remoteIndexURL("s3://bundle/releases/42/", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://bundle.s3.eu-west-1.amazonaws.com/releases/42/SHA256SUMS", indexURL.String())
+}
+
+func TestRemoteMigration(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	server, _ := serveMigrators(t, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE accounts (id INT);",
+		"0002_add_column.sql": "ALTER TABLE accounts ADD COLUMN name TEXT;",
+	}, "")
+	config.Directory = server.URL + "/bundle/SHA256SUMS"
+	config.FS, err = openRemote(config.Directory, "")
+	assert.NoError(t, err)
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"0001_make_table.sql": {}, "0002_add_column.sql": {}}, pastMigrations)
+}
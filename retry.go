@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// retryBackoff is the delay before the first retry, it grows linearly with each subsequent attempt
+var retryBackoff = 250 * time.Millisecond
+
+// isRetriable reports whether err is a transient serialization failure or deadlock, after which the same
+// transaction may succeed if attempted again
+func isRetriable(err error) bool {
+	return hasCode(err, "40001", "40P01")
+}
+
+// withRetries calls fn, calling it again up to config.SerializationRetries times while it fails with a
+// retriable error
+func withRetries(config *Config, migName string, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || !isRetriable(err) || attempt >= config.SerializationRetries {
+			return err
+		}
+
+		logf("migrator '%s' failed with a retriable error, retrying (%d of %d): %s\n", migName, attempt+1, config.SerializationRetries, err.Error())
+		time.Sleep(retryBackoff * time.Duration(attempt+1))
+	}
+}
+
+// roleSetupAttempts bounds the attempts at setting up the user while runs for other databases change it concurrently
+const roleSetupAttempts = 5
+
+// isConcurrentRoleUpdate reports whether err is the failure of a statement on a role, which is cluster wide, because
+// another session changed the role at the same time: a duplicate key in the catalog or a tuple concurrently updated
+func isConcurrentRoleUpdate(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "23505" || (pgErr.Code == "XX000" && strings.Contains(pgErr.Message, "tuple concurrently updated"))
+}
+
+// withRoleRetries calls fn, calling it again while it fails because a run for another database, creating or
+// migrating its own, set up the same user at the same time.  fn must be safe to repeat.
+func withRoleRetries(fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !isConcurrentRoleUpdate(err) || attempt >= roleSetupAttempts {
+			return err
+		}
+
+		logf("the user was changed concurrently by another run, retrying (%d of %d): %s\n", attempt, roleSetupAttempts-1, err.Error())
+		time.Sleep(retryBackoff * time.Duration(attempt))
+	}
+}
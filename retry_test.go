@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+const injectSerializationFailure string = `DO $$
+BEGIN
+	IF nextval('attempts') = 1 THEN
+		RAISE EXCEPTION 'injected' USING ERRCODE = 'serialization_failure';
+	END IF;
+END $$;
+CREATE TABLE retried (id INT);`
+
+func TestSerializationRetry(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	retryBackoff = time.Millisecond
+	defer func() {
+		retryBackoff = 250 * time.Millisecond
+	}()
+
+	// sequences are not transactional, so the attempt count survives the rollback of the failed attempt
+	config.Directory = t.TempDir()
+	config.SerializationRetries = 2
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_sequence.sql": "CREATE SEQUENCE attempts;",
+		"0002_flaky.sql":    injectSerializationFailure,
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	var attempts int
+	err = standardConn.QueryRow(context.Background(), "SELECT last_value FROM attempts").Scan(&attempts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestWithRetries(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() {
+		retryBackoff = 250 * time.Millisecond
+	}()
+
+	config := &Config{SerializationRetries: 3}
+	calls := 0
+	err := withRetries(config, "0001_deadlock.sql", func() error {
+		calls++
+		return fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: "40P01"})
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 4, calls)
+
+	// other errors are not retried
+	calls = 0
+	err = withRetries(config, "0002_syntax.sql", func() error {
+		calls++
+		return &pgconn.PgError{Code: "42601"}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestWithRoleRetries(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() {
+		retryBackoff = 250 * time.Millisecond
+	}()
+
+	// a role changed by another session is set up again, until it succeeds
+	calls := 0
+	err := withRoleRetries(func() error {
+		calls++
+		if calls == 1 {
+			return fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: "XX000", Message: "tuple concurrently updated"})
+		}
+		if calls == 2 {
+			return &pgconn.PgError{Code: "23505"}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// or the attempts run out
+	calls = 0
+	err = withRoleRetries(func() error {
+		calls++
+		return &pgconn.PgError{Code: "23505"}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, roleSetupAttempts, calls)
+
+	// other internal errors are not retried
+	calls = 0
+	err = withRoleRetries(func() error {
+		calls++
+		return &pgconn.PgError{Code: "XX000", Message: "cache lookup failed"}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
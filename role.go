@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// setSessionRole switches a newly established user connection to the configured session role, if any, under which
+// all of its work is done
+func setSessionRole(conn Executable, config *Config) error {
+	if len(config.SessionRole) == 0 {
+		return nil
+	}
+
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("SET ROLE %s", pgx.Identifier{config.SessionRole}.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("unable to switch to session role '%s', user '%s' must be a member of it: %w", config.SessionRole, config.Username, err)
+	}
+	return nil
+}
+
+// restoreRole returns the statement switching back to the session role, or to the user when there is none
+func restoreRole(config *Config) string {
+	if len(config.SessionRole) == 0 {
+		return "RESET ROLE"
+	}
+	return fmt.Sprintf("SET ROLE %s", pgx.Identifier{config.SessionRole}.Sanitize())
+}
+
+// asRole runs fn having switched to role, which the user must be a member of, and switches back afterwards.
+// within a transaction, a failure leaves the switch to be undone by the rollback.
+func asRole(conn Executable, config *Config, role string, fn func() error) error {
+	if len(role) == 0 {
+		return fn()
+	}
+
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("SET ROLE %s", pgx.Identifier{role}.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("unable to switch to role '%s', user '%s' must be a member of it: %w", role, config.Username, err)
+	}
+
+	err = fn()
+	if err != nil {
+		_, _ = conn.Exec(context.Background(), restoreRole(config))
+		return err
+	}
+
+	_, err = conn.Exec(context.Background(), restoreRole(config))
+	if err != nil {
+		return fmt.Errorf("unable to switch back from role '%s': %w", role, err)
+	}
+
+	return nil
+}
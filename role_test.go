@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestMigratorRole(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	_, err = adminConn.Exec(context.Background(), "CREATE ROLE app_owner NOLOGIN; CREATE ROLE stranger NOLOGIN")
+	assert.NoError(t, err)
+	_ = adminConn.Close(context.Background())
+
+	config.UserRoleMembership = []string{"app_owner"}
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_schema.sql":        "CREATE SCHEMA owned; GRANT USAGE, CREATE ON SCHEMA owned TO app_owner;",
+		"0002_owned.sql":         "-- evo: role=app_owner\nCREATE TABLE owned.things (id INT);",
+		"0003_owned_notrans.sql": "-- evo: role=app_owner\nCREATE INDEX CONCURRENTLY ix_things ON owned.things (id);",
+		"0004_after.sql":         "CREATE TABLE owned.others (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	// objects are owned by the switched role, and the role is reset for the migrators which follow
+	owners := map[string]string{}
+	rows, err := standardConn.Query(context.Background(), "SELECT c.relname, pg_get_userbyid(c.relowner) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE n.nspname = 'owned'")
+	assert.NoError(t, err)
+	for rows.Next() {
+		var name, owner string
+		assert.NoError(t, rows.Scan(&name, &owner))
+		owners[name] = owner
+	}
+	rows.Close()
+	assert.Equal(t, map[string]string{
+		"things":    "app_owner",
+		"ix_things": "app_owner",
+		"others":    config.Username,
+	}, owners)
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 4)
+
+	// switching to a role the user is not a member of fails
+	writeMigrators(t, config.Directory, map[string]string{
+		"0005_stranger.sql": "-- evo: role=stranger\nCREATE TABLE owned.strange (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "must be a member")
+}
+
+func TestSessionRole(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	_, err = adminConn.Exec(context.Background(), "CREATE ROLE app_owner NOLOGIN; CREATE ROLE app_indexer NOLOGIN")
+	assert.NoError(t, err)
+	_ = adminConn.Close(context.Background())
+
+	// the database and user are provisioned first, so that the session role may be granted CREATE in it
+	config.UserRoleMembership = []string{"app_owner", "app_indexer"}
+	config.Bootstrap = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	dbConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	_, err = dbConn.Exec(context.Background(), "GRANT CREATE ON SCHEMA public TO app_owner, app_indexer")
+	assert.NoError(t, err)
+	_ = dbConn.Close(context.Background())
+
+	config.Bootstrap = false
+	config.SessionRole = "app_owner"
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_things.sql": "CREATE TABLE things (id INT);",
+		"0002_index.sql":  "-- evo: role=app_indexer\nCREATE TABLE indexed (id INT);",
+		"0003_others.sql": "CREATE TABLE others (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	// everything is owned by the session role, which a migrator switching role returns to
+	owners := map[string]string{}
+	rows, err := standardConn.Query(context.Background(), "SELECT c.relname, pg_get_userbyid(c.relowner) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE n.nspname = 'public' AND c.relkind = 'r'")
+	assert.NoError(t, err)
+	for rows.Next() {
+		var name, owner string
+		assert.NoError(t, rows.Scan(&name, &owner))
+		owners[name] = owner
+	}
+	rows.Close()
+	assert.Equal(t, map[string]string{
+		"evo_mg":  "app_owner",
+		"things":  "app_owner",
+		"indexed": "app_indexer",
+		"others":  "app_owner",
+	}, owners)
+
+	// history imported into a fresh table is owned by the session role too
+	records, err := getMigrationRecords(standardConn, config)
+	assert.NoError(t, err)
+	history, err := os.Create(filepath.Join(t.TempDir(), "history.csv"))
+	assert.NoError(t, err)
+	assert.NoError(t, writeRecordsCSV(history, records))
+	_, err = history.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	stdin := os.Stdin
+	os.Stdin = history
+	defer func() {
+		os.Stdin = stdin
+	}()
+	_, err = standardConn.Exec(context.Background(), "DROP TABLE evo_mg")
+	assert.NoError(t, err)
+	err = runImport(config, []string{"--format", ExportFormatCSV})
+	assert.NoError(t, err)
+	var owner string
+	err = standardConn.QueryRow(context.Background(), "SELECT pg_get_userbyid(relowner) FROM pg_class WHERE relname = 'evo_mg'").Scan(&owner)
+	assert.NoError(t, err)
+	assert.Equal(t, "app_owner", owner)
+
+	// a role the user is not a member of is refused
+	config.SessionRole = "stranger"
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "unable to switch to session role 'stranger'")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DirectiveSavepoints executes each statement of a transactional migrator under its own savepoint, e.g.
+// "-- evo: savepoints", so that a failure is reported against the statement which caused it
+const DirectiveSavepoints string = "savepoints"
+
+// parseSavepoints reports whether the migrator carries the savepoints directive
+func parseSavepoints(sql string) bool {
+	_, ok := parseDirectives(sql)[DirectiveSavepoints]
+	return ok
+}
+
+// execStatements executes the rendered migrator one statement at a time, each under a savepoint when within a
+// transaction.  a failing statement is rolled back to its savepoint and reported by number, with its redacted
+// text.  a failure with one of the codes the migrator ignores is logged and the statement treated as successful.
+// with --continue-on-statement-error any other failure is logged and the remaining statements are executed,
+// otherwise the migrator fails.
+func execStatements(conn Executable, config *Config, rendered *RenderedMigrator, migName string) error {
+	// outside a transaction each statement commits on its own, and needs no savepoint
+	_, inTx := conn.(pgx.Tx)
+	statements := splitStatements(rendered.SQL)
+	// the redacted text is shown in place of the statement, which may hold secrets.  both renderings split alike
+	// unless a secret itself holds a statement separator, in which case no text is shown
+	redacted := splitStatements(rendered.Redacted)
+	for i, statement := range statements {
+		if inTx {
+			_, err := conn.Exec(context.Background(), "SAVEPOINT evo_statement")
+			if err != nil {
+				return fmt.Errorf("unable to create savepoint: %w", err)
+			}
+		}
+
+		_, err := conn.Exec(context.Background(), statement)
+		if err != nil {
+			if inTx {
+				_, rollbackErr := conn.Exec(context.Background(), "ROLLBACK TO SAVEPOINT evo_statement")
+				if rollbackErr != nil {
+					return fmt.Errorf("unable to roll back to savepoint: %w", rollbackErr)
+				}
+			}
+
+			text := ""
+			if len(redacted) == len(statements) {
+				text = fmt.Sprintf(" (%s)", stripComments(redacted[i]))
+			}
+			switch {
+			case hasCode(err, rendered.IgnoreErrors...):
+				logf("migrator '%s': statement %d of %d%s raised an ignored error: %s\n", migName, i+1, len(statements), text, err.Error())
+			case config.ContinueOnStatementError:
+				err = fmt.Errorf("statement %d of %d%s failed: %w", i+1, len(statements), text, schemaPermissionHint(config, err))
+				logf("migrator '%s': %s, continuing\n", migName, err.Error())
+			default:
+				return fmt.Errorf("statement %d of %d%s failed: %w", i+1, len(statements), text, schemaPermissionHint(config, err))
+			}
+		}
+
+		if inTx {
+			_, err = conn.Exec(context.Background(), "RELEASE SAVEPOINT evo_statement")
+			if err != nil {
+				return fmt.Errorf("unable to release savepoint: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseSavepoints(t *testing.T) {
+	assert.True(t, parseSavepoints("-- evo: savepoints\nCREATE TABLE things (id INT);"))
+	assert.False(t, parseSavepoints("CREATE TABLE things (id INT);"))
+}
+
+func TestSavepoints(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_statements.sql": `-- evo: savepoints
+CREATE TABLE first (id INT);
+INSERT INTO missing VALUES (1);
+CREATE TABLE third (id INT);`,
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "statement 2 of 3 (INSERT INTO missing VALUES (1)) failed")
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	// the migrator as a whole still failed atomically
+	var firstExists, thirdExists bool
+	err = conn.QueryRow(context.Background(), "SELECT to_regclass('first') IS NOT NULL, to_regclass('third') IS NOT NULL").Scan(&firstExists, &thirdExists)
+	assert.NoError(t, err)
+	assert.False(t, firstExists)
+	assert.False(t, thirdExists)
+
+	// in development the failing statement may be skipped
+	config.ContinueOnStatementError = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	err = conn.QueryRow(context.Background(), "SELECT to_regclass('first') IS NOT NULL, to_regclass('third') IS NOT NULL").Scan(&firstExists, &thirdExists)
+	assert.NoError(t, err)
+	assert.True(t, firstExists)
+	assert.True(t, thirdExists)
+
+	// savepoints require a transaction
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_outside_notrans.sql": "-- evo: savepoints\nCREATE TABLE outside (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "require a transaction")
+}
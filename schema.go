@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// userNamespace restricts a catalog query, aliasing pg_namespace as n, to schemas which are not system schemas
+const userNamespace string = `n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%'`
+
+// systemTables are the tables evo creates for its own bookkeeping, which are no part of the schema of a database
+var systemTables = []string{"evo_mg", "evo_mg_objects", "evo_audit", "evo_backfill", "evo_advisory_locks"}
+
+// systemTableList is systemTables as a list of sql literals
+var systemTableList = "'" + strings.Join(systemTables, "', '") + "'"
+
+// userRelation further restricts a catalog query, aliasing pg_class as c, to ordinary relations other than evo's own
+// tables, and the sequences they own, which do not belong to an extension
+var userRelation = userNamespace + ` AND c.relname NOT IN (` + systemTableList + `) AND NOT c.relispartition
+	AND NOT EXISTS (SELECT 1 FROM pg_depend x WHERE x.classid = 'pg_class'::regclass AND x.objid = c.oid AND x.deptype = 'e')
+	AND NOT EXISTS (SELECT 1 FROM pg_depend o JOIN pg_class ot ON ot.oid = o.refobjid
+		WHERE o.classid = 'pg_class'::regclass AND o.objid = c.oid AND o.refclassid = 'pg_class'::regclass AND o.deptype = 'a'
+		AND ot.relname IN (` + systemTableList + `))`
+
+// schemaSections are the queries extracting the definition of each kind of object, in an order in which they can
+// be recreated.  each query returns one complete statement per row, in order of creation.
+var schemaSections = []struct {
+	name  string
+	query string
+}{
+	{
+		name: "schemas",
+		query: `SELECT format('CREATE SCHEMA IF NOT EXISTS %I;', n.nspname)
+			FROM pg_namespace n
+			WHERE ` + userNamespace + ` AND n.nspname <> 'public'
+			AND NOT EXISTS (SELECT 1 FROM pg_depend x WHERE x.classid = 'pg_namespace'::regclass AND x.objid = n.oid AND x.deptype = 'e')
+			ORDER BY n.nspname`,
+	},
+	{
+		name: "extensions",
+		query: `SELECT format('CREATE EXTENSION IF NOT EXISTS %I WITH SCHEMA %I;', e.extname, n.nspname)
+			FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+			WHERE e.extname <> 'plpgsql'
+			ORDER BY e.oid`,
+	},
+	{
+		name: "enum types",
+		query: `SELECT format('CREATE TYPE %I.%I AS ENUM (%s);', n.nspname, t.typname,
+				(SELECT string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder) FROM pg_enum e WHERE e.enumtypid = t.oid))
+			FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+			WHERE t.typtype = 'e' AND ` + userNamespace + `
+			AND NOT EXISTS (SELECT 1 FROM pg_depend x WHERE x.classid = 'pg_type'::regclass AND x.objid = t.oid AND x.deptype = 'e')
+			ORDER BY t.oid`,
+	},
+	{
+		name: "domains",
+		query: `SELECT format('CREATE DOMAIN %I.%I AS %s', n.nspname, t.typname, format_type(t.typbasetype, t.typtypmod))
+				|| coalesce(' DEFAULT ' || t.typdefault, '')
+				|| CASE WHEN t.typnotnull THEN ' NOT NULL' ELSE '' END
+				|| coalesce((SELECT string_agg(format(' CONSTRAINT %I %s', con.conname, pg_get_constraintdef(con.oid)), '' ORDER BY con.oid)
+					FROM pg_constraint con WHERE con.contypid = t.oid AND con.contype = 'c'), '')
+				|| ';'
+			FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+			WHERE t.typtype = 'd' AND ` + userNamespace + `
+			AND NOT EXISTS (SELECT 1 FROM pg_depend x WHERE x.classid = 'pg_type'::regclass AND x.objid = t.oid AND x.deptype = 'e')
+			ORDER BY t.oid`,
+	},
+	{
+		name: "sequences",
+		query: `SELECT format('CREATE SEQUENCE %I.%I AS %s INCREMENT BY %s MINVALUE %s MAXVALUE %s START WITH %s CACHE %s%s;',
+				n.nspname, c.relname, format_type(s.seqtypid, NULL), s.seqincrement, s.seqmin, s.seqmax, s.seqstart, s.seqcache,
+				CASE WHEN s.seqcycle THEN ' CYCLE' ELSE '' END)
+			FROM pg_sequence s JOIN pg_class c ON c.oid = s.seqrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE ` + userRelation + `
+			AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'i')
+			ORDER BY c.oid`,
+	},
+	{
+		name: "functions",
+		query: `SELECT pg_get_functiondef(p.oid) || ';'
+			FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE p.prokind IN ('f', 'p') AND ` + userNamespace + `
+			AND NOT EXISTS (SELECT 1 FROM pg_depend x WHERE x.classid = 'pg_proc'::regclass AND x.objid = p.oid AND x.deptype = 'e')
+			ORDER BY p.oid`,
+	},
+	{
+		name: "tables",
+		query: `SELECT format(E'CREATE %sTABLE %I.%I (\n    %s\n);', CASE WHEN c.relpersistence = 'u' THEN 'UNLOGGED ' ELSE '' END, n.nspname, c.relname,
+				(SELECT string_agg(format('%I %s', a.attname, format_type(a.atttypid, a.atttypmod))
+						|| CASE WHEN a.attgenerated = 's' THEN ' GENERATED ALWAYS AS (' || pg_get_expr(d.adbin, d.adrelid) || ') STORED'
+							ELSE coalesce(' DEFAULT ' || pg_get_expr(d.adbin, d.adrelid), '') END
+						|| CASE a.attidentity WHEN 'a' THEN ' GENERATED ALWAYS AS IDENTITY' WHEN 'd' THEN ' GENERATED BY DEFAULT AS IDENTITY' ELSE '' END
+						|| CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END,
+					E',\n    ' ORDER BY a.attnum)
+				FROM pg_attribute a LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+				WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped))
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind = 'r' AND ` + userRelation + `
+			ORDER BY c.oid`,
+	},
+	{
+		name: "sequence ownership",
+		query: `SELECT format('ALTER SEQUENCE %I.%I OWNED BY %I.%I.%I;', n.nspname, c.relname, tn.nspname, t.relname, a.attname)
+			FROM pg_depend d
+			JOIN pg_class c ON c.oid = d.objid JOIN pg_namespace n ON n.oid = c.relnamespace
+			JOIN pg_class t ON t.oid = d.refobjid JOIN pg_namespace tn ON tn.oid = t.relnamespace
+			JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = d.refobjsubid
+			WHERE d.classid = 'pg_class'::regclass AND d.refclassid = 'pg_class'::regclass AND d.deptype = 'a'
+			AND c.relkind = 'S' AND ` + userRelation + `
+			ORDER BY c.oid`,
+	},
+	{
+		name: "constraints",
+		query: `SELECT format('ALTER TABLE %I.%I ADD CONSTRAINT %I %s;', n.nspname, c.relname, con.conname, pg_get_constraintdef(con.oid))
+			FROM pg_constraint con JOIN pg_class c ON c.oid = con.conrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE con.contype IN ('p', 'u', 'c', 'x') AND con.conparentid = 0 AND c.relkind = 'r' AND ` + userRelation + `
+			ORDER BY con.oid`,
+	},
+	{
+		name: "views",
+		query: `SELECT format(CASE c.relkind WHEN 'm' THEN 'CREATE MATERIALIZED VIEW %I.%I AS%s;' ELSE 'CREATE VIEW %I.%I AS%s;' END,
+				n.nspname, c.relname, rtrim(pg_get_viewdef(c.oid), ';'))
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('v', 'm') AND ` + userRelation + `
+			ORDER BY c.oid`,
+	},
+	{
+		name: "indexes",
+		query: `SELECT pg_get_indexdef(i.indexrelid) || ';'
+			FROM pg_index i JOIN pg_class c ON c.oid = i.indrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'm') AND ` + userRelation + `
+			AND NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = i.indexrelid AND con.contype IN ('p', 'u', 'x'))
+			ORDER BY i.indexrelid`,
+	},
+	{
+		name: "foreign keys",
+		query: `SELECT format('ALTER TABLE %I.%I ADD CONSTRAINT %I %s;', n.nspname, c.relname, con.conname, pg_get_constraintdef(con.oid))
+			FROM pg_constraint con JOIN pg_class c ON c.oid = con.conrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE con.contype = 'f' AND con.conparentid = 0 AND c.relkind = 'r' AND ` + userRelation + `
+			ORDER BY con.oid`,
+	},
+	{
+		name: "triggers",
+		query: `SELECT pg_get_triggerdef(t.oid) || ';'
+			FROM pg_trigger t JOIN pg_class c ON c.oid = t.tgrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE NOT t.tgisinternal AND t.tgparentid = 0 AND ` + userRelation + `
+			ORDER BY t.oid`,
+	},
+}
+
+// queryStatements runs a query returning a single text column, one statement per row
+func queryStatements(conn *pgx.Conn, query string) ([]string, error) {
+	rows, err := conn.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statements []string
+	for rows.Next() {
+		var statement string
+		if err := rows.Scan(&statement); err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+
+	return statements, rows.Err()
+}
+
+// extractSchema reconstructs the schema of the connected database from the system catalogs, as a script which
+// recreates it in an empty database.  schemas, extensions, enum and domain types, sequences, functions, tables,
+// constraints, views, indexes and triggers are covered; data, grants, comments and policies are not.
+func extractSchema(conn *pgx.Conn) (string, error) {
+	var sections []string
+	for _, section := range schemaSections {
+		statements, err := queryStatements(conn, section.query)
+		if err != nil {
+			return "", fmt.Errorf("unable to extract %s: %w", section.name, err)
+		}
+		if len(statements) == 0 {
+			continue
+		}
+		sections = append(sections, fmt.Sprintf("-- %s\n%s", section.name, strings.Join(statements, "\n\n")))
+	}
+
+	return strings.Join(sections, "\n\n"), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// migrationSets returns the names of the immediate subdirectories of the migrator directory which hold migrators,
+// each an independent migration set when EVO_PER_DIRECTORY is set
+func migrationSets(config *Config) ([]string, error) {
+	entries, err := fs.ReadDir(migratorFS(config), ".")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the subdirectories of '%s': %w", config.Directory, err)
+	}
+
+	var sets []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		matches, err := globMigratorFiles(config, filepath.Join(config.Directory, entry.Name()), "*.sql")
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 0 {
+			sets = append(sets, entry.Name())
+		}
+	}
+	return sets, nil
+}
+
+// migrationSetConfig returns a copy of config migrating the set in the named subdirectory, which is tracked by its own
+// evo_mg table in the schema evo_<set>, or <EVO_MIGRATION_SCHEMA>_<set> when a schema is configured, and locked
+// independently of the other sets
+func migrationSetConfig(config *Config, set string) (*Config, error) {
+	schema := "evo"
+	if len(config.MigrationSchema) > 0 {
+		schema = config.MigrationSchema
+	}
+
+	setConfig := *config
+	setConfig.MigrationSet = set
+	setConfig.Directory = filepath.Join(config.Directory, set)
+	setConfig.MigrationSchema = schema + "_" + set
+	if config.FS != nil {
+		sub, err := fs.Sub(config.FS, set)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open migration set '%s': %w", set, err)
+		}
+		setConfig.FS = sub
+	}
+	return &setConfig, nil
+}
+
+// migrateSets migrates each migration set of the directory in turn, as a run of its own, stopping at the first
+// which fails
+func migrateSets(config *Config) error {
+	sets, err := migrationSets(config)
+	if err != nil {
+		return err
+	}
+	if len(sets) == 0 {
+		if config.FailOnEmpty {
+			return fmt.Errorf("no subdirectory of '%s' holds migrators, check the directory given to evo", config.Directory)
+		}
+		logf("no subdirectory of '%s' holds migrators\n", config.Directory)
+		return nil
+	}
+
+	for _, set := range sets {
+		setConfig, err := migrationSetConfig(config, set)
+		if err != nil {
+			return err
+		}
+		logf("migrating set '%s'\n", set)
+		err = doMigration(setConfig, nil)
+		if err != nil {
+			return fmt.Errorf("migration set '%s' failed: %w", set, err)
+		}
+	}
+	return nil
+}
+
+// forEachSet calls fn with the config of each migration set in turn when EVO_PER_DIRECTORY is set, or once with config
+// and an empty set otherwise, so that commands inspecting the migrators see them as a run would
+func forEachSet(config *Config, fn func(set string, setConfig *Config) error) error {
+	if !config.PerDirectory {
+		return fn("", config)
+	}
+
+	sets, err := migrationSets(config)
+	if err != nil {
+		return err
+	}
+	for _, set := range sets {
+		setConfig, err := migrationSetConfig(config, set)
+		if err != nil {
+			return err
+		}
+		err = fn(set, setConfig)
+		if err != nil {
+			return fmt.Errorf("migration set '%s': %w", set, err)
+		}
+	}
+	return nil
+}
+
+// migrate migrates the configured database, either as a whole or, when EVO_PER_DIRECTORY is set, per migration set
+func migrate(config *Config) error {
+	if config.PerDirectory && !config.Bootstrap {
+		return migrateSets(config)
+	}
+	return doMigration(config, nil)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestMigrationSets(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"billing", "docs", "search"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0o755))
+	}
+	writeMigrators(t, filepath.Join(dir, "billing"), map[string]string{"0001_invoices.sql": "CREATE TABLE invoices (id INT);"})
+	writeMigrators(t, filepath.Join(dir, "search"), map[string]string{"0001_documents.sql": "CREATE TABLE documents (id INT);"})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "README.md"), []byte("not a migrator"), 0o644))
+
+	sets, err := migrationSets(&Config{Directory: dir})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"billing", "search"}, sets)
+
+	config, err := migrationSetConfig(&Config{Directory: dir, Database: "app"}, "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "billing"), config.Directory)
+	assert.Equal(t, "evo_billing", config.MigrationSchema)
+	assert.Equal(t, "app/billing", lockName(config))
+
+	// a configured schema prefixes that of each set, rather than being replaced
+	config, err = migrationSetConfig(&Config{Directory: dir, Database: "app", MigrationSchema: "migrations"}, "billing")
+	assert.NoError(t, err)
+	assert.Equal(t, "migrations_billing", config.MigrationSchema)
+
+	var visited []string
+	err = forEachSet(&Config{Directory: dir, PerDirectory: true}, func(set string, setConfig *Config) error {
+		visited = append(visited, set+":"+setConfig.MigrationSchema)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"billing:evo_billing", "search:evo_search"}, visited)
+}
+
+func TestPerDirectory(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	config.PerDirectory = true
+	for _, sub := range []string{"billing", "search"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(config.Directory, sub), 0o755))
+	}
+	// both sets hold a migrator of the same name, tracked apart
+	writeMigrators(t, filepath.Join(config.Directory, "billing"), map[string]string{
+		"0001_make_table.sql": "CREATE TABLE invoices (id INT);",
+		"0002_add_column.sql": "ALTER TABLE invoices ADD COLUMN total INT;",
+	})
+	writeMigrators(t, filepath.Join(config.Directory, "search"), map[string]string{
+		"0001_make_table.sql": "CREATE TABLE documents (id INT);",
+	})
+	err = migrate(config)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	billing, err := migrationSetConfig(config, "billing")
+	assert.NoError(t, err)
+	pastMigrations, err := getPastMigrations(conn, billing)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 2)
+
+	search, err := migrationSetConfig(config, "search")
+	assert.NoError(t, err)
+	pastMigrations, err = getPastMigrations(conn, search)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"0001_make_table.sql": {}}, pastMigrations)
+
+	// a new migrator in one set leaves the other untouched
+	writeMigrators(t, filepath.Join(config.Directory, "search"), map[string]string{
+		"0002_add_column.sql": "ALTER TABLE documents ADD COLUMN body TEXT;",
+	})
+	err = migrate(config)
+	assert.NoError(t, err)
+	pastMigrations, err = getPastMigrations(conn, search)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 2)
+	pastMigrations, err = getPastMigrations(conn, billing)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 2)
+
+	// the read only commands look into each set rather than the directory itself
+	writeMigrators(t, filepath.Join(config.Directory, "billing"), map[string]string{
+		"0003_add_index.sql": "CREATE INDEX invoices_total ON invoices (total);",
+	})
+	var pending []string
+	err = forEachSet(config, func(set string, setConfig *Config) error {
+		status, err := getMigrationStatus(conn, setConfig)
+		if err != nil {
+			return err
+		}
+		for _, migName := range status.Pending {
+			pending = append(pending, set+"/"+migName)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"billing/0003_add_index.sql"}, pending)
+	err = forEachSet(config, func(_ string, setConfig *Config) error {
+		return assertApplied(conn, setConfig)
+	})
+	assert.ErrorContains(t, err, "migration set 'billing': 1 migrators pending")
+}
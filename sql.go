@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var dollarQuoteTag = regexp.MustCompile(`^\$[A-Za-z_][A-Za-z0-9_]*\$|^\$\$`)
+
+// splitStatements splits sql into its top level statements on semicolons, ignoring semicolons which appear
+// within quoted identifiers, string literals, escape string literals (E'...'), dollar quoted bodies and comments.
+// statements are returned trimmed, with empty statements dropped.
+func splitStatements(sql string) []string {
+	var statements []string
+	start := 0
+	i := 0
+	for i < len(sql) {
+		switch {
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 1
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+		case isEscapeString(sql, i):
+			// within E'...' a backslash escapes the character following it, a quote included
+			i += 2
+			for i < len(sql) {
+				if sql[i] == '\\' {
+					i += 2
+					continue
+				}
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+		case sql[i] == '\'' || sql[i] == '"':
+			quote := sql[i]
+			i++
+			for i < len(sql) {
+				if sql[i] == quote {
+					// a doubled quote is an escaped quote
+					if i+1 < len(sql) && sql[i+1] == quote {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+		case sql[i] == '$':
+			tag := dollarQuoteTag.FindString(sql[i:])
+			if len(tag) == 0 {
+				i++
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+		case sql[i] == ';':
+			statements = appendStatement(statements, sql[start:i])
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+	if start < len(sql) {
+		statements = appendStatement(statements, sql[start:])
+	}
+
+	return statements
+}
+
+// isEscapeString reports whether an escape string literal, E'...', begins at i of sql.  the E must not end a longer
+// word, such as the name of a column.
+func isEscapeString(sql string, i int) bool {
+	if sql[i] != 'E' && sql[i] != 'e' {
+		return false
+	}
+	if i+1 >= len(sql) || sql[i+1] != '\'' {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	previous := sql[i-1]
+	return !(previous == '_' || previous == '$' || previous >= '0' && previous <= '9' || previous >= 'a' && previous <= 'z' || previous >= 'A' && previous <= 'Z')
+}
+
+func appendStatement(statements []string, statement string) []string {
+	statement = strings.TrimSpace(statement)
+	if len(stripComments(statement)) == 0 {
+		return statements
+	}
+	return append(statements, statement)
+}
+
+// stripComments removes leading comments and whitespace from a statement
+func stripComments(statement string) string {
+	for {
+		statement = strings.TrimSpace(statement)
+		switch {
+		case strings.HasPrefix(statement, "--"):
+			end := strings.IndexByte(statement, '\n')
+			if end < 0 {
+				return ""
+			}
+			statement = statement[end+1:]
+		case strings.HasPrefix(statement, "/*"):
+			end := strings.Index(statement, "*/")
+			if end < 0 {
+				return ""
+			}
+			statement = statement[end+2:]
+		default:
+			return statement
+		}
+	}
+}
+
+// statementKeywords returns the first n keywords of a statement, upper cased
+func statementKeywords(statement string, n int) []string {
+	fields := strings.Fields(strings.ToUpper(stripComments(statement)))
+	if len(fields) > n {
+		fields = fields[:n]
+	}
+	return fields
+}
+
+// isTransactionControl reports whether a statement begins, ends or aborts a transaction
+func isTransactionControl(statement string) bool {
+	keywords := statementKeywords(statement, 2)
+	if len(keywords) == 0 {
+		return false
+	}
+
+	switch keywords[0] {
+	case "BEGIN", "COMMIT", "END", "ABORT":
+		return true
+	case "START":
+		return len(keywords) > 1 && keywords[1] == "TRANSACTION"
+	case "ROLLBACK":
+		// rolling back to a savepoint leaves the transaction intact
+		return len(keywords) < 2 || keywords[1] != "TO"
+	}
+
+	return false
+}
+
+// validateTransactionalSQL ensures that a transactional migrator does not manage its own transaction, which
+// would end the wrapping transaction prematurely and record the migrator outside of it
+func validateTransactionalSQL(migName string, sql string) error {
+	for _, statement := range splitStatements(sql) {
+		if isTransactionControl(statement) {
+			return fmt.Errorf("transactional migrator '%s' contains transaction control statement '%s', rename it with the suffix _notrans.sql if it must manage its own transactions", migName, strings.Join(statementKeywords(statement, 2), " "))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements(`
+-- leading comment; with a semicolon
+CREATE TABLE a (name TEXT DEFAULT 'x;y');
+/* block; comment */
+CREATE FUNCTION f() RETURNS INT AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql;
+INSERT INTO "odd;name" VALUES ('it''s; fine')
+`)
+	assert.Equal(t, []string{
+		"-- leading comment; with a semicolon\nCREATE TABLE a (name TEXT DEFAULT 'x;y')",
+		"/* block; comment */\nCREATE FUNCTION f() RETURNS INT AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql",
+		`INSERT INTO "odd;name" VALUES ('it''s; fine')`,
+	}, statements)
+
+	// a backslash escapes a quote within an escape string, but not within a standard one
+	statements = splitStatements(`INSERT INTO a VALUES (E'it\'s; fine', e'\\', 'back\');
+INSERT INTO a VALUES (E'two''s; fine');SELECT 1`)
+	assert.Equal(t, []string{
+		`INSERT INTO a VALUES (E'it\'s; fine', e'\\', 'back\')`,
+		`INSERT INTO a VALUES (E'two''s; fine')`,
+		"SELECT 1",
+	}, statements)
+}
+
+func TestValidateTransactionalSQL(t *testing.T) {
+	err := validateTransactionalSQL("0001_ok.sql", "CREATE TABLE a (id INT); SAVEPOINT s; ROLLBACK TO SAVEPOINT s; CREATE FUNCTION f() RETURNS VOID AS $$ BEGIN END; $$ LANGUAGE plpgsql;")
+	assert.NoError(t, err)
+
+	err = validateTransactionalSQL("0002_commit.sql", "CREATE TABLE a (id INT);\ncommit;\nCREATE TABLE b (id INT);")
+	assert.ErrorContains(t, err, "0002_commit.sql")
+	assert.ErrorContains(t, err, "_notrans.sql")
+
+	err = validateTransactionalSQL("0003_begin.sql", "BEGIN; CREATE TABLE a (id INT);")
+	assert.Error(t, err)
+
+	err = validateTransactionalSQL("0004_rollback.sql", "CREATE TABLE a (id INT); ROLLBACK")
+	assert.Error(t, err)
+}
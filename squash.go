@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// subsumedMigrators returns the applied migrators which a baseline squashed up to and including upTo replaces.
+// the reference database must not have applied anything beyond upTo, nor anything missing from the directory,
+// as its schema would then include changes which the baseline does not account for.
+func subsumedMigrators(status *MigrationStatus, upTo string) ([]string, error) {
+	if len(status.Missing) > 0 {
+		return nil, fmt.Errorf("reference database has applied migrators which are missing from the directory: %s", strings.Join(status.Missing, ", "))
+	}
+	if len(status.Applied) == 0 {
+		return nil, fmt.Errorf("reference database has no applied migrators to squash")
+	}
+	if len(upTo) == 0 {
+		return status.Applied, nil
+	}
+
+	index := slices.Index(status.Applied, upTo)
+	if index < 0 {
+		return nil, fmt.Errorf("migrator '%s' has not been applied to the reference database", upTo)
+	}
+	if index < len(status.Applied)-1 {
+		return nil, fmt.Errorf("reference database has migrator '%s' applied beyond '%s', squash against a database migrated only up to it", status.Applied[index+1], upTo)
+	}
+
+	return status.Applied, nil
+}
+
+// getBaseline extracts the schema of the reference database into a baseline migrator which subsumes the applied
+// migrators up to and including upTo
+func getBaseline(conn *pgx.Conn, config *Config, upTo string) (string, error) {
+	status, err := getMigrationStatus(conn, config)
+	if err != nil {
+		return "", err
+	}
+
+	subsumed, err := subsumedMigrators(status, upTo)
+	if err != nil {
+		return "", err
+	}
+
+	schema, err := extractSchema(conn)
+	if err != nil {
+		return "", err
+	}
+
+	var baseline strings.Builder
+	fmt.Fprintf(&baseline, "-- baseline generated by evo squash from database '%s'\n", config.Database)
+	for _, migName := range subsumed {
+		fmt.Fprintf(&baseline, "%s %s\n", subsumesPrefix, migName)
+	}
+	// function bodies may refer to objects created further down
+	fmt.Fprintf(&baseline, "\nSET LOCAL check_function_bodies = false;\n\n")
+	// the baseline is itself rendered as a template
+	baseline.WriteString(strings.ReplaceAll(schema, "{{", `{{"{{"}}`))
+	baseline.WriteString("\n")
+
+	return baseline.String(), nil
+}
+
+// applyBaseline handles a pending baseline migrator.  on a database which has applied none of the migrators it
+// subsumes, the baseline is executed and all of them are recorded alongside it.  on a database which has applied
+// all of them, it is recorded without being executed.
+func applyBaseline(rendered *RenderedMigrator, conn Transactor, config *Config, migName string, subsumed []string, existingMigrators map[string]struct{}) error {
+	keys := make([]string, 0, len(subsumed))
+	var present []string
+	for _, subsumedName := range subsumed {
+		key, err := migratorKey(config, subsumedName)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		if _, ok := existingMigrators[key]; ok {
+			present = append(present, subsumedName)
+		}
+	}
+
+	if len(present) == len(subsumed) {
+		logf("migrators subsumed by baseline '%s' are already applied, recording it without executing\n", migName)
+		return recordMigrator(conn, config, migName, rendered.Checksum)
+	}
+	if len(present) > 0 {
+		return fmt.Errorf("baseline '%s' cannot be applied to a database which has applied only some of the migrators it subsumes (%s), apply the original migrators first", migName, strings.Join(present, ", "))
+	}
+
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	err = executeMigrator(rendered, tx, config, migName)
+	if err != nil {
+		return fmt.Errorf("error executing baseline '%s' in transaction: %w", migName, err)
+	}
+	for _, subsumedName := range subsumed {
+		err = recordMigrator(tx, config, subsumedName, "")
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction for baseline '%s': %w", migName, err)
+	}
+
+	// subsumed migrators still present in the directory must now be skipped
+	for _, key := range keys {
+		existingMigrators[key] = struct{}{}
+	}
+
+	return nil
+}
+
+func runSquash(config *Config, args []string) error {
+	flags := flag.NewFlagSet("squash", flag.ContinueOnError)
+	upTo := flags.String("up-to", "", "the last migrator subsumed by the baseline (default all applied migrators)")
+	output := flags.String("output", "", "path of the baseline migrator to write")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(*output) == 0 {
+		return fmt.Errorf("--output is required")
+	}
+
+	logf("connecting to reference database '%s' as user '%s' (read only)\n", config.Database, config.Username)
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	if err != nil {
+		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	baseline, err := getBaseline(conn, config, *upTo)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(*output, []byte(baseline), 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to write baseline '%s': %w", *output, err)
+	}
+	logf("baseline written to '%s', the migrators it subsumes may now be removed\n", *output)
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// squashMigrators exercise each kind of object covered by extractSchema
+var squashMigrators = map[string]string{
+	"0001_types.sql": `CREATE TYPE color AS ENUM ('red', 'green');
+CREATE DOMAIN positive AS INT CHECK (VALUE > 0);
+CREATE SCHEMA billing;`,
+	"0002_tables.sql": `CREATE TABLE owners (id SERIAL PRIMARY KEY, name TEXT NOT NULL UNIQUE);
+CREATE TABLE things (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    owner_id INT REFERENCES owners (id) ON DELETE CASCADE,
+    color color DEFAULT 'red',
+    quantity positive,
+    doubled INT GENERATED ALWAYS AS (quantity * 2) STORED,
+    CHECK (owner_id IS NOT NULL OR color = 'red')
+);
+CREATE TABLE billing.invoices (id BIGSERIAL PRIMARY KEY, thing_id INT NOT NULL REFERENCES things (id));`,
+	"0003_alter.sql": `ALTER TYPE color ADD VALUE 'blue';
+ALTER TABLE owners ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE things DROP COLUMN quantity CASCADE;
+CREATE INDEX ix_things_color ON things (color) WHERE color <> 'red';
+CREATE VIEW red_things AS SELECT id, owner_id FROM things WHERE color = 'red';`,
+	"0004_trigger.sql": `CREATE FUNCTION touch() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    NEW.created_at = NOW();
+    RETURN NEW;
+END;
+$$;
+CREATE TRIGGER owners_touch BEFORE UPDATE ON owners FOR EACH ROW EXECUTE FUNCTION touch();`,
+}
+
+func getSchema(t *testing.T, config *Config) string {
+	t.Helper()
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	schema, err := extractSchema(conn)
+	assert.NoError(t, err)
+	return schema
+}
+
+func TestSquash(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, squashMigrators)
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	assert.NoError(t, err)
+	_, err = getBaseline(conn, config, "0003_alter.sql")
+	assert.ErrorContains(t, err, "applied beyond '0003_alter.sql'")
+	baseline, err := getBaseline(conn, config, "0004_trigger.sql")
+	assert.NoError(t, err)
+	_ = conn.Close(context.Background())
+
+	// the squashed directory holds the baseline in place of the migrators it subsumes, followed by a later one
+	later := map[string]string{
+		"0005_later.sql": "ALTER TABLE things ADD COLUMN note TEXT;",
+	}
+	squashedDirectory := t.TempDir()
+	writeMigrators(t, squashedDirectory, later)
+	err = os.WriteFile(filepath.Join(squashedDirectory, "0000_baseline.sql"), []byte(baseline), 0o644)
+	assert.NoError(t, err)
+
+	// the reference database replays every migrator, its baseline is recorded without being executed
+	writeMigrators(t, config.Directory, later)
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	config.Directory = squashedDirectory
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// a fresh database applies the baseline and the later migrator
+	fresh := *config
+	fresh.Database = "testdb_fresh"
+	err = doMigration(&fresh, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, getSchema(t, config), getSchema(t, &fresh))
+
+	for _, c := range []*Config{config, &fresh} {
+		conn, err := pgx.Connect(context.Background(), c.GetUserConnUrl())
+		assert.NoError(t, err)
+		pastMigrations, err := getPastMigrations(conn, c)
+		assert.NoError(t, err)
+		assert.Len(t, pastMigrations, 6)
+		assert.Contains(t, pastMigrations, "0000_baseline.sql")
+		assert.Contains(t, pastMigrations, "0002_tables.sql")
+		_ = conn.Close(context.Background())
+	}
+}
+
+func TestSubsumedMigrators(t *testing.T) {
+	status := &MigrationStatus{Applied: []string{"0001_a.sql", "0002_b.sql"}, Pending: []string{"0003_c.sql"}}
+	subsumed, err := subsumedMigrators(status, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001_a.sql", "0002_b.sql"}, subsumed)
+
+	subsumed, err = subsumedMigrators(status, "0002_b.sql")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001_a.sql", "0002_b.sql"}, subsumed)
+
+	_, err = subsumedMigrators(status, "0001_a.sql")
+	assert.ErrorContains(t, err, "'0002_b.sql' applied beyond")
+
+	_, err = subsumedMigrators(status, "0003_c.sql")
+	assert.ErrorContains(t, err, "has not been applied")
+
+	status.Missing = []string{"0000_gone.sql"}
+	_, err = subsumedMigrators(status, "")
+	assert.ErrorContains(t, err, "0000_gone.sql")
+}
+
+func TestSchemaSkipsSystemTables(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE accounts (id INT PRIMARY KEY, seen BOOLEAN);\nINSERT INTO accounts SELECT generate_series(1, 3);",
+		"0002_backfill.sql":   "-- evo: backfill=accounts.id\nUPDATE accounts SET seen = true WHERE id BETWEEN $1 + 1 AND $2;",
+	})
+	// evo_audit, and the sequence of its id, are left out as well
+	config.Audit = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	schema := getSchema(t, config)
+	assert.Contains(t, schema, "accounts")
+	assert.NotContains(t, schema, "evo_")
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	objects, err := snapshotSchema(conn)
+	assert.NoError(t, err)
+	for key := range objects {
+		assert.NotContains(t, key, "evo_")
+	}
+}
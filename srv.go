@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srvPrefix marks a hostname which is the name of a DNS SRV record, e.g. "srv://_postgres._tcp.db.svc", resolved to
+// a host and port once, as the configuration is read
+const srvPrefix = "srv://"
+
+// srvLookupTimeout bounds the time spent resolving an SRV record
+const srvLookupTimeout = 10 * time.Second
+
+// SRVResolver looks up SRV records, satisfied by *net.Resolver
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service string, proto string, name string) (string, []*net.SRV, error)
+}
+
+// srvResolver resolves SRV hostnames, replaced in tests
+var srvResolver SRVResolver = net.DefaultResolver
+
+// selectSRV picks a target as described by RFC 2782: among the targets of the lowest priority, one is chosen at
+// random in proportion to its weight.  targets of weight 0 are only chosen when all targets of that priority have it.
+func selectSRV(records []*net.SRV) *net.SRV {
+	var candidates []*net.SRV
+	for _, record := range records {
+		switch {
+		case len(candidates) == 0 || record.Priority < candidates[0].Priority:
+			candidates = []*net.SRV{record}
+		case record.Priority == candidates[0].Priority:
+			candidates = append(candidates, record)
+		}
+	}
+
+	total := 0
+	for _, candidate := range candidates {
+		total += int(candidate.Weight)
+	}
+	if total == 0 {
+		return candidates[0]
+	}
+
+	r := rand.IntN(total)
+	for _, candidate := range candidates {
+		r -= int(candidate.Weight)
+		if r < 0 {
+			return candidate
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// resolveSRVHost resolves a hostname of the form "srv://<name>" to the "<host>:<port>" of one of the targets of the
+// SRV record <name>.  any other hostname is returned as it is.
+func resolveSRVHost(hostname string) (string, error) {
+	name, ok := strings.CutPrefix(hostname, srvPrefix)
+	if !ok {
+		return hostname, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+	defer cancel()
+	_, records, err := srvResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve SRV record '%s': %w", name, err)
+	}
+	if len(records) == 0 {
+		return "", fmt.Errorf("SRV record '%s' has no targets", name)
+	}
+
+	target := selectSRV(records)
+	return net.JoinHostPort(strings.TrimSuffix(target.Target, "."), strconv.Itoa(int(target.Port))), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubResolver answers SRV lookups from a fixed set of records
+type stubResolver struct {
+	records map[string][]*net.SRV
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service string, proto string, name string) (string, []*net.SRV, error) {
+	records, ok := r.records[name]
+	if !ok {
+		return "", nil, errors.New("no such host")
+	}
+	return name, records, nil
+}
+
+func TestSelectSRV(t *testing.T) {
+	// the lowest priority wins regardless of weight
+	target := selectSRV([]*net.SRV{
+		{Target: "backup.", Port: 5432, Priority: 20, Weight: 100},
+		{Target: "primary.", Port: 5432, Priority: 10, Weight: 0},
+	})
+	assert.Equal(t, "primary.", target.Target)
+
+	// within a priority, a target of weight 0 is not chosen over one with weight
+	for range 20 {
+		target = selectSRV([]*net.SRV{
+			{Target: "drained.", Port: 5432, Priority: 10, Weight: 0},
+			{Target: "active.", Port: 5432, Priority: 10, Weight: 5},
+			{Target: "backup.", Port: 5432, Priority: 20, Weight: 5},
+		})
+		assert.Equal(t, "active.", target.Target)
+	}
+}
+
+func TestResolveSRVHost(t *testing.T) {
+	previous := srvResolver
+	defer func() {
+		srvResolver = previous
+	}()
+	srvResolver = &stubResolver{records: map[string][]*net.SRV{
+		"_postgres._tcp.db.svc": {{Target: "db-0.db.svc.", Port: 6543, Priority: 10, Weight: 1}},
+		"_postgres._tcp.empty":  {},
+	}}
+
+	host, err := resolveSRVHost("srv://_postgres._tcp.db.svc")
+	assert.NoError(t, err)
+	assert.Equal(t, "db-0.db.svc:6543", host)
+
+	host, err = resolveSRVHost("db.example.com:5432")
+	assert.NoError(t, err)
+	assert.Equal(t, "db.example.com:5432", host)
+
+	_, err = resolveSRVHost("srv://_postgres._tcp.missing")
+	assert.ErrorContains(t, err, "unable to resolve SRV record '_postgres._tcp.missing'")
+	_, err = resolveSRVHost("srv://_postgres._tcp.empty")
+	assert.ErrorContains(t, err, "has no targets")
+
+	// the record is resolved once, every connection of the run reaching the same target
+	srvResolver = &stubResolver{records: map[string][]*net.SRV{
+		"_postgres._tcp.db.svc": {
+			{Target: "db-0.db.svc.", Port: 6543, Priority: 10, Weight: 1},
+			{Target: "db-1.db.svc.", Port: 6543, Priority: 10, Weight: 1},
+		},
+	}}
+	t.Setenv("EVO_DB_HOST", "srv://_postgres._tcp.db.svc")
+	t.Setenv("EVO_DB_DATABASE", Database)
+	t.Setenv("EVO_DB_ADMIN_USERNAME", AdminUsername)
+	t.Setenv("EVO_DB_ADMIN_PASSWORD", AdminPassword)
+	t.Setenv("EVO_DB_USERNAME", Username)
+	t.Setenv("EVO_DB_PASSWORD", Password)
+	config, err := getConfig(t.TempDir(), ConnectAdmin)
+	assert.NoError(t, err)
+	assert.NotContains(t, config.Hostname, srvPrefix)
+	for range 10 {
+		for _, connUrl := range []string{config.GetAdminConnUrl(), config.GetAdminConnUrl("postgres"), config.GetUserConnUrl()} {
+			connConfig, err := pgx.ParseConfig(connUrl)
+			assert.NoError(t, err)
+			assert.Equal(t, config.Hostname, net.JoinHostPort(connConfig.Host, "6543"))
+			assert.Equal(t, uint16(6543), connConfig.Port)
+		}
+	}
+
+	t.Setenv("EVO_DB_HOST", "srv://_postgres._tcp.missing")
+	_, err = getConfig(t.TempDir(), ConnectAdmin)
+	assert.ErrorContains(t, err, "unable to resolve SRV record")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type MigrationStatus struct {
+	// Applied migrators which are both recorded in evo_mg and present in the directory
+	Applied []string
+	// Pending migrators which are present in the directory but not yet recorded in evo_mg
+	Pending []string
+	// Missing migrators which are recorded in evo_mg but no longer present in the directory (these are versions
+	// when tracking by version)
+	Missing []string
+	// CurrentVersion is the highest applied version when tracking by version
+	CurrentVersion *int64
+	// Releases maps applied migrators to the release label they were applied under, if any
+	Releases map[string]string
+}
+
+// parseSince sets config.Since from an RFC3339 timestamp, an empty value leaves it unset
+func parseSince(config *Config, since string) error {
+	if len(since) == 0 {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return fmt.Errorf("--since must be an RFC3339 timestamp: %w", err)
+	}
+	config.Since = &t
+
+	return nil
+}
+
+// connectReadOnly opens a connection on which every transaction defaults to read only, so that any accidental
+// write is rejected by the server rather than mutating the database.  this makes it safe to point at a replica.
+func connectReadOnly(connUrl string) (*pgx.Conn, error) {
+	connConfig, err := pgx.ParseConfig(connUrl)
+	if err != nil {
+		return nil, err
+	}
+	connConfig.RuntimeParams["default_transaction_read_only"] = "on"
+
+	return pgx.ConnectConfig(context.Background(), connConfig)
+}
+
+// getMigrationStatus compares the migrators recorded in evo_mg against those in the directory, without
+// writing anything to the database.  when config.Since is set, only the migrators applied at or after it are
+// reported as applied.  migrators which do not apply to the environment are not reported as pending.
+func getMigrationStatus(conn *pgx.Conn, config *Config) (*MigrationStatus, error) {
+	exists, err := migratorTableExists(conn, config)
+	if err != nil {
+		return nil, err
+	}
+
+	pastMigrations := map[string]struct{}{}
+	if exists {
+		pastMigrations, err = getAppliedKeys(conn, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matches, err := findMigrators(config)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MigrationStatus{
+		Releases: map[string]string{},
+	}
+	present := map[string]struct{}{}
+	for _, match := range matches {
+		migName := migratorName(config, match)
+		key, err := migratorKey(config, migName)
+		if err != nil {
+			return nil, err
+		}
+		present[key] = struct{}{}
+		if _, ok := pastMigrations[key]; ok {
+			status.Applied = append(status.Applied, migName)
+			continue
+		}
+
+		// a migrator a run would skip in this environment is not pending in it
+		source, err := readMigrator(config, match)
+		if err != nil {
+			return nil, err
+		}
+		if allowedInEnvironment(source, config.Env) {
+			status.Pending = append(status.Pending, migName)
+		}
+	}
+
+	for key := range pastMigrations {
+		if _, ok := present[key]; !ok {
+			status.Missing = append(status.Missing, key)
+		}
+	}
+	sort.Strings(status.Missing)
+
+	if exists {
+		records, err := getMigrationRecords(conn, config)
+		if err != nil {
+			return nil, err
+		}
+		appliedSince := map[string]struct{}{}
+		for _, record := range records {
+			if record.Release != nil {
+				status.Releases[record.Migrator] = *record.Release
+			}
+			key := record.Migrator
+			if record.Version != nil {
+				key = formatVersion(record.Version)
+			}
+			appliedSince[key] = struct{}{}
+		}
+
+		if config.Since != nil {
+			var applied []string
+			for _, migName := range status.Applied {
+				key, err := migratorKey(config, migName)
+				if err != nil {
+					return nil, err
+				}
+				if _, ok := appliedSince[key]; ok {
+					applied = append(applied, migName)
+				}
+			}
+			status.Applied = applied
+		}
+	}
+
+	if exists && config.TrackBy == TrackByVersion {
+		version, ok, err := getCurrentVersion(conn, config)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			status.CurrentVersion = &version
+		}
+	}
+
+	return status, nil
+}
+
+func runStatus(config *Config, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	since := flags.String("since", "", "only report migrators applied at or after this RFC3339 timestamp")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = parseSince(config, *since)
+	if err != nil {
+		return err
+	}
+
+	logf("connecting to database '%s' as user '%s' (read only)\n", config.Database, config.Username)
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	if err != nil {
+		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	return forEachSet(config, func(set string, setConfig *Config) error {
+		if len(set) > 0 {
+			fmt.Printf("set %s\n", set)
+		}
+		return printStatus(conn, setConfig)
+	})
+}
+
+// printStatus reports the applied, pending, missing and drifted migrators of config
+func printStatus(conn *pgx.Conn, config *Config) error {
+	status, err := getMigrationStatus(conn, config)
+	if err != nil {
+		return err
+	}
+
+	for _, migName := range status.Applied {
+		release, ok := status.Releases[migName]
+		if ok {
+			fmt.Printf("applied  %s (release %s)\n", migName, release)
+			continue
+		}
+		fmt.Printf("applied  %s\n", migName)
+	}
+	for _, migName := range status.Pending {
+		fmt.Printf("pending  %s\n", migName)
+	}
+	for _, migName := range status.Missing {
+		fmt.Printf("missing  %s\n", migName)
+	}
+
+	drifted, err := findDrift(conn, config)
+	if err != nil {
+		return err
+	}
+	for _, migName := range drifted {
+		fmt.Printf("drifted  %s (changed since it was applied)\n", migName)
+	}
+	fmt.Printf("%d applied, %d pending, %d missing, %d drifted\n", len(status.Applied), len(status.Pending), len(status.Missing), len(drifted))
+	if status.CurrentVersion != nil {
+		fmt.Printf("current version %d\n", *status.CurrentVersion)
+	}
+
+	return nil
+}
+
+// missingApplied returns the keys of the applied migrators which no longer have a file among matches, other than
+// those subsumed by a baseline which is present, whose files are expected to have been removed
+func missingApplied(config *Config, applied map[string]struct{}, matches []string) ([]string, error) {
+	present := map[string]struct{}{}
+	for _, match := range matches {
+		names := []string{migratorName(config, match)}
+		source, err := readMigrator(config, match)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, parseSubsumes(source)...)
+
+		for _, migName := range names {
+			key, err := migratorKey(config, migName)
+			if err != nil {
+				return nil, err
+			}
+			present[key] = struct{}{}
+		}
+	}
+
+	var missing []string
+	for key := range applied {
+		if _, ok := present[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+
+	return missing, nil
+}
+
+// assertApplied returns an error listing the pending migrators, if there are any
+func assertApplied(conn *pgx.Conn, config *Config) error {
+	status, err := getMigrationStatus(conn, config)
+	if err != nil {
+		return err
+	}
+
+	if len(status.Pending) > 0 {
+		return fmt.Errorf("%d migrators pending: %s", len(status.Pending), strings.Join(status.Pending, ", "))
+	}
+
+	return nil
+}
+
+func runAssertApplied(config *Config, args []string) error {
+	logf("connecting to database '%s' as user '%s' (read only)\n", config.Database, config.Username)
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	if err != nil {
+		return fmt.Errorf("unable to connect to database '%s': %w", config.Database, err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	err = forEachSet(config, func(_ string, setConfig *Config) error {
+		return assertApplied(conn, setConfig)
+	})
+	if err != nil {
+		return err
+	}
+	logf("all migrators applied\n")
+
+	return nil
+}
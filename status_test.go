@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestStatusReadOnly(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	status, err := getMigrationStatus(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, status.Applied, 5)
+	assert.Empty(t, status.Pending)
+	assert.Empty(t, status.Missing)
+
+	// any write over the read only connection must be rejected
+	err = executeMigrator(&RenderedMigrator{SQL: "CREATE TABLE readonly_probe (id INT)"}, conn, config, "0006_readonly_probe.sql")
+	assert.Error(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	var exists bool
+	err = standardConn.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'readonly_probe')").Scan(&exists)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	pastMigrations, err := getPastMigrations(standardConn, config)
+	assert.NoError(t, err)
+	assert.NotContains(t, pastMigrations, "0006_readonly_probe.sql")
+}
+
+func TestAssertApplied(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := connectReadOnly(config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	err = assertApplied(conn, config)
+	assert.NoError(t, err)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+	err = assertApplied(conn, config)
+	assert.ErrorContains(t, err, "0002_second.sql")
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	err = assertApplied(conn, config)
+	assert.NoError(t, err)
+}
+
+func TestStatusSince(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	for migName, createdAt := range map[string]string{
+		"0001_make_table.sql":        "2024-01-01T00:00:00Z",
+		"0002_drop_and_make.sql":     "2024-02-01T00:00:00Z",
+		"0003_make_dtype.sql":        "2024-03-01T00:00:00Z",
+		"0004_edit_type_notrans.sql": "2024-04-01T00:00:00Z",
+		"0005_add_index.sql":         "2024-05-01T00:00:00Z",
+	} {
+		_, err = conn.Exec(context.Background(), "UPDATE evo_mg SET created_at = $1 WHERE migrator = $2", createdAt, migName)
+		assert.NoError(t, err)
+	}
+
+	err = parseSince(config, "2024-03-01T00:00:00Z")
+	assert.NoError(t, err)
+
+	records, err := getMigrationRecords(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, "0003_make_dtype.sql", records[0].Migrator)
+
+	status, err := getMigrationStatus(conn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0003_make_dtype.sql", "0004_edit_type_notrans.sql", "0005_add_index.sql"}, status.Applied)
+	assert.Empty(t, status.Pending)
+
+	err = parseSince(config, "last tuesday")
+	assert.ErrorContains(t, err, "--since must be an RFC3339 timestamp")
+}
+
+func TestFailOnMissingApplied(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.FailOnMissingApplied = true
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql":  "CREATE TABLE first (id INT);",
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	err = os.Remove(filepath.Join(config.Directory, "0001_first.sql"))
+	assert.NoError(t, err)
+	writeMigrators(t, config.Directory, map[string]string{
+		"0003_third.sql": "CREATE TABLE third (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "0001_first.sql")
+
+	// nothing was applied by the failed run
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 2)
+
+	// a baseline accounts for the migrators it subsumes
+	writeMigrators(t, config.Directory, map[string]string{
+		"0000_baseline.sql": "-- evo-subsumes: 0001_first.sql\nCREATE TABLE first (id INT);",
+	})
+	missing, err := missingApplied(config, pastMigrations, []string{
+		filepath.Join(config.Directory, "0000_baseline.sql"),
+		filepath.Join(config.Directory, "0002_second.sql"),
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+}
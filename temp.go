@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// tempDatabaseName returns a name for a temporary database, derived from prefix and unique to the caller, so that
+// any number of temporary databases may be created concurrently
+func tempDatabaseName(prefix string) string {
+	suffix := strings.ReplaceAll(uuid.NewString(), "-", "")[:16]
+	// postgres truncates identifiers to 63 bytes, the suffix must survive it
+	maxPrefix := 63 - len(suffix) - 1
+	if len(prefix) > maxPrefix {
+		prefix = prefix[:maxPrefix]
+	}
+	return prefix + "_" + suffix
+}
+
+// tempConfig returns a copy of config targeting the named temporary database, which writes nothing outside it
+func tempConfig(config *Config, database string) *Config {
+	temp := *config
+	temp.Database = database
+	temp.Databases = []string{database}
+	temp.CheckpointFile = ""
+	temp.MetricsTextfile = ""
+	temp.ManifestOut = ""
+	return &temp
+}
+
+// dropDatabase drops the named database, disconnecting any sessions still connected to it
+func dropDatabase(adminConn *pgx.Conn, database string) error {
+	logf("dropping temporary database '%s'\n", database)
+	_, err := adminConn.Exec(context.Background(), fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", pgx.Identifier{database}.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("unable to drop temporary database '%s': %w", database, err)
+	}
+	return nil
+}
+
+// MigrateTemp creates a uniquely named database, alongside the configured one, and applies every migrator to it,
+// giving a test an isolated and fully migrated database.  the name of the database is returned, along with a
+// function which drops it.  ctx is checked before the database is created, the migration itself runs to
+// completion.  should the migration fail, the database is dropped before the error is returned.
+func MigrateTemp(ctx context.Context, config *Config) (string, func(), error) {
+	err := ctx.Err()
+	if err != nil {
+		return "", nil, err
+	}
+
+	temp := tempConfig(config, tempDatabaseName(config.Database+"_tmp"))
+	temp.SkipCreateDatabase = false
+	cleanup := func() {
+		adminConn, err := pgx.Connect(context.Background(), temp.GetAdminConnUrl(maintenanceDatabase))
+		if err != nil {
+			logf("unable to connect to drop temporary database '%s': %s\n", temp.Database, err.Error())
+			return
+		}
+		defer func() {
+			_ = adminConn.Close(context.Background())
+		}()
+
+		err = dropDatabase(adminConn, temp.Database)
+		if err != nil {
+			logf("%s\n", err.Error())
+		}
+	}
+
+	err = doMigration(temp, nil)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return temp.Database, cleanup, nil
+}
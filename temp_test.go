@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestTempDatabaseName(t *testing.T) {
+	assert.NotEqual(t, tempDatabaseName("testdb_tmp"), tempDatabaseName("testdb_tmp"))
+	assert.Regexp(t, `^testdb_tmp_[0-9a-f]{16}$`, tempDatabaseName("testdb_tmp"))
+	assert.Len(t, tempDatabaseName(string(make([]byte, 100))), 63)
+}
+
+func TestMigrateTemp(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql":  "CREATE TABLE first (id INT);",
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+
+	// the calls race to create and set up the same, new, user
+	config.Username = "temp_user"
+	names := make([]string, 4)
+	cleanups := make([]func(), 4)
+	wg := sync.WaitGroup{}
+	for i := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name, cleanup, err := MigrateTemp(context.Background(), config)
+			assert.NoError(t, err)
+			names[i] = name
+			cleanups[i] = cleanup
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, slices.Compact(slices.Sorted(slices.Values(names))), len(names))
+
+	for _, name := range names {
+		conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl(name))
+		assert.NoError(t, err)
+		pastMigrations, err := getPastMigrations(conn, config)
+		assert.NoError(t, err)
+		assert.Contains(t, pastMigrations, "0001_first.sql")
+		assert.Contains(t, pastMigrations, "0002_second.sql")
+		_ = conn.Close(context.Background())
+	}
+
+	for _, cleanup := range cleanups {
+		cleanup()
+	}
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	var remaining int
+	err = adminConn.QueryRow(context.Background(), "SELECT count(*) FROM pg_database WHERE datname = ANY($1)", names).Scan(&remaining)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+}
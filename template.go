@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template/parse"
+	"unicode/utf16"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvPrecedenceHigh causes environment variables to override values from template vars files
+	EnvPrecedenceHigh string = "high"
+	// EnvPrecedenceLow causes values from template vars files to override environment variables
+	EnvPrecedenceLow string = "low"
+)
+
+// secretPrefix namespaces the environment variables holding secrets, which are withheld from the template
+// dictionary and only available through the secret template function
+const secretPrefix string = "EVO_SECRET_"
+
+// redactedSecret replaces secrets in printed migrators
+const redactedSecret string = "[redacted]"
+
+// runID uniquely identifies this invocation of evo, it is exposed to templates as .RunID and recorded against
+// each migrator applied, unless EVO_RUN_ID supplies an identifier instead
+var runID = newRunID()
+
+// newRunID generates an identifier, unique to an invocation of evo
+func newRunID() string {
+	return uuid.NewString()
+}
+
+// getRunID returns the identifier of this invocation, as configured or generated
+func getRunID(config *Config) string {
+	if len(config.RunID) > 0 {
+		return config.RunID
+	}
+	return runID
+}
+
+// splitList splits a colon or comma separated list, dropping empty entries
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ':' || r == ','
+	})
+}
+
+// deepMerge merges src into dst, nested maps are merged recursively rather than replaced wholesale
+func deepMerge(dst map[string]any, src map[string]any) {
+	for key, srcValue := range src {
+		srcMap, srcIsMap := srcValue.(map[string]any)
+		dstMap, dstIsMap := dst[key].(map[string]any)
+		if srcIsMap && dstIsMap {
+			deepMerge(dstMap, srcMap)
+			continue
+		}
+		dst[key] = srcValue
+	}
+}
+
+// loadTemplateVarsFile reads a json or yaml file containing a dictionary of template variables
+func loadTemplateVarsFile(path string) (map[string]any, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template vars file '%s': %w", path, err)
+	}
+
+	vars := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &vars)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &vars)
+	default:
+		return nil, fmt.Errorf("template vars file '%s' must have a .json, .yaml or .yml extension", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template vars file '%s': %w", path, err)
+	}
+
+	return vars, nil
+}
+
+// getTemplateData builds the dictionary supplied to each migrator template.  template vars files are merged
+// left to right, and the process environment is overlaid on top of them (or beneath them, depending on the
+// configured precedence).  when an allowlist is configured, only the environment variables it names are
+// included.  the render time values .RunID and .Now are set last, and are fixed for the run.
+func getTemplateData(config *Config) (map[string]any, error) {
+	fileVars := map[string]any{}
+	for _, path := range config.TemplateVarsFiles {
+		vars, err := loadTemplateVarsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		deepMerge(fileVars, vars)
+	}
+
+	envVars := map[string]any{}
+	if len(config.TemplateAllow) > 0 {
+		for _, name := range config.TemplateAllow {
+			value, ok := os.LookupEnv(name)
+			if ok && !strings.HasPrefix(name, secretPrefix) {
+				envVars[name] = value
+			}
+		}
+	} else {
+		for _, envStr := range os.Environ() {
+			strParts := strings.SplitN(envStr, "=", 2)
+			if strings.HasPrefix(strParts[0], secretPrefix) {
+				continue
+			}
+			envVars[strParts[0]] = strParts[1]
+		}
+	}
+
+	data := map[string]any{}
+	if config.TemplateEnvPrecedence == EnvPrecedenceLow {
+		deepMerge(data, envVars)
+		deepMerge(data, fileVars)
+	} else {
+		deepMerge(data, fileVars)
+		deepMerge(data, envVars)
+	}
+	data["RunID"] = getRunID(config)
+	data["Now"] = now(config)
+	data["Database"] = config.Database
+
+	return data, nil
+}
+
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+// defaultFileEncoding is the encoding of migrators without a byte order mark unless EVO_FILE_ENCODING says otherwise
+const defaultFileEncoding string = "utf-8"
+
+// decodeMigrator converts the raw content of a migrator to a string.  a byte order mark is stripped, and a
+// utf-16 byte order mark selects the matching utf-16 decoding regardless of the declared encoding.
+func decodeMigrator(content []byte, encoding string) (string, error) {
+	switch {
+	case bytes.HasPrefix(content, utf8BOM):
+		return string(content[len(utf8BOM):]), nil
+	case bytes.HasPrefix(content, utf16LEBOM):
+		return decodeUTF16(content[len(utf16LEBOM):], binary.LittleEndian)
+	case bytes.HasPrefix(content, utf16BEBOM):
+		return decodeUTF16(content[len(utf16BEBOM):], binary.BigEndian)
+	}
+
+	switch strings.ToLower(encoding) {
+	case "", defaultFileEncoding, "utf8":
+		return string(content), nil
+	case "utf-16le", "utf-16":
+		return decodeUTF16(content, binary.LittleEndian)
+	case "utf-16be":
+		return decodeUTF16(content, binary.BigEndian)
+	}
+
+	return "", fmt.Errorf("unsupported file encoding '%s'", encoding)
+}
+
+func decodeUTF16(content []byte, byteOrder binary.ByteOrder) (string, error) {
+	if len(content)%2 != 0 {
+		return "", fmt.Errorf("utf-16 content has an odd number of bytes")
+	}
+
+	units := make([]uint16, len(content)/2)
+	for i := range units {
+		units[i] = byteOrder.Uint16(content[i*2:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// readMigrator reads and decodes the migrator at path
+func readMigrator(config *Config, path string) (string, error) {
+	content, err := readMigratorFile(config, path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read migrator '%s': %w", path, err)
+	}
+
+	source, err := decodeMigrator(content, config.FileEncoding)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode migrator '%s': %w", path, err)
+	}
+
+	return source, nil
+}
+
+// RenderedMigrator is a migrator rendered against the template dictionary
+type RenderedMigrator struct {
+	// SQL is the rendered migrator, as it is executed
+	SQL string
+	// Redacted is the rendered migrator with any secrets masked, safe to print
+	Redacted string
+	// Checksum is the sha256 of the rendered migrator, or of its template source when it uses secrets so that
+	// no trace of them is recorded
+	Checksum string
+	// Role is the role the migrator is executed as, set by the directive "-- evo: role=<name>"
+	Role string
+	// DisableTriggers are the tables whose user triggers are disabled while the migrator executes, set by the
+	// directive "-- evo: disable-triggers=<table>,..."
+	DisableTriggers []string
+	// Copies are the csv files loaded once the migrator has executed, set by "-- evo: copy" directives
+	Copies []CopySpec
+	// Savepoints executes each statement under its own savepoint, set by the directive "-- evo: savepoints"
+	Savepoints bool
+	// BatchSize, when not 0, is the number of statements executed per round trip, set by the directive
+	// "-- evo: batch"
+	BatchSize int
+	// IgnoreErrors are the SQLSTATE codes tolerated from any statement, which then executes one statement at a
+	// time, set by the directive "-- evo: ignore-errors=<code>,..."
+	IgnoreErrors []string
+	// Backfill, when set, executes the migrator in committed chunks of ids, set by the directive
+	// "-- evo: backfill=<table>.<column>"
+	Backfill *BackfillSpec
+}
+
+// lookupSecret returns the value of the secret name, which is read from the EVO_SECRET_ prefixed environment
+// variable of that name
+func lookupSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(secretPrefix + name)
+	if !ok {
+		return "", fmt.Errorf("secret '%s' is not set, it is read from %s%s", name, secretPrefix, name)
+	}
+	return value, nil
+}
+
+// checksum returns the hex encoded sha256 of content
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// includeFile reads the file name, relative to the migrator directory, to be inserted verbatim into a migrator.
+// the contents are not rendered as a template, nor escaped.
+func includeFile(config *Config, name string) (template.HTML, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("included file '%s' must be a relative path within the migrator directory", name)
+	}
+
+	content, err := readMigratorFile(config, filepath.Join(config.Directory, filepath.FromSlash(name)))
+	if err != nil {
+		return "", fmt.Errorf("unable to read included file '%s': %w", name, err)
+	}
+	text, err := decodeMigrator(content, config.FileEncoding)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode included file '%s': %w", name, err)
+	}
+
+	return template.HTML(text), nil
+}
+
+// runValues are the template data which differ on every invocation of evo, so that sql rendered from them can
+// never be rendered identically again
+var runValues = []string{"RunID", "Now"}
+
+// usesRunValues reports whether any template of t refers to one of runValues, as a field, a field of a variable
+// or a string, such as the key given to index
+func usesRunValues(t *template.Template) bool {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil && nodeUsesRunValues(tmpl.Tree.Root) {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeUsesRunValues(node parse.Node) bool {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return false
+		}
+		for _, child := range node.Nodes {
+			if nodeUsesRunValues(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return nodeUsesRunValues(node.Pipe)
+	case *parse.PipeNode:
+		if node == nil {
+			return false
+		}
+		for _, command := range node.Cmds {
+			if nodeUsesRunValues(command) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			if nodeUsesRunValues(arg) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return slices.Contains(runValues, node.Ident[0])
+	case *parse.VariableNode:
+		return len(node.Ident) > 1 && slices.Contains(runValues, node.Ident[1])
+	case *parse.ChainNode:
+		return nodeUsesRunValues(node.Node)
+	case *parse.StringNode:
+		return slices.Contains(runValues, node.Text)
+	case *parse.IfNode:
+		return nodeUsesRunValues(node.Pipe) || nodeUsesRunValues(node.List) || nodeUsesRunValues(node.ElseList)
+	case *parse.RangeNode:
+		return nodeUsesRunValues(node.Pipe) || nodeUsesRunValues(node.List) || nodeUsesRunValues(node.ElseList)
+	case *parse.WithNode:
+		return nodeUsesRunValues(node.Pipe) || nodeUsesRunValues(node.List) || nodeUsesRunValues(node.ElseList)
+	case *parse.TemplateNode:
+		return nodeUsesRunValues(node.Pipe)
+	}
+	return false
+}
+
+// partialOutputLines is how many of the last lines rendered before a template failed are included in its error
+const partialOutputLines = 10
+
+// templateExecError describes the failed execution of the template at path, with the line of source at which
+// it failed and the tail of the output rendered up to that point
+func templateExecError(path string, source string, partial string, err error) error {
+	message := fmt.Sprintf("error executing template '%s'", path)
+
+	// the location is reported as "template: <name>:<line>:<column>: ..."
+	match := regexp.MustCompile(regexp.QuoteMeta(filepath.Base(path)) + `:(\d+):`).FindStringSubmatch(err.Error())
+	if match != nil {
+		line, _ := strconv.Atoi(match[1])
+		lines := strings.Split(source, "\n")
+		if line >= 1 && line <= len(lines) {
+			message += fmt.Sprintf(" at line %d (%s)", line, strings.TrimSpace(lines[line-1]))
+		}
+	}
+
+	partialLines := strings.Split(strings.TrimRight(partial, "\n"), "\n")
+	if len(partialLines) > partialOutputLines {
+		partialLines = partialLines[len(partialLines)-partialOutputLines:]
+	}
+	if len(strings.TrimSpace(partial)) > 0 {
+		return fmt.Errorf("%s: %w\nrendered before the failure:\n%s", message, err, strings.Join(partialLines, "\n"))
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
+
+// renderMigrator parses the migrator at path as a template and renders it against data, with .MigratorName
+// set to migName.  secrets are available through the function {{ secret "NAME" }}, whether another migrator
+// had been applied before the run through {{ applied "NAME" }}, and the contents of other files through
+// {{ include "PATH" }}.
+func renderMigrator(config *Config, path string, migName string, data map[string]any) (*RenderedMigrator, error) {
+	source, err := readMigrator(config, path)
+	if err != nil {
+		return nil, err
+	}
+
+	migratorData := make(map[string]any, len(data)+1)
+	for key, value := range data {
+		migratorData[key] = value
+	}
+	migratorData["MigratorName"] = migName
+
+	usesSecrets := false
+	usesApplied := false
+	redact := false
+	var secretValues []string
+	funcs := template.FuncMap{
+		"secret": func(name string) (string, error) {
+			usesSecrets = true
+			if redact {
+				return redactedSecret, nil
+			}
+			value, err := lookupSecret(name)
+			secretValues = append(secretValues, value)
+			return value, err
+		},
+		"include": func(name string) (template.HTML, error) {
+			return includeFile(config, name)
+		},
+		"applied": func(name string) (bool, error) {
+			usesApplied = true
+			key, err := migratorKey(config, name)
+			if err != nil {
+				return false, err
+			}
+			_, ok := config.AppliedBefore[key]
+			return ok, nil
+		},
+	}
+
+	t, err := template.New(filepath.Base(path)).Funcs(funcs).Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse migrator as template '%s': %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, migratorData)
+	if err != nil {
+		// the output may already hold secrets, which are masked as they would be for display
+		partial := buf.String()
+		for _, value := range secretValues {
+			if len(value) > 0 {
+				partial = strings.ReplaceAll(partial, value, redactedSecret)
+			}
+		}
+		return nil, templateExecError(path, source, partial, err)
+	}
+
+	rendered := &RenderedMigrator{
+		SQL:      buf.String(),
+		Redacted: buf.String(),
+		Checksum: checksum(buf.String()),
+		Role:     parseDirectives(buf.String())[DirectiveRole],
+	}
+	rendered.DisableTriggers = parseDisableTriggers(rendered.SQL)
+	rendered.Savepoints = parseSavepoints(rendered.SQL)
+	rendered.BatchSize, err = parseBatchSize(config, rendered.SQL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid batch directive in migrator '%s': %w", path, err)
+	}
+	if rendered.Savepoints && rendered.BatchSize > 0 {
+		return nil, fmt.Errorf("migrator '%s' may not use both savepoints and batches", path)
+	}
+	rendered.IgnoreErrors, err = parseIgnoreErrors(rendered.SQL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignore-errors directive in migrator '%s': %w", path, err)
+	}
+	if len(rendered.IgnoreErrors) > 0 && rendered.BatchSize > 0 {
+		return nil, fmt.Errorf("migrator '%s' may not ignore errors and use batches", path)
+	}
+	rendered.Backfill, err = parseBackfill(rendered.SQL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backfill directive in migrator '%s': %w", path, err)
+	}
+	rendered.Copies, err = parseCopies(path, rendered.SQL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid copy directive in migrator '%s': %w", path, err)
+	}
+	// each chunk of a backfill is executed on its own, as the user, outside of what the other directives provide
+	if rendered.Backfill != nil && (rendered.Savepoints || rendered.BatchSize > 0 || len(rendered.IgnoreErrors) > 0 ||
+		len(rendered.Role) > 0 || len(rendered.DisableTriggers) > 0 || len(rendered.Copies) > 0) {
+		return nil, fmt.Errorf("migrator '%s' is a backfill, which may not use savepoints, batches, ignore errors, a role, disabled triggers or copies", path)
+	}
+	if usesApplied || usesRunValues(t) {
+		// the output depends on the migrators applied before the run, or on the run itself, which a later status
+		// or check cannot reproduce, so the template is checksummed instead
+		rendered.Checksum = checksum(source)
+	}
+	if !usesSecrets {
+		return rendered, nil
+	}
+
+	// render again with the secrets masked, for display
+	redact = true
+	buf.Reset()
+	err = t.Execute(&buf, migratorData)
+	if err != nil {
+		return nil, fmt.Errorf("error executing template '%s': %w", path, err)
+	}
+	rendered.Redacted = buf.String()
+	rendered.Checksum = checksum(source)
+
+	return rendered, nil
+}
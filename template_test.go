@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestTemplateVarsFilesMerge(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "base.json")
+	err := os.WriteFile(basePath, []byte(`{"owner": "base", "tables": {"users": "users", "orders": "orders"}, "EVO_TEST_VAR": "file"}`), 0o644)
+	assert.NoError(t, err)
+	envPath := filepath.Join(dir, "staging.yaml")
+	err = os.WriteFile(envPath, []byte("owner: staging\ntables:\n  orders: staging_orders\n"), 0o644)
+	assert.NoError(t, err)
+
+	t.Setenv("EVO_TEST_VAR", "env")
+
+	config := &Config{
+		TemplateVarsFiles:     splitList(basePath + "," + envPath),
+		TemplateEnvPrecedence: EnvPrecedenceHigh,
+	}
+	data, err := getTemplateData(config)
+	assert.NoError(t, err)
+
+	// later files override earlier ones, nested maps are merged rather than replaced
+	assert.Equal(t, "staging", data["owner"])
+	assert.Equal(t, map[string]any{"users": "users", "orders": "staging_orders"}, data["tables"])
+	assert.Equal(t, "env", data["EVO_TEST_VAR"])
+
+	config.TemplateEnvPrecedence = EnvPrecedenceLow
+	data, err = getTemplateData(config)
+	assert.NoError(t, err)
+	assert.Equal(t, "file", data["EVO_TEST_VAR"])
+}
+
+func TestRenderMigratorEncoding(t *testing.T) {
+	dir := t.TempDir()
+	data := map[string]any{"table": "users"}
+	config := &Config{Directory: dir}
+
+	bomPath := filepath.Join(dir, "0001_bom.sql")
+	err := os.WriteFile(bomPath, append([]byte{0xEF, 0xBB, 0xBF}, []byte("CREATE TABLE {{ .table }} (id INT);")...), 0o644)
+	assert.NoError(t, err)
+	rendered, err := renderMigrator(config, bomPath, filepath.Base(bomPath), data)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE users (id INT);", rendered.SQL)
+
+	utf16Path := filepath.Join(dir, "0002_utf16.sql")
+	content := []byte{0xFF, 0xFE}
+	for _, r := range "DROP TABLE {{ .table }};" {
+		content = append(content, byte(r), 0)
+	}
+	err = os.WriteFile(utf16Path, content, 0o644)
+	assert.NoError(t, err)
+	rendered, err = renderMigrator(config, utf16Path, filepath.Base(utf16Path), data)
+	assert.NoError(t, err)
+	assert.Equal(t, "DROP TABLE users;", rendered.SQL)
+
+	utf16BEPath := filepath.Join(dir, "0003_utf16be.sql")
+	content = nil
+	for _, r := range "SELECT 1;" {
+		content = append(content, 0, byte(r))
+	}
+	err = os.WriteFile(utf16BEPath, content, 0o644)
+	assert.NoError(t, err)
+	config.FileEncoding = "utf-16be"
+	rendered, err = renderMigrator(config, utf16BEPath, filepath.Base(utf16BEPath), data)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT 1;", rendered.SQL)
+}
+
+func TestRenderMigratorContext(t *testing.T) {
+	dir := t.TempDir()
+	writeMigrators(t, dir, map[string]string{
+		"0001_first.sql":  "-- {{ .MigratorName }}",
+		"0002_second.sql": "-- {{ .MigratorName }}",
+		"0003_third.sql":  "-- {{ .RunID }} {{ .Now.Year }}",
+	})
+
+	config := &Config{Directory: dir}
+	data, err := getTemplateData(config)
+	assert.NoError(t, err)
+
+	matches, err := findMigrators(config)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 3)
+	for _, match := range matches[:2] {
+		migName := migratorName(config, match)
+		rendered, err := renderMigrator(config, match, migName, data)
+		assert.NoError(t, err)
+		assert.Equal(t, "-- "+migName, rendered.SQL)
+	}
+
+	// the run id and timestamp are fixed for the run
+	rendered, err := renderMigrator(config, matches[2], migratorName(config, matches[2]), data)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("-- %s %d", runID, data["Now"].(time.Time).Year()), rendered.SQL)
+
+	// but differ between runs, so the template is checksummed rather than the sql
+	data["RunID"] = "another-run"
+	again, err := renderMigrator(config, matches[2], migratorName(config, matches[2]), data)
+	assert.NoError(t, err)
+	assert.NotEqual(t, rendered.SQL, again.SQL)
+	assert.Equal(t, rendered.Checksum, again.Checksum)
+}
+
+func TestUsesRunValues(t *testing.T) {
+	for source, expected := range map[string]bool{
+		"SELECT '{{ .RunID }}';":                            true,
+		"SELECT {{ .Now.Unix }};":                           true,
+		"{{ with $x := 1 }}SELECT '{{ $.Now }}';{{ end }}":  true,
+		`SELECT '{{ index . "RunID" }}';`:                   true,
+		"{{ if .Database }}SELECT '{{ .RunID }}';{{ end }}": true,
+		"SELECT '{{ .Database }}';":                         false,
+		"SELECT 1;":                                         false,
+	} {
+		tmpl, err := template.New("migrator").Parse(source)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, usesRunValues(tmpl), source)
+	}
+}
+
+func TestRenderMigratorDatabase(t *testing.T) {
+	dir := t.TempDir()
+	writeMigrators(t, dir, map[string]string{
+		"0001_default.sql": "ALTER TABLE settings ALTER COLUMN tenant SET DEFAULT '{{ .Database }}';",
+	})
+
+	config := &Config{Directory: dir, Databases: []string{"tenant_a", "tenant_b"}}
+	rendered := map[string]string{}
+	mutex := sync.Mutex{}
+	results := forEachDatabase(config, func(config *Config) error {
+		data, err := getTemplateData(config)
+		if err != nil {
+			return err
+		}
+		migrator, err := renderMigrator(config, filepath.Join(dir, "0001_default.sql"), "0001_default.sql", data)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		rendered[config.Database] = migrator.SQL
+		return nil
+	})
+	assert.Len(t, results, 2)
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+	}
+	assert.Equal(t, map[string]string{
+		"tenant_a": "ALTER TABLE settings ALTER COLUMN tenant SET DEFAULT 'tenant_a';",
+		"tenant_b": "ALTER TABLE settings ALTER COLUMN tenant SET DEFAULT 'tenant_b';",
+	}, rendered)
+}
+
+func TestTemplateAllow(t *testing.T) {
+	dir := t.TempDir()
+	writeMigrators(t, dir, map[string]string{
+		"0001_allowed.sql": "-- {{ .EVO_TEST_ALLOWED }}:{{ .EVO_TEST_WITHHELD }}",
+	})
+	t.Setenv("EVO_TEST_ALLOWED", "allowed")
+	t.Setenv("EVO_TEST_WITHHELD", "withheld")
+
+	config := &Config{
+		Directory:     dir,
+		TemplateAllow: splitList("EVO_TEST_ALLOWED,EVO_TEST_UNSET"),
+	}
+	data, err := getTemplateData(config)
+	assert.NoError(t, err)
+	assert.Equal(t, "allowed", data["EVO_TEST_ALLOWED"])
+	assert.NotContains(t, data, "EVO_TEST_WITHHELD")
+	assert.NotContains(t, data, "EVO_TEST_UNSET")
+	assert.NotContains(t, data, "PATH")
+
+	// withheld variables render as missing keys
+	path := filepath.Join(dir, "0001_allowed.sql")
+	rendered, err := renderMigrator(config, path, "0001_allowed.sql", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "-- allowed:", rendered.SQL)
+}
+
+func TestRenderMigratorSecret(t *testing.T) {
+	dir := t.TempDir()
+	source := "INSERT INTO keys (key) VALUES ('{{ secret \"ENCRYPTION_KEY\" }}');"
+	writeMigrators(t, dir, map[string]string{
+		"0001_seed_key.sql": source,
+		"0002_missing.sql":  "-- {{ secret \"UNSET\" }}",
+	})
+	t.Setenv("EVO_SECRET_ENCRYPTION_KEY", "s3cr3t")
+
+	config := &Config{Directory: dir}
+	data, err := getTemplateData(config)
+	assert.NoError(t, err)
+	assert.NotContains(t, data, "EVO_SECRET_ENCRYPTION_KEY")
+
+	rendered, err := renderMigrator(config, filepath.Join(dir, "0001_seed_key.sql"), "0001_seed_key.sql", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO keys (key) VALUES ('s3cr3t');", rendered.SQL)
+	assert.Equal(t, "INSERT INTO keys (key) VALUES ('[redacted]');", rendered.Redacted)
+	// the checksum is taken over the template, not the rendered secret
+	assert.Equal(t, checksum(source), rendered.Checksum)
+
+	_, err = renderMigrator(config, filepath.Join(dir, "0002_missing.sql"), "0002_missing.sql", data)
+	assert.ErrorContains(t, err, "EVO_SECRET_UNSET")
+}
+
+func TestRenderMigratorExecError(t *testing.T) {
+	dir := t.TempDir()
+	writeMigrators(t, dir, map[string]string{
+		"0001_seed.sql": "INSERT INTO keys (key) VALUES ('{{ secret \"KEY\" }}');\nINSERT INTO things (id) VALUES (1);\nINSERT INTO things (id) VALUES ({{ secret \"UNSET\" }});\nINSERT INTO things (id) VALUES (3);\n",
+	})
+	t.Setenv("EVO_SECRET_KEY", "s3cr3t")
+
+	config := &Config{Directory: dir}
+	data, err := getTemplateData(config)
+	assert.NoError(t, err)
+
+	_, err = renderMigrator(config, filepath.Join(dir, "0001_seed.sql"), "0001_seed.sql", data)
+	assert.ErrorContains(t, err, `at line 3 (INSERT INTO things (id) VALUES ({{ secret "UNSET" }});)`)
+	assert.ErrorContains(t, err, "rendered before the failure:\nINSERT INTO keys (key) VALUES ('[redacted]');\nINSERT INTO things (id) VALUES (1);\nINSERT INTO things (id) VALUES (")
+	assert.NotContains(t, err.Error(), "s3cr3t")
+}
+
+func TestRenderMigratorInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeMigrators(t, dir, map[string]string{
+		"0001_grants.sql":    "CREATE TABLE things (id INT);\n{{ include \"snippets/grants.sql\" }}",
+		"0002_traversal.sql": "{{ include \"../outside.sql\" }}",
+		"0003_absolute.sql":  "{{ include \"/etc/passwd\" }}",
+	})
+	err := os.MkdirAll(filepath.Join(dir, "snippets"), 0o755)
+	assert.NoError(t, err)
+	// included verbatim, neither rendered nor escaped
+	err = os.WriteFile(filepath.Join(dir, "snippets", "grants.sql"), []byte("GRANT SELECT ON things TO \"readers\"; -- {{ not a template }} <>"), 0o644)
+	assert.NoError(t, err)
+
+	config := &Config{Directory: dir}
+	data, err := getTemplateData(config)
+	assert.NoError(t, err)
+
+	rendered, err := renderMigrator(config, filepath.Join(dir, "0001_grants.sql"), "0001_grants.sql", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE things (id INT);\nGRANT SELECT ON things TO \"readers\"; -- {{ not a template }} <>", rendered.SQL)
+
+	_, err = renderMigrator(config, filepath.Join(dir, "0002_traversal.sql"), "0002_traversal.sql", data)
+	assert.ErrorContains(t, err, "must be a relative path within the migrator directory")
+	_, err = renderMigrator(config, filepath.Join(dir, "0003_absolute.sql"), "0003_absolute.sql", data)
+	assert.ErrorContains(t, err, "must be a relative path within the migrator directory")
+}
+
+func TestRenderMigratorApplied(t *testing.T) {
+	dir := t.TempDir()
+	writeMigrators(t, dir, map[string]string{
+		"0002_branch.sql": `{{ if applied "0001_first.sql" }}SELECT 1;{{ else }}SELECT 0;{{ end }}`,
+	})
+	path := filepath.Join(dir, "0002_branch.sql")
+
+	config := &Config{Directory: dir}
+	rendered, err := renderMigrator(config, path, "0002_branch.sql", map[string]any{})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT 0;", rendered.SQL)
+	pendingChecksum := rendered.Checksum
+
+	config.AppliedBefore = map[string]struct{}{"0001_first.sql": {}}
+	rendered, err = renderMigrator(config, path, "0002_branch.sql", map[string]any{})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT 1;", rendered.SQL)
+
+	// the checksum does not depend on the state the migrator was rendered against
+	assert.Equal(t, pendingChecksum, rendered.Checksum)
+
+	// when tracking by version, migrators are identified by version
+	config.TrackBy = TrackByVersion
+	config.AppliedBefore = map[string]struct{}{"1": {}}
+	rendered, err = renderMigrator(config, path, "0002_branch.sql", map[string]any{})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT 1;", rendered.SQL)
+}
+
+func TestAppliedTemplateFunction(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE branches (name TEXT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// 0002 is applied during the run, but 0003 sees the state from before it
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_second.sql": `INSERT INTO branches VALUES ('{{ if applied "0001_first.sql" }}first applied{{ else }}first pending{{ end }}');`,
+		"0003_third.sql":  `INSERT INTO branches VALUES ('{{ if applied "0002_second.sql" }}second applied{{ else }}second pending{{ end }}');`,
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	standardConn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = standardConn.Close(context.Background())
+	}()
+
+	rows, err := standardConn.Query(context.Background(), "SELECT name FROM branches ORDER BY name")
+	assert.NoError(t, err)
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first applied", "second pending"}, names)
+
+	// rendered again without the state from before the run, the migrators are not taken for drifted
+	drifted, err := findDrift(standardConn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, drifted)
+}
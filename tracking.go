@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// migratorTableUpgrades are columns added to evo_mg after its original definition, which are added to existing
+// tables as required
+var migratorTableUpgrades = []struct {
+	column     string
+	definition string
+}{
+	{column: "release", definition: "TEXT"},
+	{column: "checksum", definition: "TEXT"},
+	{column: "run_id", definition: "TEXT"},
+}
+
+// now reads the configured clock, defaulting to the system clock, as a utc time of microsecond precision, the
+// precision at which postgres stores it
+func now(config *Config) time.Time {
+	clock := config.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	return clock().UTC().Truncate(time.Microsecond)
+}
+
+// defaultMigrationSchema holds the evo_mg table unless EVO_MIGRATION_SCHEMA names another
+const defaultMigrationSchema string = "public"
+
+// migrationSchema returns the schema holding the evo_mg table
+func migrationSchema(config *Config) string {
+	if len(config.MigrationSchema) == 0 {
+		return defaultMigrationSchema
+	}
+	return config.MigrationSchema
+}
+
+// migratorTable returns the schema qualified name of the evo_mg table
+func migratorTable(config *Config) string {
+	return pgx.Identifier{migrationSchema(config), "evo_mg"}.Sanitize()
+}
+
+// ensureSchema creates the schema if it does not already exist.  existence is checked first, as creating a schema
+// requires the CREATE privilege on the database even when it already exists.
+func ensureSchema(conn *pgx.Conn, schema string) error {
+	var exists bool
+	err := conn.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)", schema).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("unable to query for schema '%s': %w", schema, err)
+	}
+	if exists {
+		return nil
+	}
+
+	logf("creating schema '%s'\n", schema)
+	_, err = conn.Exec(context.Background(), fmt.Sprintf("CREATE SCHEMA %s", pgx.Identifier{schema}.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("unable to create schema '%s': %w", schema, err)
+	}
+
+	return nil
+}
+
+// findMigratorTableSchemas returns every schema in which an evo_mg table exists
+func findMigratorTableSchemas(conn *pgx.Conn) ([]string, error) {
+	rows, err := conn.Query(context.Background(), "SELECT n.nspname FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace WHERE c.relname = 'evo_mg' AND c.relkind IN ('r', 'p') ORDER BY n.nspname")
+	if err != nil {
+		return nil, fmt.Errorf("unable to search for evo migrator tables: %w", err)
+	}
+
+	schemas, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("unable to search for evo migrator tables: %w", err)
+	}
+
+	return schemas, nil
+}
+
+// hasMigratorColumn reports whether the existing evo_mg table has the named column
+func hasMigratorColumn(conn *pgx.Conn, config *Config, column string) (bool, error) {
+	var exists bool
+	row := conn.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_attribute WHERE attrelid = to_regclass($1) AND attname = $2 AND attnum > 0 AND NOT attisdropped)", migratorTable(config), column)
+	err := row.Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("unable to interogate evo migrator table columns: %w", err)
+	}
+
+	return exists, nil
+}
+
+// upgradeMigratorTable adds any columns missing from an evo_mg table created by an earlier version of evo
+func upgradeMigratorTable(conn *pgx.Conn, config *Config) error {
+	for _, upgrade := range migratorTableUpgrades {
+		exists, err := hasMigratorColumn(conn, config, upgrade.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		logf("adding column '%s' to evo migration table\n", upgrade.column)
+		_, err = conn.Exec(context.Background(), fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", migratorTable(config), upgrade.column, upgrade.definition))
+		if err != nil {
+			return fmt.Errorf("unable to add column '%s' to evo migration table: %w", upgrade.column, err)
+		}
+	}
+
+	return nil
+}
+
+// nullable maps an empty string to NULL
+func nullable(value string) any {
+	if len(value) == 0 {
+		return nil
+	}
+	return value
+}
+
+// recordMigrator inserts the row marking a migrator as applied
+func recordMigrator(conn Executable, config *Config, migName string, checksum string) error {
+	// created_at is set explicitly, rather than by the column's default, so that it comes from the configured
+	// clock rather than the server's
+	columns := []string{"migrator", "created_at", "release", "checksum", "run_id"}
+	values := []any{migName, now(config), nullable(config.Release), nullable(checksum), getRunID(config)}
+
+	if config.TrackBy == TrackByVersion {
+		version, err := parseVersion(migName)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, "version")
+		values = append(values, version)
+	}
+
+	placeholders := make([]string, len(values))
+	for i := range values {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", migratorTable(config), strings.Join(columns, ", "), strings.Join(placeholders, ", ")), values...)
+	return err
+}
+
+// ensureTableOwner transfers ownership of table to owner, unless it is already owned by it.  the connected role
+// must be a member of owner, and owner must have the CREATE privilege on the table's schema.
+func ensureTableOwner(conn *pgx.Conn, table string, owner string) error {
+	var currentOwner string
+	err := conn.QueryRow(context.Background(), "SELECT pg_get_userbyid(relowner) FROM pg_class WHERE oid = $1::regclass", table).Scan(&currentOwner)
+	if err != nil {
+		return fmt.Errorf("unable to query owner of table %s: %w", table, err)
+	}
+	if currentOwner == owner {
+		return nil
+	}
+
+	logf("changing owner of table %s from '%s' to '%s'\n", table, currentOwner, owner)
+	_, err = conn.Exec(context.Background(), fmt.Sprintf("ALTER TABLE %s OWNER TO %s", table, pgx.Identifier{owner}.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("unable to change owner of table %s to '%s': %w", table, owner, err)
+	}
+
+	return nil
+}
+
+// unrecordMigrator removes the record of an applied migrator, by name or by version depending on the tracking mode,
+// along with the objects recorded for it
+func unrecordMigrator(conn Executable, config *Config, migName string) error {
+	if config.RecordObjects {
+		err := unrecordObjects(conn, config, migName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.TrackBy == TrackByVersion {
+		version, err := parseVersion(migName)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Exec(context.Background(), fmt.Sprintf("DELETE FROM %s WHERE version = $1", migratorTable(config)), version)
+		return err
+	}
+
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("DELETE FROM %s WHERE migrator = $1", migratorTable(config)), migName)
+	return err
+}
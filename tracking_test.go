@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestReleaseLabel(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+	})
+	config.Release = "v1.0.0"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+	config.Release = "v1.1.0"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	records, err := getMigrationRecords(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "0001_first.sql", records[0].Migrator)
+	assert.Equal(t, "v1.0.0", *records[0].Release)
+	assert.Equal(t, "0002_second.sql", records[1].Migrator)
+	assert.Equal(t, "v1.1.0", *records[1].Release)
+
+	status, err := getMigrationStatus(conn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"0001_first.sql": "v1.0.0", "0002_second.sql": "v1.1.0"}, status.Releases)
+}
+
+func TestRunID(t *testing.T) {
+	// without EVO_RUN_ID, each invocation generates an identifier of its own
+	assert.NotEqual(t, newRunID(), newRunID())
+	assert.Equal(t, runID, getRunID(&Config{}))
+	assert.Equal(t, "build-42", getRunID(&Config{RunID: "build-42"}))
+
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql":  "CREATE TABLE first (id INT);",
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// a later invocation, here identified by EVO_RUN_ID
+	writeMigrators(t, config.Directory, map[string]string{
+		"0003_third.sql": "CREATE TABLE third (id INT);",
+	})
+	config.RunID = "build-42"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	records, err := getMigrationRecords(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, runID, *records[0].RunID)
+	assert.Equal(t, runID, *records[1].RunID)
+	assert.Equal(t, "build-42", *records[2].RunID)
+}
+
+func TestClock(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	// a clock in another zone, with more precision than postgres keeps
+	fixed := time.Date(2024, 3, 1, 9, 30, 15, 123456789, time.FixedZone("UTC+5", 5*60*60))
+	config.Clock = func() time.Time {
+		return fixed
+	}
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	records, err := getMigrationRecords(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.True(t, time.Date(2024, 3, 1, 4, 30, 15, 123456000, time.UTC).Equal(records[0].CreatedAt))
+	assert.Equal(t, time.Date(2024, 3, 1, 4, 30, 15, 123456000, time.UTC), now(config))
+}
+
+func TestUpgradeMigratorTable(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	// simulate a table created before any upgrade columns existed
+	_, err = conn.Exec(context.Background(), "DROP TABLE evo_mg; CREATE TABLE evo_mg (migrator TEXT PRIMARY KEY, created_at TIMESTAMPTZ DEFAULT NOW())")
+	assert.NoError(t, err)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+	})
+	config.Release = "v2.0.0"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	for _, upgrade := range migratorTableUpgrades {
+		exists, err := hasMigratorColumn(conn, config, upgrade.column)
+		assert.NoError(t, err)
+		assert.True(t, exists, upgrade.column)
+	}
+}
+
+func TestMigratorTableInOtherSchema(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	_, err = adminConn.Exec(context.Background(), "CREATE SCHEMA legacy AUTHORIZATION "+config.Username)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	// history lives only in the legacy schema
+	_, err = conn.Exec(context.Background(), "DROP TABLE evo_mg; CREATE TABLE legacy.evo_mg (migrator TEXT PRIMARY KEY, created_at TIMESTAMPTZ DEFAULT NOW()); INSERT INTO legacy.evo_mg (migrator) VALUES ('0001_first.sql')")
+	assert.NoError(t, err)
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql":  "CREATE TABLE first (id INT);",
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "schema 'legacy'")
+
+	exists, err := migratorTableExists(conn, config)
+	assert.NoError(t, err)
+	assert.False(t, exists, "history must not be forked into the public schema")
+
+	config.MigrationSchema = "legacy"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	var firstExists, secondExists bool
+	err = conn.QueryRow(context.Background(), "SELECT to_regclass('public.first') IS NOT NULL, to_regclass('public.second') IS NOT NULL").Scan(&firstExists, &secondExists)
+	assert.NoError(t, err)
+	assert.False(t, firstExists)
+	assert.True(t, secondExists)
+}
+
+func TestSystemTableOwner(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	for _, statement := range []string{
+		"CREATE ROLE evo_owner",
+		"GRANT evo_owner TO " + Username,
+		"GRANT CREATE ON SCHEMA public TO evo_owner",
+	} {
+		_, err = adminConn.Exec(context.Background(), statement)
+		assert.NoError(t, err)
+	}
+
+	config.SystemTableOwner = "evo_owner"
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	var owner string
+	err = adminConn.QueryRow(context.Background(), "SELECT tableowner FROM pg_tables WHERE schemaname = 'public' AND tablename = 'evo_mg'").Scan(&owner)
+	assert.NoError(t, err)
+	assert.Equal(t, "evo_owner", owner)
+
+	postgresConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = postgresConn.Close(context.Background())
+	}()
+	err = postgresConn.QueryRow(context.Background(), "SELECT tableowner FROM pg_tables WHERE tablename = 'evo_advisory_locks'").Scan(&owner)
+	assert.NoError(t, err)
+	assert.Equal(t, "evo_owner", owner)
+
+	// the user retains access to evo_mg through its membership of the owner
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+}
+
+func TestMigratorTableIgnoresSearchPath(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	_, err = adminConn.Exec(context.Background(), "CREATE SCHEMA scratch AUTHORIZATION "+config.Username)
+	assert.NoError(t, err)
+	_, err = adminConn.Exec(context.Background(), fmt.Sprintf("ALTER ROLE %s SET search_path TO scratch, public", pgx.Identifier{config.Username}.Sanitize()))
+	assert.NoError(t, err)
+
+	// the user's search_path puts scratch first, the migration table must still land in the configured schema
+	config.MigrationSchema = "app"
+	_, err = adminConn.Exec(context.Background(), "DROP TABLE public.evo_mg")
+	assert.NoError(t, err)
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql": "CREATE TABLE first (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// a second run finds the table rather than attempting to create it again
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	var inApp, inScratch, inPublic bool
+	err = adminConn.QueryRow(context.Background(), "SELECT to_regclass('app.evo_mg') IS NOT NULL, to_regclass('scratch.evo_mg') IS NOT NULL, to_regclass('public.evo_mg') IS NOT NULL").Scan(&inApp, &inScratch, &inPublic)
+	assert.NoError(t, err)
+	assert.True(t, inApp)
+	assert.False(t, inScratch)
+	assert.False(t, inPublic)
+
+	var count int
+	err = adminConn.QueryRow(context.Background(), "SELECT count(*) FROM app.evo_mg").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
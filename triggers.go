@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DirectiveDisableTriggers lists the tables whose user triggers are disabled while a migrator executes, e.g.
+// "-- evo: disable-triggers=events,audit.log"
+const DirectiveDisableTriggers string = "disable-triggers"
+
+// parseDisableTriggers extracts the tables listed by the disable-triggers directive of a migrator
+func parseDisableTriggers(source string) []string {
+	var tables []string
+	for _, table := range strings.Split(parseDirectives(source)[DirectiveDisableTriggers], ",") {
+		table = strings.TrimSpace(table)
+		if len(table) > 0 {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// setTriggers enables or disables the user triggers of a table, which requires ownership of it
+func setTriggers(conn Executable, config *Config, table string, enable bool) error {
+	action := "DISABLE"
+	if enable {
+		action = "ENABLE"
+	}
+
+	_, err := conn.Exec(context.Background(), fmt.Sprintf("ALTER TABLE %s %s TRIGGER USER", pgx.Identifier(strings.Split(table, ".")).Sanitize(), action))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42501" {
+		return fmt.Errorf("unable to %s triggers on table '%s', user '%s' (or the migrator's role) must own it: %w", strings.ToLower(action), table, config.Username, err)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to %s triggers on table '%s': %w", strings.ToLower(action), table, err)
+	}
+	return nil
+}
+
+// withTriggersDisabled runs fn with the user triggers of tables disabled, and enables them again afterwards.
+// within a transaction, a failure leaves the triggers to be restored by the rollback.
+func withTriggersDisabled(conn Executable, config *Config, tables []string, fn func() error) error {
+	for i, table := range tables {
+		err := setTriggers(conn, config, table, false)
+		if err != nil {
+			for _, disabled := range tables[:i] {
+				_ = setTriggers(conn, config, disabled, true)
+			}
+			return err
+		}
+	}
+
+	err := fn()
+	if err != nil {
+		for _, table := range tables {
+			_ = setTriggers(conn, config, table, true)
+		}
+		return err
+	}
+
+	for _, table := range tables {
+		err = setTriggers(conn, config, table, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
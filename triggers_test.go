@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseDisableTriggers(t *testing.T) {
+	assert.Equal(t, []string{"events", "audit.log"}, parseDisableTriggers("-- evo: disable-triggers=events,audit.log\nUPDATE events SET seen = true;"))
+	assert.Empty(t, parseDisableTriggers("UPDATE events SET seen = true;"))
+}
+
+func TestDisableTriggers(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_events.sql": `CREATE TABLE events (id INT);
+CREATE FUNCTION reject() RETURNS trigger AS $$ BEGIN RAISE EXCEPTION 'trigger fired'; END $$ LANGUAGE plpgsql;
+CREATE TRIGGER reject_events BEFORE INSERT ON events FOR EACH ROW EXECUTE FUNCTION reject();`,
+		"0002_backfill.sql": "-- evo: disable-triggers=events\nINSERT INTO events SELECT generate_series(1, 10);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	// the backfill ran without the trigger, which is enabled again afterwards
+	var count int
+	err = conn.QueryRow(context.Background(), "SELECT count(*) FROM events").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, count)
+
+	var enabled string
+	err = conn.QueryRow(context.Background(), "SELECT tgenabled FROM pg_trigger WHERE tgname = 'reject_events'").Scan(&enabled)
+	assert.NoError(t, err)
+	assert.Equal(t, "O", enabled)
+
+	// without ownership of the table, the migrator fails clearly
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl(config.Database))
+	assert.NoError(t, err)
+	_, err = adminConn.Exec(context.Background(), "CREATE TABLE foreign_events (id INT); GRANT INSERT ON foreign_events TO "+config.Username)
+	assert.NoError(t, err)
+	_ = adminConn.Close(context.Background())
+
+	writeMigrators(t, config.Directory, map[string]string{
+		"0003_foreign.sql": "-- evo: disable-triggers=foreign_events\nINSERT INTO foreign_events VALUES (1);",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "must own it")
+}
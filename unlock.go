@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LockHolder describes the session holding the lock of a database
+type LockHolder struct {
+	PID         int32
+	State       string
+	ClientAddr  string
+	Application string
+	// Idle is how long ago the session last touched the server
+	Idle time.Duration
+}
+
+// lockHolderColumns are selected from pg_stat_activity, aliased as a, to describe a lock holder
+const lockHolderColumns string = `a.pid, coalesce(a.state, ''), coalesce(host(a.client_addr), 'local'), coalesce(a.application_name, ''),
+	extract(epoch FROM now() - coalesce(a.state_change, a.backend_start))::float8`
+
+// getLockHolder returns the session holding the lock of the configured database, or nil when it is free.  a row
+// of the lock table records the transaction which locked it, in xmax, which is still running only while held.
+func getLockHolder(conn *pgx.Conn, config *Config) (*LockHolder, error) {
+	var query string
+	var key any = lockName(config)
+	if config.LockMode == LockModeAdvisory {
+		key = advisoryLockKey(config)
+		// pg_advisory_lock(bigint) splits its key across classid and objid
+		query = fmt.Sprintf(`SELECT %s FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+			WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+			AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+			AND ((l.classid::bigint << 32) | l.objid::bigint) = $1`, lockHolderColumns)
+	} else {
+		var exists bool
+		err := conn.QueryRow(context.Background(), "SELECT to_regclass($1) IS NOT NULL", lockTableName(config)).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("unable to query for the lock table: %w", err)
+		}
+		if !exists {
+			return nil, nil
+		}
+
+		query = fmt.Sprintf(`SELECT %s FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+			WHERE l.locktype = 'transactionid' AND l.mode = 'ExclusiveLock' AND l.granted
+			AND l.transactionid = (SELECT xmax FROM %s WHERE name = $1)`, lockHolderColumns, lockTableName(config))
+	}
+
+	var holder LockHolder
+	var idleSeconds float64
+	err := conn.QueryRow(context.Background(), query, key).Scan(&holder.PID, &holder.State, &holder.ClientAddr, &holder.Application, &idleSeconds)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to query the holder of the lock: %w", err)
+	}
+	holder.Idle = time.Duration(idleSeconds * float64(time.Second))
+
+	return &holder, nil
+}
+
+// clearLock terminates the session holding the lock of the configured database, provided it is orphaned: idle
+// for longer than staleAfter, whereas a live evo touches the server every lockKeepaliveInterval.  a lock held by a
+// live session is refused.  returns the holder, or nil when the lock was already free.
+func clearLock(conn *pgx.Conn, config *Config, staleAfter time.Duration) (*LockHolder, error) {
+	holder, err := getLockHolder(conn, config)
+	if err != nil || holder == nil {
+		return nil, err
+	}
+
+	if holder.State == "active" || holder.Idle < staleAfter {
+		return holder, fmt.Errorf("lock for database '%s' is held by a live session (pid %d, %s from %s, last active %s ago), refusing to clear it",
+			config.Database, holder.PID, holder.State, holder.ClientAddr, holder.Idle.Round(time.Second))
+	}
+
+	var terminated bool
+	err = conn.QueryRow(context.Background(), "SELECT pg_terminate_backend($1)", holder.PID).Scan(&terminated)
+	if err != nil {
+		return holder, fmt.Errorf("unable to terminate session %d holding the lock: %w", holder.PID, err)
+	}
+	if !terminated {
+		return holder, fmt.Errorf("session %d holding the lock could not be terminated", holder.PID)
+	}
+
+	return holder, nil
+}
+
+func runUnlock(config *Config, args []string) error {
+	flags := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	staleAfter := flags.Duration("stale-after", 4*lockKeepaliveInterval, "how long the holding session must have been idle to be considered orphaned")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	conn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl(maintenanceDatabase))
+	if err != nil {
+		return connectError(fmt.Errorf("unable to connect to database: %w", err))
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	holder, err := clearLock(conn, config, *staleAfter)
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		logf("lock for database '%s' is free\n", config.Database)
+		return nil
+	}
+
+	logf("terminated orphaned session (pid %d, %s from %s, idle %s) holding the lock for database '%s'\n",
+		holder.PID, holder.State, holder.ClientAddr, holder.Idle.Round(time.Second), config.Database)
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestUnlock(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	conn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	for _, lockMode := range []string{LockModeTable, LockModeAdvisory} {
+		config.LockMode = lockMode
+
+		// a free lock is reported as such
+		holder, err := clearLock(conn, config, time.Minute)
+		assert.NoError(t, err)
+		assert.Nil(t, holder)
+
+		holderConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+		assert.NoError(t, err)
+		var holderPID int32
+		err = holderConn.QueryRow(context.Background(), "SELECT pg_backend_pid()").Scan(&holderPID)
+		assert.NoError(t, err)
+		release, err := acquireLock(holderConn, config)
+		assert.NoError(t, err)
+
+		// a lock held by a live session is never cleared
+		holder, err = clearLock(conn, config, time.Minute)
+		assert.ErrorContains(t, err, "held by a live session")
+		assert.Equal(t, holderPID, holder.PID)
+
+		release()
+		holder, err = getLockHolder(conn, config)
+		assert.NoError(t, err)
+		assert.Nil(t, holder)
+
+		// a session which has stopped touching the server is orphaned, and is terminated
+		release, err = acquireLock(holderConn, config)
+		assert.NoError(t, err)
+		time.Sleep(200 * time.Millisecond)
+		holder, err = clearLock(conn, config, 100*time.Millisecond)
+		assert.NoError(t, err)
+		assert.Equal(t, holderPID, holder.PID)
+
+		assert.Eventually(t, func() bool {
+			holder, err := getLockHolder(conn, config)
+			return err == nil && holder == nil
+		}, 5*time.Second, 100*time.Millisecond)
+
+		release()
+		_ = holderConn.Close(context.Background())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// userAttributes returns the role attributes applied to the user on creation and kept in sync thereafter, empty
+// when none are configured
+func userAttributes(config *Config) string {
+	var attributes []string
+	if config.UserConnectionLimit != nil {
+		attributes = append(attributes, fmt.Sprintf("CONNECTION LIMIT %d", *config.UserConnectionLimit))
+	}
+	if len(config.UserValidUntil) > 0 {
+		attributes = append(attributes, fmt.Sprintf("VALID UNTIL %s", quoteLiteral(config.UserValidUntil)))
+	}
+
+	return strings.Join(attributes, " ")
+}
+
+// ensureUserMemberships grants the user membership of each of the configured group roles it is not yet a
+// member of
+func ensureUserMemberships(conn *pgx.Conn, config *Config) error {
+	for _, role := range config.UserRoleMembership {
+		var member bool
+		err := conn.QueryRow(context.Background(), "SELECT pg_has_role($1, $2, 'MEMBER')", config.Username, role).Scan(&member)
+		if err != nil {
+			return fmt.Errorf("unable to query membership of role '%s': %w", role, err)
+		}
+		if member {
+			continue
+		}
+
+		logf("granting membership of role '%s' to user '%s'\n", role, config.Username)
+		_, err = conn.Exec(context.Background(), fmt.Sprintf("GRANT %s TO %s", pgx.Identifier{role}.Sanitize(), pgx.Identifier{config.Username}.Sanitize()))
+		if err != nil {
+			return fmt.Errorf("unable to grant membership of role '%s' to user '%s': %w", role, config.Username, err)
+		}
+	}
+
+	return nil
+}
+
+// ensureSchemaCreate verifies that the user may create objects in the migration schema, which postgres 15 and
+// later no longer allow every user to do in public, granting CREATE on the schema when it is missing.  a schema
+// which does not exist yet is created by the user, and so owned by it.
+func ensureSchemaCreate(conn *pgx.Conn, config *Config) error {
+	schema := migrationSchema(config)
+	var canCreate bool
+	err := conn.QueryRow(context.Background(), "SELECT has_schema_privilege($1, n.oid, 'CREATE') FROM pg_namespace n WHERE n.nspname = $2", config.Username, schema).Scan(&canCreate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to query privileges of user '%s' on schema '%s': %w", config.Username, schema, err)
+	}
+	if canCreate {
+		return nil
+	}
+
+	logf("granting CREATE on schema '%s' to user '%s'\n", schema, config.Username)
+	_, err = conn.Exec(context.Background(), fmt.Sprintf("GRANT USAGE, CREATE ON SCHEMA %s TO %s", pgx.Identifier{schema}.Sanitize(), pgx.Identifier{config.Username}.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("user '%s' may not create objects in schema '%s' and admin user '%s' is unable to grant it, the schema's owner (or the database owner, for public) must run GRANT CREATE ON SCHEMA %s TO %s: %w",
+			config.Username, schema, config.AdminUsername, pgx.Identifier{schema}.Sanitize(), pgx.Identifier{config.Username}.Sanitize(), err)
+	}
+
+	return nil
+}
+
+// schemaPermissionHint explains a failure to create an object in a schema, which on postgres 15 and later is
+// commonly a user lacking CREATE on public, which is no longer granted to every user by default
+func schemaPermissionHint(config *Config, err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "42501" || !strings.HasPrefix(pgErr.Message, "permission denied for schema ") {
+		return err
+	}
+
+	schema := strings.TrimPrefix(pgErr.Message, "permission denied for schema ")
+	return fmt.Errorf("user '%s' may not create objects in schema '%s' (postgres 15 and later no longer grant CREATE on public to every user), grant it with GRANT CREATE ON SCHEMA %s TO %s: %w",
+		config.Username, schema, pgx.Identifier{schema}.Sanitize(), pgx.Identifier{config.Username}.Sanitize(), err)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestUserAttributes(t *testing.T) {
+	assert.Equal(t, "", userAttributes(&Config{}))
+
+	limit := 5
+	assert.Equal(t, "CONNECTION LIMIT 5", userAttributes(&Config{UserConnectionLimit: &limit}))
+	assert.Equal(t, "VALID UNTIL 'infinity'", userAttributes(&Config{UserValidUntil: "infinity"}))
+	assert.Equal(t, "CONNECTION LIMIT 5 VALID UNTIL '2030-01-01''; DROP'", userAttributes(&Config{
+		UserConnectionLimit: &limit,
+		UserValidUntil:      "2030-01-01'; DROP",
+	}))
+}
+
+func TestUserAttributesApplied(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	_, err = adminConn.Exec(context.Background(), "CREATE ROLE app_readers")
+	assert.NoError(t, err)
+	_, err = adminConn.Exec(context.Background(), "CREATE ROLE app_writers")
+	assert.NoError(t, err)
+
+	limit := 5
+	config.UserConnectionLimit = &limit
+	config.UserValidUntil = "2030-01-01 00:00:00+00"
+	config.UserRoleMembership = []string{"app_readers"}
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	var connLimit int
+	var validUntil *time.Time
+	query := "SELECT rolconnlimit, rolvaliduntil FROM pg_roles WHERE rolname = $1"
+	err = adminConn.QueryRow(context.Background(), query, config.Username).Scan(&connLimit, &validUntil)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, connLimit)
+	assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), validUntil.UTC())
+
+	var member bool
+	err = adminConn.QueryRow(context.Background(), "SELECT pg_has_role($1, 'app_readers', 'MEMBER')", config.Username).Scan(&member)
+	assert.NoError(t, err)
+	assert.True(t, member)
+
+	// the attributes of an existing user follow the configuration
+	limit = -1
+	config.UserValidUntil = "infinity"
+	config.UserRoleMembership = []string{"app_readers", "app_writers"}
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	var validUntilText string
+	err = adminConn.QueryRow(context.Background(), "SELECT rolconnlimit, rolvaliduntil::TEXT FROM pg_roles WHERE rolname = $1", config.Username).Scan(&connLimit, &validUntilText)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, connLimit)
+	assert.Equal(t, "infinity", validUntilText)
+
+	err = adminConn.QueryRow(context.Background(), "SELECT pg_has_role($1, 'app_writers', 'MEMBER')", config.Username).Scan(&member)
+	assert.NoError(t, err)
+	assert.True(t, member)
+}
+
+func TestExternalUser(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	// the user, the database and the user's privileges are all provisioned by another system
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	_, err = adminConn.Exec(context.Background(), "CREATE ROLE username LOGIN PASSWORD 'password' CONNECTION LIMIT 3")
+	assert.NoError(t, err)
+	_, err = adminConn.Exec(context.Background(), "CREATE DATABASE testdb")
+	assert.NoError(t, err)
+	dbConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	_, err = dbConn.Exec(context.Background(), "GRANT ALL ON SCHEMA public TO username")
+	assert.NoError(t, err)
+	_ = dbConn.Close(context.Background())
+
+	userState := func() string {
+		var state string
+		err := adminConn.QueryRow(context.Background(), "SELECT row_to_json(a)::TEXT || (SELECT COALESCE(datacl::TEXT, '') FROM pg_database WHERE datname = 'testdb') FROM pg_authid a WHERE rolname = 'username'").Scan(&state)
+		assert.NoError(t, err)
+		return state
+	}
+	before := userState()
+
+	config.ExternalUser = true
+	config.RegrantAlways = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, before, userState())
+
+	// a mismatched password is an error, rather than being reset
+	config.Password = "other"
+	err = doMigration(config, nil)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+	assert.Equal(t, before, userState())
+}
+
+func TestPasswordSyncedForExistingUser(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	// the user was created out of band with another password
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	_, err = adminConn.Exec(context.Background(), "CREATE ROLE username LOGIN PASSWORD 'stale'")
+	assert.NoError(t, err)
+	_ = adminConn.Close(context.Background())
+
+	config.AutoUpdatePassword = true
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	_ = conn.Close(context.Background())
+}
+
+func TestSkipUserVerify(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.SkipUserVerify = true
+	config.AutoUpdatePassword = true
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, pastMigrations, 1)
+	_ = conn.Close(context.Background())
+
+	// the trusted password is not reset when it is rejected
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl("postgres"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = adminConn.Close(context.Background())
+	}()
+	_, err = adminConn.Exec(context.Background(), "ALTER ROLE username PASSWORD 'rotated'")
+	assert.NoError(t, err)
+
+	err = doMigration(config, nil)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+
+	config.Password = "rotated"
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+}
+
+func TestSchemaCreateGranted(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	// on postgres 15 and later a fresh user may only create tables in public once granted CREATE on it
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	// a migration schema created by someone else is granted to the user likewise
+	adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl())
+	assert.NoError(t, err)
+	_, err = adminConn.Exec(context.Background(), "CREATE SCHEMA app")
+	assert.NoError(t, err)
+	_ = adminConn.Close(context.Background())
+
+	config.MigrationSchema = "app"
+	writeMigrators(t, config.Directory, map[string]string{
+		"0002_make_app_table.sql": "CREATE TABLE app.things (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	var exists bool
+	err = conn.QueryRow(context.Background(), "SELECT to_regclass('app.things') IS NOT NULL AND to_regclass('app.evo_mg') IS NOT NULL").Scan(&exists)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestSchemaPermissionHint(t *testing.T) {
+	config := &Config{Username: "app_user"}
+	err := schemaPermissionHint(config, fmt.Errorf("unable to create table: %w", &pgconn.PgError{Code: "42501", Message: "permission denied for schema public"}))
+	assert.ErrorContains(t, err, `user 'app_user' may not create objects in schema 'public'`)
+	assert.ErrorContains(t, err, `GRANT CREATE ON SCHEMA "public" TO "app_user"`)
+
+	other := &pgconn.PgError{Code: "42501", Message: "permission denied for table things"}
+	assert.Equal(t, other, schemaPermissionHint(config, other))
+}
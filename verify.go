@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// verifyDirectory is the subdirectory of the migrator directory holding verification queries
+const verifyDirectory string = "verify"
+
+// maxVerifyRows is the number of rows of a failed verification which are reported
+const maxVerifyRows = 10
+
+// ErrVerificationFailed is returned when verification queries, run after the migrators, found problems
+type ErrVerificationFailed struct {
+	// Failures maps the name of each failed verification to the reason it failed
+	Failures map[string]string
+}
+
+func (e *ErrVerificationFailed) Error() string {
+	names := make([]string, 0, len(e.Failures))
+	for name := range e.Failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d verifications failed:", len(names))
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n  %s: %s", name, e.Failures[name])
+	}
+	return b.String()
+}
+
+// verifyResult checks the rows returned by a verification query, returning why it failed, or an empty string when
+// it passed.  a query passes when it returns no rows, or a single row of a single true boolean.
+func verifyResult(columns []string, rows [][]any) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	if len(rows) == 1 && len(rows[0]) == 1 {
+		if value, ok := rows[0][0].(bool); ok {
+			if value {
+				return ""
+			}
+			return "returned false"
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "returned %d rows (%s)", len(rows), strings.Join(columns, ", "))
+	for i, row := range rows {
+		if i == maxVerifyRows {
+			fmt.Fprintf(&b, "\n    ...")
+			break
+		}
+		values := make([]string, 0, len(row))
+		for _, value := range row {
+			values = append(values, fmt.Sprintf("%v", value))
+		}
+		fmt.Fprintf(&b, "\n    %s", strings.Join(values, ", "))
+	}
+	return b.String()
+}
+
+// runVerification executes a verification query in a read only transaction, which is rolled back
+func runVerification(conn *pgx.Conn, sql string) ([]string, [][]any, error) {
+	tx, err := conn.BeginTx(context.Background(), pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	rows, err := tx.Query(context.Background(), sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for _, field := range rows.FieldDescriptions() {
+		columns = append(columns, field.Name)
+	}
+	var values [][]any
+	for rows.Next() {
+		row, err := rows.Values()
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, row)
+	}
+	return columns, values, rows.Err()
+}
+
+// verify runs each query in the verify subdirectory of the migrator directory, in name order, after the migrators
+// are applied.  each is rendered as a template like a migrator, and is expected to return no rows, or a single true
+// boolean.  every verification is run, and those which fail are reported together.
+func verify(conn *pgx.Conn, config *Config, data map[string]any) error {
+	matches, err := globMigratorFiles(config, filepath.Join(config.Directory, verifyDirectory), "*.sql")
+	if err != nil {
+		return fmt.Errorf("unable to list verifications: %w", err)
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	sort.Strings(matches)
+
+	failures := map[string]string{}
+	for _, match := range matches {
+		name := migratorName(config, match)
+		logf("running verification '%s'...\n", name)
+		rendered, err := renderMigrator(config, match, name, data)
+		if err != nil {
+			return err
+		}
+
+		columns, rows, err := runVerification(conn, rendered.SQL)
+		if err != nil {
+			failures[name] = fmt.Sprintf("error: %s", err.Error())
+			continue
+		}
+		if reason := verifyResult(columns, rows); len(reason) > 0 {
+			failures[name] = reason
+		}
+	}
+
+	if len(failures) > 0 {
+		return &ErrVerificationFailed{Failures: failures}
+	}
+	logf("%d verifications passed\n", len(matches))
+	return nil
+}
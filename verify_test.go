@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestVerifyResult(t *testing.T) {
+	assert.Equal(t, "", verifyResult([]string{"id"}, nil))
+	assert.Equal(t, "", verifyResult([]string{"ok"}, [][]any{{true}}))
+	assert.Equal(t, "returned false", verifyResult([]string{"ok"}, [][]any{{false}}))
+	assert.Equal(t, "returned 2 rows (id, name)\n    1, <nil>\n    2, <nil>", verifyResult([]string{"id", "name"}, [][]any{{1, nil}, {2, nil}}))
+}
+
+func TestVerify(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE users (id INT, email TEXT);",
+		"0002_seed.sql":       "INSERT INTO users VALUES (1, 'a@example.com'), (2, NULL);",
+	})
+	writeMigrators(t, filepath.Join(config.Directory, verifyDirectory), map[string]string{
+		"counts_match.sql":  "SELECT count(*) = 2 FROM users;",
+		"emails_filled.sql": "SELECT id, email FROM users WHERE email IS NULL;",
+	})
+	err = doMigration(config, nil)
+	var verifyErr *ErrVerificationFailed
+	assert.True(t, errors.As(err, &verifyErr))
+	assert.Equal(t, map[string]string{
+		"verify/emails_filled.sql": "returned 1 rows (id, email)\n    2, <nil>",
+	}, verifyErr.Failures)
+	assert.ErrorContains(t, err, "1 verifications failed:\n  verify/emails_filled.sql: returned 1 rows")
+
+	// once the data is fixed, the run passes
+	writeMigrators(t, config.Directory, map[string]string{
+		"0003_fill_email.sql": "UPDATE users SET email = 'b@example.com' WHERE email IS NULL;",
+	})
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// TrackByName records applied migrators by filename
+	TrackByName string = "name"
+	// TrackByVersion records applied migrators by the numeric version prefixing their filename
+	TrackByVersion string = "version"
+)
+
+var versionPrefix = regexp.MustCompile(`^[0-9]+`)
+
+// orderingPrefix matches the prefix which orders a migrator, such as a sequence number, timestamp or ulid, up to
+// the separator following it
+var orderingPrefix = regexp.MustCompile(`^([0-9][0-9A-Za-z]*)[_.-]`)
+
+// parseVersion extracts the numeric version prefixing a migrator's filename
+func parseVersion(migName string) (int64, error) {
+	prefix := versionPrefix.FindString(path.Base(migName))
+	if len(prefix) == 0 {
+		return 0, fmt.Errorf("migrator '%s' has no numeric version prefix", migName)
+	}
+
+	version, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("migrator '%s' has an invalid version prefix: %w", migName, err)
+	}
+
+	return version, nil
+}
+
+// migratorKey returns the value identifying the migrator in evo_mg, which depends on the tracking mode
+func migratorKey(config *Config, migName string) (string, error) {
+	if config.TrackBy != TrackByVersion {
+		return migName, nil
+	}
+
+	version, err := parseVersion(migName)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(version, 10), nil
+}
+
+// validateVersions ensures that no two migrators share a version when tracking by version
+func validateVersions(config *Config, migNames []string) error {
+	if config.TrackBy != TrackByVersion {
+		return nil
+	}
+
+	seen := map[int64]string{}
+	for _, migName := range migNames {
+		version, err := parseVersion(migName)
+		if err != nil {
+			return err
+		}
+		if other, ok := seen[version]; ok {
+			return fmt.Errorf("migrators '%s' and '%s' share version %d", other, migName, version)
+		}
+		seen[version] = migName
+	}
+
+	return nil
+}
+
+// validateOrdering ensures that no two pending migrators in the same directory share an ordering prefix, as
+// generated prefixes occasionally collide and would otherwise be applied in an arbitrary order
+func validateOrdering(pending []string) error {
+	prefixed := map[string][]string{}
+	var prefixes []string
+	for _, migName := range pending {
+		match := orderingPrefix.FindStringSubmatch(path.Base(migName))
+		if match == nil {
+			continue
+		}
+		prefix := path.Join(path.Dir(migName), match[1])
+		if _, ok := prefixed[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+		prefixed[prefix] = append(prefixed[prefix], migName)
+	}
+
+	var collisions []string
+	for _, prefix := range prefixes {
+		if len(prefixed[prefix]) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%s (%s)", path.Base(prefix), strings.Join(prefixed[prefix], ", ")))
+		}
+	}
+	if len(collisions) > 0 {
+		return fmt.Errorf("pending migrators share an ordering prefix, rename them so that their order is unambiguous: %s", strings.Join(collisions, "; "))
+	}
+
+	return nil
+}
+
+// getAppliedKeys returns the keys of all applied migrators, as produced by migratorKey
+func getAppliedKeys(conn *pgx.Conn, config *Config) (map[string]struct{}, error) {
+	if config.TrackBy != TrackByVersion {
+		return getPastMigrations(conn, config)
+	}
+
+	rows, err := conn.Query(context.Background(), fmt.Sprintf("SELECT version FROM %s", migratorTable(config)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to inquire for existing migrator versions: %w", err)
+	}
+	defer rows.Close()
+
+	keys := map[string]struct{}{}
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to read existing migrator version: %w", err)
+		}
+		keys[strconv.FormatInt(version, 10)] = struct{}{}
+	}
+
+	return keys, rows.Err()
+}
+
+// getCurrentVersion returns the highest applied version, the boolean is false when nothing has been applied
+func getCurrentVersion(conn *pgx.Conn, config *Config) (int64, bool, error) {
+	var version *int64
+	err := conn.QueryRow(context.Background(), fmt.Sprintf("SELECT MAX(version) FROM %s", migratorTable(config))).Scan(&version)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to query current version: %w", err)
+	}
+	if version == nil {
+		return 0, false, nil
+	}
+
+	return *version, true, nil
+}
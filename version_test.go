@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseVersion(t *testing.T) {
+	version, err := parseVersion("0042_add_b_tables.sql")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), version)
+
+	version, err = parseVersion("staging/20240101120000_seed.sql")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20240101120000), version)
+
+	_, err = parseVersion("add_tables.sql")
+	assert.Error(t, err)
+
+	err = validateVersions(&Config{TrackBy: TrackByVersion}, []string{"001_a.sql", "1_b.sql"})
+	assert.ErrorContains(t, err, "share version 1")
+}
+
+func TestValidateOrdering(t *testing.T) {
+	err := validateOrdering([]string{
+		"20240101120000123_add_users.sql",
+		"20240101120000124_add_orders.sql",
+		"01HQ3Z8J9K2M4N6P8R0T2V4X6Z_add_items.sql",
+		"seed.sql",
+		"staging/20240101120000123_seed_users.sql",
+	})
+	assert.NoError(t, err)
+
+	err = validateOrdering([]string{
+		"20240101120000123_add_users.sql",
+		"20240101120000123_add_orders.sql",
+		"20240101120000124_add_items.sql",
+	})
+	assert.ErrorContains(t, err, "20240101120000123 (20240101120000123_add_users.sql, 20240101120000123_add_orders.sql)")
+}
+
+func TestOrderingCollision(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"20240101120000000_first.sql":  "CREATE TABLE first (id INT);",
+		"20240101120000123_orders.sql": "CREATE TABLE orders (id INT);",
+		"20240101120000123_users.sql":  "CREATE TABLE users (id INT);",
+	})
+	err = doMigration(config, nil)
+	assert.ErrorContains(t, err, "20240101120000123_orders.sql, 20240101120000123_users.sql")
+
+	// nothing is applied, not even the migrators preceding the collision
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	pastMigrations, err := getPastMigrations(conn, config)
+	assert.NoError(t, err)
+	assert.Empty(t, pastMigrations)
+}
+
+func TestTrackByVersion(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	config.Directory = t.TempDir()
+	config.TrackBy = TrackByVersion
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_first.sql":  "CREATE TABLE first (id INT);",
+		"0002_second.sql": "CREATE TABLE second (id INT);",
+		"0003_third.sql":  "CREATE TABLE third (id INT);",
+	})
+
+	targetVersion := int64(2)
+	config.TargetVersion = &targetVersion
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	conn, err := pgx.Connect(context.Background(), config.GetUserConnUrl())
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	version, ok, err := getCurrentVersion(conn, config)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), version)
+
+	// renaming a file without changing its version must not cause it to be applied again
+	err = os.Rename(filepath.Join(config.Directory, "0002_second.sql"), filepath.Join(config.Directory, "0002_renamed.sql"))
+	assert.NoError(t, err)
+
+	config.TargetVersion = nil
+	err = doMigration(config, nil)
+	assert.NoError(t, err)
+
+	version, _, err = getCurrentVersion(conn, config)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), version)
+
+	status, err := getMigrationStatus(conn, config)
+	assert.NoError(t, err)
+	assert.Len(t, status.Applied, 3)
+	assert.Empty(t, status.Pending)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// migratorPollInterval is the delay between checks while waiting for a migrator to be applied
+var migratorPollInterval = 2 * time.Second
+
+// migratorApplied reports whether the migrator migName has been applied, a database without evo_mg having
+// applied nothing
+func migratorApplied(conn *pgx.Conn, config *Config, migName string) (bool, error) {
+	key, err := migratorKey(config, migName)
+	if err != nil {
+		return false, err
+	}
+
+	exists, err := migratorTableExists(conn, config)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	applied, err := getAppliedKeys(conn, config)
+	if err != nil {
+		return false, err
+	}
+	_, ok := applied[key]
+	return ok, nil
+}
+
+// waitForMigrator polls until the migrator migName has been applied by another runner, or timeout elapses.
+// until the database accepts connections, failures to connect are retried likewise, the database and user may not
+// have been created yet.  failures to query, while the database restarts or fails over say, are retried too, save
+// for a lack of privileges, which no amount of waiting fixes.
+func waitForMigrator(config *Config, migName string, timeout time.Duration) error {
+	// a name which cannot be tracked is not worth waiting for
+	_, err := migratorKey(config, migName)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	var conn *pgx.Conn
+	defer func() {
+		if conn != nil {
+			_ = conn.Close(context.Background())
+		}
+	}()
+
+	for {
+		if conn == nil {
+			conn, err = connectReadOnly(config.GetUserConnUrl())
+			if err != nil {
+				conn = nil
+				logf("unable to connect to database '%s', retrying: %s\n", config.Database, err.Error())
+			}
+		}
+		if conn != nil {
+			applied, err := migratorApplied(conn, config, migName)
+			switch {
+			case hasCode(err, "42501"):
+				return fmt.Errorf("unable to check for migrator '%s': %w", migName, err)
+			case err != nil:
+				// the connection is replaced, it may well be what failed
+				logf("unable to check for migrator '%s', retrying: %s\n", migName, err.Error())
+				_ = conn.Close(context.Background())
+				conn = nil
+			case applied:
+				logf("migrator '%s' has been applied\n", migName)
+				return nil
+			default:
+				logf("waiting for migrator '%s' to be applied\n", migName)
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("migrator '%s' was not applied within %s", migName, timeout)
+		}
+		time.Sleep(migratorPollInterval)
+	}
+}
+
+func runWait(config *Config, args []string) error {
+	flags := flag.NewFlagSet("wait", flag.ContinueOnError)
+	migName := flags.String("for", "", "name of the migrator to wait for, e.g. 0042_add_b_tables.sql")
+	timeout := flags.Duration("timeout", 5*time.Minute, "how long to wait before failing, e.g. 60s")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(*migName) == 0 {
+		return fmt.Errorf("--for is required")
+	}
+
+	logf("waiting up to %s for migrator '%s' in database '%s' (read only)\n", *timeout, *migName, config.Database)
+	return waitForMigrator(config, *migName, *timeout)
+}
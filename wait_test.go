@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestWaitForMigrator(t *testing.T) {
+	pgContainer, config, err := setupDb()
+	assert.NoError(t, err)
+	defer testcontainers.CleanupContainer(t, pgContainer)
+
+	defer func(interval time.Duration) {
+		migratorPollInterval = interval
+	}(migratorPollInterval)
+	migratorPollInterval = 100 * time.Millisecond
+
+	config.Directory = t.TempDir()
+	writeMigrators(t, config.Directory, map[string]string{
+		"0001_make_table.sql": "CREATE TABLE things (id INT);",
+	})
+
+	// nothing has been applied, not even evo_mg exists
+	err = waitForMigrator(config, "0001_make_table.sql", 500*time.Millisecond)
+	assert.ErrorContains(t, err, "was not applied within")
+
+	// the waiting survives its connection being dropped before the migrator is applied
+	migrated := make(chan error, 1)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		adminConn, err := pgx.Connect(context.Background(), config.GetAdminConnUrl(maintenanceDatabase))
+		if err == nil {
+			_, err = adminConn.Exec(context.Background(), "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE usename = $1", config.Username)
+			_ = adminConn.Close(context.Background())
+		}
+		if err != nil {
+			migrated <- err
+			return
+		}
+		time.Sleep(time.Second)
+		migrated <- doMigration(config, nil)
+	}()
+
+	err = waitForMigrator(config, "0001_make_table.sql", time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, <-migrated)
+}